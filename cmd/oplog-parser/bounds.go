@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// boundsFlags holds the flags limiting the replay to a window of the oplog.
+type boundsFlags struct {
+	fromTS    string
+	toTS      string
+	untilWall string
+}
+
+func addBoundsFlags(cmd *cobra.Command, flags *boundsFlags) {
+	cmd.Flags().StringVar(&flags.fromTS, "from-ts", "", "Apply the oplog entries from this ts, written as <seconds>.<increment> or <seconds>")
+	cmd.Flags().StringVar(&flags.toTS, "to-ts", "", "Apply the oplog entries up to this ts, included, then stop")
+	cmd.Flags().StringVar(&flags.untilWall, "until-wall-time", "", "Apply the oplog entries written up to this RFC 3339 time, included, then stop")
+}
+
+// bounds parses the flags. A ts written as <seconds> starts at the first entry of that second
+// when it is the lower bound, and ends at the last entry of that second when it is the upper bound.
+func (f boundsFlags) bounds() (domain.Bounds, error) {
+	var bounds domain.Bounds
+	var err error
+
+	if f.fromTS != "" {
+		if bounds.From, err = domain.ParseTimestamp(f.fromTS, 0); err != nil {
+			return bounds, withExitCode(exitUsage, fmt.Errorf("--from-ts: %w", err))
+		}
+	}
+	if f.toTS != "" {
+		if bounds.To, err = domain.ParseTimestamp(f.toTS, domain.MaxIncrement); err != nil {
+			return bounds, withExitCode(exitUsage, fmt.Errorf("--to-ts: %w", err))
+		}
+	}
+	if f.untilWall != "" {
+		if bounds.Until, err = time.Parse(time.RFC3339Nano, f.untilWall); err != nil {
+			return bounds, withExitCode(exitUsage, fmt.Errorf("--until-wall-time: %w", err))
+		}
+	}
+
+	if !bounds.From.IsZero() && !bounds.To.IsZero() && bounds.From.After(bounds.To) {
+		return bounds, withExitCode(exitUsage, fmt.Errorf("--from-ts %s is after --to-ts %s", bounds.From, bounds.To))
+	}
+	return bounds, nil
+}
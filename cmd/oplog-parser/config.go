@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// Flags overriding the configuration, which are applied only when set on the command line.
+var (
+	configFile   string
+	targetName   string
+	mongoURI     string
+	logLevel     string
+	logFormat    string
+	httpAddr     string
+	batchSize    int
+	includeNS    []string
+	excludeNS    []string
+	followInput  bool
+	skipBadLines bool
+)
+
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&configFile, "config", os.Getenv(config.CONFIG_FILE), "Config file (YAML, or TOML with a .toml extension)")
+	flags.StringVar(&targetName, "target", "", "Name of the configured target to write to")
+	flags.StringVar(&mongoURI, "mongo-uri", "", "MongoDB connection string")
+	flags.StringVar(&logLevel, "log-level", "", "Log level: debug, info, warn or error")
+	flags.StringVar(&logFormat, "log-format", "", "Log format: text or json")
+	flags.StringVar(&httpAddr, "http-addr", "", "Address of the metrics and health endpoints")
+	flags.IntVar(&batchSize, "batch-size", 0, "Maximum number of statements committed in a batch")
+	flags.StringSliceVar(&includeNS, "include", nil, "Regular expressions of the namespaces (db.collection) to replicate")
+	flags.StringSliceVar(&excludeNS, "exclude", nil, "Regular expressions of the namespaces (db.collection) to skip")
+}
+
+// loadConfig layers the command line flags on top of the configuration file and environment,
+// and validates the result. The selected target is validated only when requireTarget is set.
+func loadConfig(cmd *cobra.Command, requireTarget bool) (config.Config, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return cfg, withExitCode(exitUsage, err)
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("target") {
+		cfg.Target = targetName
+	}
+	if flags.Changed("mongo-uri") {
+		cfg.Mongo.URI = mongoURI
+		cfg.Mongo.URIFile = ""
+	}
+	if flags.Changed("log-level") {
+		cfg.Log.Level = logLevel
+	}
+	if flags.Changed("log-format") {
+		cfg.Log.Format = logFormat
+	}
+	if flags.Changed("http-addr") {
+		cfg.HTTPAddr = httpAddr
+	}
+	if flags.Changed("batch-size") {
+		cfg.Flush.MaxStatements = batchSize
+	}
+	if flags.Changed("include") {
+		cfg.Filter.Include = includeNS
+	}
+	if flags.Changed("exclude") {
+		cfg.Filter.Exclude = excludeNS
+	}
+
+	if flags.Changed("follow") {
+		cfg.Input.Follow = followInput
+	}
+	if flags.Changed("skip-bad-lines") {
+		cfg.Input.SkipBadLines = skipBadLines
+	}
+
+	if err := cfg.Resolve(); err != nil {
+		return cfg, withExitCode(exitUsage, err)
+	}
+	if requireTarget {
+		if err := cfg.ValidateTarget(); err != nil {
+			return cfg, withExitCode(exitUsage, err)
+		}
+	}
+	return cfg, nil
+}
+
+// namespaceFilter returns the filter of the configuration, which has been validated by loadConfig.
+func namespaceFilter(cfg config.Config) (*domain.NamespaceFilter, error) {
+	filter, err := domain.NewNamespaceFilter(cfg.Filter)
+	return filter, withExitCode(exitUsage, err)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var convertOplogFile string
+var convertSQLFile string
+var convertOrdering string
+var convertFormat string
+var convertBounds boundsFlags
+var convertOutput outputFlags
+
+func init() {
+	convertCmd.Flags().StringVarP(&convertOplogFile, "source_file", "f", "", "Source oplog file")
+	convertCmd.MarkFlagRequired("source_file")
+	addPipelineFlags(convertCmd, &convertSQLFile, &convertOrdering)
+	addSourceFlags(convertCmd, &convertFormat)
+	addBoundsFlags(convertCmd, &convertBounds)
+	addOutputFlags(convertCmd, &convertOutput)
+}
+
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert an oplog file into SQL",
+	Long: `convert reads the oplog entries of a JSON file, a mongodump oplog.bson or a mongodump
+archive, gzipped or not, and writes the equivalent SQL statements to out/<database>_<target_file>,
+one file per database, or applies them to PostgreSQL when no target file is given. With
+--output-format debezium it writes the entries as Debezium change events to JSON lines files instead,
+and with --output-format csv it exports the rows to CSV files in out/<target_file>, along with the
+DDL and a psql script loading them with \copy.`,
+	Example: `  oplog2sql convert -f example-input.json -o output.sql
+  oplog2sql convert -f dump/oplog.bson -o output.sql
+  oplog2sql convert -f dump.archive.gz --format archive -o output.sql
+  oplog2sql convert -f example-input.json --output-format debezium -o events.jsonl
+  oplog2sql convert -f dump.archive.gz --output-format csv -o export`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOrdering(convertOrdering); err != nil {
+			return err
+		}
+		if err := convertOutput.validate(convertSQLFile); err != nil {
+			return err
+		}
+		bounds, err := convertBounds.bounds()
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig(cmd, convertSQLFile == "")
+		if err != nil {
+			return err
+		}
+		filter, err := namespaceFilter(cfg)
+		if err != nil {
+			return err
+		}
+
+		logger := logging.NewLogger(cfg.Log)
+		oplogReader, err := newFileReader(convertOplogFile, convertFormat, cfg, bounds, filter, logger)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := startCommand(cfg, logger)
+		defer cancel()
+
+		p := pipeline{
+			cfg:      cfg,
+			logger:   logger,
+			ordering: convertOrdering,
+			sqlFile:  convertSQLFile,
+			output:   convertOutput,
+		}
+		err = p.run(ctx, oplogReader)
+		if err != nil {
+			return withExitCode(exitFailure, fmt.Errorf("oplog file could not be converted: %w", err))
+		}
+		return nil
+	},
+}
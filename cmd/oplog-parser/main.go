@@ -1,117 +1,69 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
 
-	"github.com/one2nc/mongo-oplog-to-sql/config"
 	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
-	"github.com/one2nc/mongo-oplog-to-sql/internal/reader"
-	"github.com/one2nc/mongo-oplog-to-sql/internal/service"
-	"github.com/one2nc/mongo-oplog-to-sql/internal/writer"
 	"github.com/spf13/cobra"
 )
 
-func main() {
-	err := rootCmd.Execute()
-	if err != nil {
-		os.Exit(1)
-	}
-}
-
-var oplogFile string
-var sqlFile string
+// Exit codes of the commands, following sysexits.h for usage and data errors.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 64
+	exitInvalid = 65
+)
 
-func init() {
-	rootCmd.Flags().StringVarP(&oplogFile, "source_file", "f", "", "Source oplog file")
-	rootCmd.Flags().StringVarP(&sqlFile, "target_file", "o", "", "Target SQL file")
+// exitError is an error of a command along with the code the process exits with.
+type exitError struct {
+	code int
+	err  error
 }
 
-var rootCmd = &cobra.Command{
-	Use:   "oplog2sql",
-	Short: "A utility for parsing MongoDB's oplog and translating it into equivalent SQL statements",
-	Long:  `oplog2sql is a powerful utility that allows you to parse the oplog data from MongoDB and effortlessly translate it into SQL statements. With this tool, you can seamlessly migrate your data from MongoDB to a SQL-based database system while preserving the integrity and structure of your data. Say goodbye to manual migration efforts and let oplog2sql automate the process for you.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if !cmd.Flags().HasFlags() {
-			cmd.Usage()
-			return
-		}
-
-		// Create a context that will be cancelled on interrupt signal
-		ctx, cancel := context.WithCancel(context.Background())
-
-		// Handle interrupt signal
-		handleInterruptSignal(cancel)
-
-		cfg := config.Load()
-
-		publisher := domain.NewInMemoryOplogPublisher()
-
-		// Create a reader to read the oplogs
-		oplogReader := createReader(oplogFile, cfg.MongoURI)
-
-		// Start reading Oplog entries in a separate goroutine
-		go oplogReader.ReadOplogs(ctx, publisher)
-
-		// Get oplogs from publisher
-		oplogChan, err := publisher.GetOplogs()
-		if err != nil {
-			cancel()
-			return
-		}
-
-		// Create a service to process the oplogs
-		oplogService := service.NewOplogService(ctx, domain.NewDefaultUUIDGenerator())
-
-		// Uncomment below line for serial implementation
-		// sqlChan := oplogService.ProcessOplogs(oplogChan, cancel)
-
-		// Comment below line for serial implementation
-		sqlChan := oplogService.ProcessOplogsConcurrent(oplogChan, cancel)
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
 
-		var wg sync.WaitGroup
-		for sqlStmt := range sqlChan {
-			wg.Add(1)
-			go func(sqlStmt domain.SQLStatement) {
-				defer wg.Done()
-				// Create a writer to write the sql statements
-				sqlWriter := createWriter(sqlFile, sqlStmt.GetDBName(), cfg.DBConfig)
-				sqlWriter.WriteSQL(ctx, sqlStmt.GetChannel())
-			}(sqlStmt)
-		}
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
 
-		wg.Wait()
-	},
+func main() {
+	os.Exit(execute())
 }
 
-func handleInterruptSignal(cancel context.CancelFunc) {
-	// Create an interrupt channel to listen for the interrupt signal
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+func execute() int {
+	err := rootCmd.Execute()
+	if err == nil {
+		return exitOK
+	}
 
-	go func() {
-		<-interrupt
-		fmt.Println("Interrupt signal received. Gracefully stopping...")
+	fmt.Fprintln(os.Stderr, "Error:", err)
 
-		// Cancel the context to signal the shutdown
-		cancel()
-	}()
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	// errors which are not returned by a command come from parsing the command line
+	return exitUsage
 }
 
-func createReader(oplogFile, mongoConnectionStr string) reader.OplogReader {
-	if oplogFile != "" {
-		return reader.NewFileReader(oplogFile)
-	}
-	return reader.NewMongoReader(mongoConnectionStr)
+func init() {
+	rootCmd.AddCommand(tailCmd, convertCmd, snapshotCmd, schemaCmd, validateCmd, replayCmd)
 }
 
-func createWriter(sqlFile, schemaName string, dbCfg config.DBConfig) writer.SQLWriter {
-	if sqlFile != "" {
-		return writer.NewFileWriter(fmt.Sprintf("out/%s_%s", schemaName, sqlFile))
-	}
-	return writer.NewPostgresWriter(dbCfg)
+var rootCmd = &cobra.Command{
+	Use:   "oplog2sql",
+	Short: "A utility for parsing MongoDB's oplog and translating it into equivalent SQL statements",
+	Long:  `oplog2sql is a powerful utility that allows you to parse the oplog data from MongoDB and effortlessly translate it into SQL statements. With this tool, you can seamlessly migrate your data from MongoDB to a SQL-based database system while preserving the integrity and structure of your data. Say goodbye to manual migration efforts and let oplog2sql automate the process for you.`,
+
+	Version:       domain.BuildVersion(),
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats of the pipeline.
+const (
+	outputSQL      = "sql"
+	outputDebezium = "debezium"
+	outputCSV      = "csv"
+)
+
+// Splits of the change events between files.
+const (
+	splitTable    = "table"
+	splitCombined = "combined"
+)
+
+// outputFlags holds the flags selecting what the pipeline writes.
+type outputFlags struct {
+	format     string
+	split      string
+	serverName string
+}
+
+func addOutputFlags(cmd *cobra.Command, flags *outputFlags) {
+	cmd.Flags().StringVar(&flags.format, "output-format", outputSQL, "Output format: 'sql' statements, 'debezium' change events as JSON lines written to the target file, or 'csv' files of the rows of every table exported to the out/<target_file> directory")
+	cmd.Flags().StringVar(&flags.split, "split", splitTable, "Files of the change events: 'table' for out/<db>.<collection>_<target_file>, or 'combined' for out/<target_file>")
+	cmd.Flags().StringVar(&flags.serverName, "server-name", "oplog2sql", "Logical server name of the change events, the prefix of their Debezium topics")
+}
+
+// validate checks the flags, given the target file of the command.
+func (f outputFlags) validate(targetFile string) error {
+	switch f.format {
+	case outputSQL:
+		return nil
+	case outputDebezium, outputCSV:
+	default:
+		return withExitCode(exitUsage, fmt.Errorf("invalid output format %q", f.format))
+	}
+
+	if targetFile == "" {
+		return withExitCode(exitUsage, fmt.Errorf("--output-format %s requires a target file", f.format))
+	}
+	if f.split != splitTable && f.split != splitCombined {
+		return withExitCode(exitUsage, fmt.Errorf("invalid split %q", f.split))
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/reader"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/service"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/writer"
+	"github.com/spf13/cobra"
+)
+
+// eventWriterName identifies the change event writer in the checkpoint coordinator.
+const eventWriterName = "events"
+
+// pipeline reads oplogs, converts them into SQL statements and writes them to SQL files
+// or, when sqlFile is empty, to the postgres DB.
+type pipeline struct {
+	cfg         config.Config
+	logger      *slog.Logger
+	ordering    string
+	sqlFile     string
+	coordinator *domain.CheckpointCoordinator
+	// publisher buffers the oplogs read, in memory when nil
+	publisher domain.OplogPublisher
+	output    outputFlags
+	// upsertUntil is the ts up to which the oplogs may already be applied to the target,
+	// whose inserts overwrite the rows of their document
+	upsertUntil domain.Timestamp
+}
+
+func (p pipeline) run(ctx context.Context, oplogReader reader.OplogReader) error {
+	publisher := p.publisher
+	if publisher == nil {
+		publisher = domain.NewInMemoryOplogPublisher(p.cfg.Pipeline.Buffers.Oplogs, metrics.Recorder{})
+	}
+
+	// The service cancels the pipeline context once processing stops, which stops the
+	// reader, while the writers drain the remaining statements until the context is cancelled
+	pipelineCtx, pipelineCancel := context.WithCancel(ctx)
+	defer pipelineCancel()
+
+	// The publisher is closed with the pipeline, which releases a reader waiting for room in a
+	// full buffer or spool once the writers have stopped
+	if closer, ok := publisher.(io.Closer); ok {
+		go func() {
+			<-pipelineCtx.Done()
+			closer.Close()
+		}()
+	}
+
+	// Start reading Oplog entries in a separate goroutine
+	readErrChan := make(chan error, 1)
+	go func() {
+		readErrChan <- oplogReader.ReadOplogs(pipelineCtx, publisher)
+	}()
+
+	// Get oplogs from publisher
+	oplogChan, err := publisher.GetOplogs()
+	if err != nil {
+		return err
+	}
+
+	if !p.upsertUntil.IsZero() {
+		oplogChan = markUpserts(oplogChan, p.upsertUntil)
+	}
+
+	if p.coordinator != nil {
+		// the oplogs of a database are applied in the streams of their collection
+		oplogChan = p.coordinator.Track(oplogChan, func(oplog domain.OplogEntry) string {
+			if p.output.format == outputDebezium {
+				return domain.StreamKey(eventWriterName, "")
+			}
+			if p.ordering == service.OrderingStrict {
+				return domain.StreamKey(service.SerialDatabaseName, "")
+			}
+			return domain.StreamKey(oplog.DatabaseName(), oplog.Namespace)
+		})
+	}
+
+	// Change events are written straight from the oplogs, in oplog order
+	if p.output.format == outputDebezium {
+		eventWriter := writer.NewChangeEventWriter(
+			fmt.Sprintf("out/%s", p.sqlFile),
+			p.output.serverName,
+			p.output.split == splitTable,
+			eventWriterName,
+			p.coordinator,
+			p.logger,
+		)
+		err := eventWriter.WriteEvents(ctx, oplogChan)
+		pipelineCancel()
+		if readErr := readError(readErrChan); err == nil {
+			err = readErr
+		}
+		return errors.Join(err, publisherErr(publisher))
+	}
+
+	// Create a service to process the oplogs
+	oplogService := service.NewOplogService(pipelineCtx, domain.NewDefaultUUIDGenerator(), p.cfg.Pipeline, metrics.Recorder{}, p.logger)
+
+	var sqlChan chan domain.SQLStatement
+	if p.ordering == service.OrderingStrict {
+		sqlChan = oplogService.ProcessOplogs(oplogChan, pipelineCancel)
+	} else {
+		sqlChan = oplogService.ProcessOplogsConcurrent(oplogChan, pipelineCancel)
+	}
+
+	// The rows of all the databases are exported to the files of a single CSV export
+	var csvExport *writer.CSVExport
+	if p.output.format == outputCSV {
+		if csvExport, err = writer.NewCSVExport(filepath.Join("out", p.sqlFile), p.logger); err != nil {
+			return err
+		}
+	}
+
+	// The first writer to fail stops the pipeline, and the statements left for it are drained
+	// so that the service is never blocked publishing them
+	var writeErr error
+	var writeErrOnce sync.Once
+	var wg sync.WaitGroup
+	for sqlStmt := range sqlChan {
+		wg.Add(1)
+		go func(sqlStmt domain.SQLStatement) {
+			defer wg.Done()
+			// Create a writer to write the sql statements
+			var sqlWriter writer.SQLWriter = csvExport
+			var err error
+			if csvExport == nil {
+				sqlWriter, err = p.createWriter(sqlStmt.GetDBName())
+			}
+			if err == nil {
+				err = sqlWriter.WriteSQL(ctx, sqlStmt.GetChannel())
+			}
+			if err == nil {
+				return
+			}
+
+			writeErrOnce.Do(func() {
+				writeErr = fmt.Errorf("writer of %s failed: %w", sqlStmt.GetDBName(), err)
+				pipelineCancel()
+			})
+			for range sqlStmt.GetChannel() {
+			}
+		}(sqlStmt)
+	}
+
+	wg.Wait()
+	if csvExport != nil {
+		if err := csvExport.Close(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+	if readErr := readError(readErrChan); writeErr == nil {
+		writeErr = readErr
+	}
+	return errors.Join(writeErr, publisherErr(publisher))
+}
+
+// readError waits for the reader to stop and returns its error. A reader stopped by closing the
+// publisher along with the pipeline stops as it does on the context being done.
+func readError(readErrChan <-chan error) error {
+	if err := <-readErrChan; !errors.Is(err, domain.ErrPublisherClosed) {
+		return err
+	}
+	return nil
+}
+
+// publisherErr returns the error which stopped a disk-backed publisher from delivering the
+// oplogs, which otherwise ends the pipeline as if the reader had stopped.
+func publisherErr(publisher domain.OplogPublisher) error {
+	if spool, ok := publisher.(*domain.DiskOplogPublisher); ok {
+		return spool.Err()
+	}
+	return nil
+}
+
+// markUpserts flags the oplogs up to the given ts as upserts.
+func markUpserts(oplogChan <-chan domain.OplogEntry, until domain.Timestamp) <-chan domain.OplogEntry {
+	marked := make(chan domain.OplogEntry)
+	go func() {
+		defer close(marked)
+		for oplog := range oplogChan {
+			oplog.Upsert = !oplog.Timestamp.After(until)
+			marked <- oplog
+		}
+	}()
+	return marked
+}
+
+func (p pipeline) createWriter(schemaName string) (writer.SQLWriter, error) {
+	if p.sqlFile != "" {
+		return writer.NewFileWriter(fmt.Sprintf("out/%s_%s", schemaName, p.sqlFile), schemaName, p.coordinator, p.logger), nil
+	}
+	return writer.NewPostgresWriter(
+		p.cfg.DBConfig,
+		retry.NewPolicy(p.cfg.Retry),
+		p.cfg.Flush,
+		schemaName,
+		p.coordinator,
+		metrics.Recorder{},
+		health.Recorder{},
+		p.logger,
+	)
+}
+
+// addPipelineFlags adds the flags shared by the commands running a pipeline.
+func addPipelineFlags(cmd *cobra.Command, sqlFile, ordering *string) {
+	cmd.Flags().StringVarP(sqlFile, "target_file", "o", "", "Target SQL file, the statements are applied to PostgreSQL when empty")
+	cmd.Flags().StringVar(ordering, "ordering", service.OrderingPerDocument, "Ordering guarantee, either 'document' (concurrent) or 'strict' (global oplog order)")
+}
+
+func validateOrdering(ordering string) error {
+	if ordering != service.OrderingPerDocument && ordering != service.OrderingStrict {
+		return withExitCode(exitUsage, fmt.Errorf("invalid ordering %q", ordering))
+	}
+	return nil
+}
+
+// startCommand creates the context of a long running command, which is cancelled on interrupt
+// signal, and starts the metrics and health endpoints when configured.
+func startCommand(cfg config.Config, logger *slog.Logger) (context.Context, context.CancelFunc) {
+	// Create a context that will be cancelled on interrupt signal
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Handle interrupt signal
+	handleInterruptSignal(cancel, logger)
+
+	if cfg.HTTPAddr != "" {
+		go serveHTTP(ctx, cfg.HTTPAddr, logger)
+	}
+	return ctx, cancel
+}
+
+// serveHTTP exposes the metrics and health endpoints on addr until the context is done.
+func serveHTTP(ctx context.Context, addr string, logger *slog.Logger) {
+	mux := http.NewServeMux()
+	metrics.Register(mux)
+	health.Register(mux)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	logger.Info("serving metrics and health endpoints", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("http server stopped", "error", err)
+	}
+}
+
+func handleInterruptSignal(cancel context.CancelFunc, logger *slog.Logger) {
+	// Create an interrupt channel to listen for the interrupt signal
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-interrupt
+		logger.Info("interrupt signal received, gracefully stopping")
+
+		// Cancel the context to signal the shutdown
+		cancel()
+	}()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+)
+
+// endlessReader publishes inserts until the publisher rejects them.
+type endlessReader struct{}
+
+func (endlessReader) ReadOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
+	defer publisher.Stop()
+
+	for i := uint32(1); ; i++ {
+		entry := domain.OplogEntry{
+			Operation: "i",
+			Namespace: "shop.orders",
+			Object:    map[string]interface{}{"_id": int(i), "total": 10},
+			Timestamp: domain.Timestamp{T: i, I: 1},
+		}
+		if err := publisher.PublishOplog(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func TestPipelineStopsWhenWriterFails(t *testing.T) {
+	cfg := config.Default()
+	cfg.Pipeline.Buffers = config.BufferConfig{Oplogs: 1, Databases: 1, Collections: 1, Statements: 1}
+
+	// the file of the writer cannot be created, so the writer fails while the reader is still
+	// waiting for room in the full oplog buffer
+	p := pipeline{
+		cfg:     cfg,
+		logger:  logging.NewNopLogger(),
+		sqlFile: "missing/orders.sql",
+		output:  outputFlags{format: outputSQL},
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.run(context.Background(), endlessReader{})
+	}()
+
+	select {
+	case err := <-errChan:
+		if err == nil || !strings.Contains(err.Error(), "writer of shop failed") {
+			t.Errorf("Pipeline error does not match the expected result.\nWant: %s\nGot: %v", "writer of shop failed", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("pipeline did not stop after its writer failed")
+	}
+}
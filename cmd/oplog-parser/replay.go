@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/writer"
+	"github.com/spf13/cobra"
+)
+
+var replaySQLFile string
+
+func init() {
+	replayCmd.Flags().StringVarP(&replaySQLFile, "source_file", "f", "", "Source SQL file, with statements terminated by semicolons")
+	replayCmd.MarkFlagRequired("source_file")
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Apply an SQL file to PostgreSQL",
+	Long: `replay executes the statements of an SQL file written by convert or tail, each terminated by a
+semicolon, against PostgreSQL in batches, with the same retries as tail.`,
+	Example: "  oplog2sql replay -f out/student_output.sql",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig(cmd, true)
+		if err != nil {
+			return err
+		}
+
+		sqlFile, err := os.Open(replaySQLFile)
+		if err != nil {
+			return withExitCode(exitFailure, err)
+		}
+		defer sqlFile.Close()
+
+		logger := logging.NewLogger(cfg.Log)
+		ctx, cancel := startCommand(cfg, logger)
+		defer cancel()
+
+		sqlStmt := domain.NewSQLStatement("replay", cfg.Pipeline.Buffers.Statements, metrics.Recorder{})
+		readErrChan := make(chan error, 1)
+		go func() {
+			defer sqlStmt.Close()
+			readErrChan <- readStatements(ctx, sqlFile, func(query string) {
+				sqlStmt.Publish(query, domain.Timestamp{})
+				// every statement is a boundary at which a batch may be committed
+				sqlStmt.PublishCheckpoint(domain.Timestamp{})
+			})
+		}()
+
+		// the statements left once the writer stops are drained, so that the reading stops
+		drain := func() error {
+			for range sqlStmt.GetChannel() {
+			}
+			if err := <-readErrChan; err != nil {
+				return fmt.Errorf("SQL file could not be read: %w", err)
+			}
+			return nil
+		}
+
+		sqlWriter, err := writer.NewPostgresWriter(cfg.DBConfig, retry.NewPolicy(cfg.Retry), cfg.Flush, "replay", nil, metrics.Recorder{}, health.Recorder{}, logger)
+		if err != nil {
+			cancel()
+			drain()
+			return withExitCode(exitFailure, err)
+		}
+		if err := sqlWriter.WriteSQL(ctx, sqlStmt.GetChannel()); err != nil {
+			cancel()
+			drain()
+			return withExitCode(exitFailure, err)
+		}
+		if err := drain(); err != nil {
+			return withExitCode(exitFailure, err)
+		}
+
+		return withExitCode(exitFailure, ctx.Err())
+	},
+}
+
+// readStatements reads the statements of an SQL file until its end or the context is done, and
+// publishes each of them with its terminating semicolon. Statements may span several lines, and
+// the semicolons within quoted strings, quoted identifiers, dollar-quoted strings and comments
+// do not end them. Comments are left out, and a last statement without semicolon is published
+// as is.
+func readStatements(ctx context.Context, r io.Reader, publish func(query string)) error {
+	reader := bufio.NewReader(r)
+	var sb strings.Builder
+	// quote is the closing delimiter of the quoted text or comment being read, if any
+	var quote string
+
+	flush := func() {
+		if query := strings.TrimSpace(sb.String()); query != "" && query != ";" {
+			publish(query)
+		}
+		sb.Reset()
+	}
+
+	for ctx.Err() == nil {
+		c, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			if quote != "" && quote != "\n" {
+				return fmt.Errorf("unterminated %s at the end of the file", describeQuote(quote))
+			}
+			flush()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch quote {
+		case "":
+		case "\n":
+			// a line comment ends with its line, which is kept as the separator of the tokens
+			if c == '\n' {
+				quote = ""
+				sb.WriteByte(c)
+			}
+			continue
+		case "*/":
+			if c == '*' && peek(reader, '/') {
+				reader.ReadByte()
+				quote = ""
+				sb.WriteByte(' ')
+			}
+			continue
+		default:
+			sb.WriteByte(c)
+			if c != quote[0] {
+				continue
+			}
+			if len(quote) > 1 {
+				// the rest of the tag of a dollar quote
+				if rest, err := reader.Peek(len(quote) - 1); err == nil && string(rest) == quote[1:] {
+					reader.Discard(len(rest))
+					sb.Write(rest)
+					quote = ""
+				}
+				continue
+			}
+			// a doubled quote is an escaped one
+			if peek(reader, c) {
+				reader.ReadByte()
+				sb.WriteByte(c)
+				continue
+			}
+			quote = ""
+			continue
+		}
+
+		switch {
+		case c == '\'' || c == '"':
+			quote = string(c)
+		case c == '-' && peek(reader, '-'):
+			quote = "\n"
+			continue
+		case c == '/' && peek(reader, '*'):
+			reader.ReadByte()
+			quote = "*/"
+			continue
+		case c == '$':
+			if tag, ok := dollarTag(reader); ok {
+				reader.Discard(len(tag) - 1)
+				sb.WriteString(tag)
+				quote = tag
+				continue
+			}
+		case c == ';':
+			sb.WriteByte(c)
+			flush()
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return nil
+}
+
+// peek reports whether the next byte of the reader is c.
+func peek(reader *bufio.Reader, c byte) bool {
+	next, err := reader.Peek(1)
+	return err == nil && next[0] == c
+}
+
+// dollarTag returns the tag opening a dollar-quoted string, such as $$ or $body$, when the bytes
+// following a $ complete one.
+func dollarTag(reader *bufio.Reader) (string, bool) {
+	for n := 1; ; n++ {
+		next, err := reader.Peek(n)
+		if err != nil || len(next) < n {
+			return "", false
+		}
+		c := next[n-1]
+		switch {
+		case c == '$':
+			return "$" + string(next), true
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || n > 1 && c >= '0' && c <= '9':
+		default:
+			return "", false
+		}
+	}
+}
+
+func describeQuote(quote string) string {
+	switch quote {
+	case "'":
+		return "quoted string"
+	case "\"":
+		return "quoted identifier"
+	case "*/":
+		return "comment"
+	}
+	return "dollar-quoted string"
+}
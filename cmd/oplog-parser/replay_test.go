@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Statement per line",
+			sql:  "CREATE SCHEMA IF NOT EXISTS test;\nINSERT INTO test.student (_id) VALUES ('1');\n",
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"INSERT INTO test.student (_id) VALUES ('1');",
+			},
+		},
+		{
+			name: "Statement spanning lines",
+			sql:  "INSERT INTO test.student (_id, name)\nVALUES ('1', 'Selena');",
+			want: []string{"INSERT INTO test.student (_id, name)\nVALUES ('1', 'Selena');"},
+		},
+		{
+			name: "Semicolons and newlines in quotes",
+			sql:  "INSERT INTO test.student (_id, \"a;b\") VALUES ('1', 'it''s; a\nline');UPDATE test.student SET name = 'x';",
+			want: []string{
+				"INSERT INTO test.student (_id, \"a;b\") VALUES ('1', 'it''s; a\nline');",
+				"UPDATE test.student SET name = 'x';",
+			},
+		},
+		{
+			name: "Dollar quotes",
+			sql:  "SELECT $$a;b$$, $tag$c;$$;d$tag$;SELECT 1;",
+			want: []string{"SELECT $$a;b$$, $tag$c;$$;d$tag$;", "SELECT 1;"},
+		},
+		{
+			name: "Comments",
+			sql:  "-- header; with a semicolon\nSELECT 1; /* a; b */ SELECT 2;\n-- trailer",
+			want: []string{"SELECT 1;", "SELECT 2;"},
+		},
+		{
+			name: "Last statement without semicolon",
+			sql:  "SELECT 1;\nSELECT 2\n",
+			want: []string{"SELECT 1;", "SELECT 2"},
+		},
+		{
+			name: "Statement longer than a scanner line",
+			sql:  "INSERT INTO test.student (name) VALUES ('" + strings.Repeat("a", 128*1024) + "');",
+			want: []string{"INSERT INTO test.student (name) VALUES ('" + strings.Repeat("a", 128*1024) + "');"},
+		},
+		{
+			name:    "Unterminated string",
+			sql:     "INSERT INTO test.student (name) VALUES ('Selena);",
+			want:    []string{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			err := readStatements(context.Background(), strings.NewReader(tt.sql), func(query string) {
+				got = append(got, query)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readStatements() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Statements do not match the expected result.\nWant: %q\nGot: %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/service"
+	"github.com/spf13/cobra"
+)
+
+var schemaOplogFile string
+var schemaSQLFile string
+var schemaFormat string
+
+func init() {
+	schemaCmd.Flags().StringVarP(&schemaOplogFile, "source_file", "f", "", "Source oplog file")
+	schemaCmd.MarkFlagRequired("source_file")
+	addSourceFlags(schemaCmd, &schemaFormat)
+	schemaCmd.Flags().StringVarP(&schemaSQLFile, "target_file", "o", "", "Target SQL file, the DDL is printed to stdout when empty")
+}
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the DDL inferred from an oplog file",
+	Long: `schema reads the oplog entries of a source file, in any format accepted by convert, and prints only the CREATE SCHEMA, CREATE TABLE
+and ALTER TABLE statements needed to hold them, in oplog order.`,
+	Example: "  oplog2sql schema -f example-input.json",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig(cmd, false)
+		if err != nil {
+			return err
+		}
+		filter, err := namespaceFilter(cfg)
+		if err != nil {
+			return err
+		}
+		logger := logging.NewLogger(cfg.Log)
+		oplogReader, err := newFileReader(schemaOplogFile, schemaFormat, cfg, domain.Bounds{}, filter, logger)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if schemaSQLFile != "" {
+			outputFile, err := os.Create(schemaSQLFile)
+			if err != nil {
+				return withExitCode(exitFailure, err)
+			}
+			defer outputFile.Close()
+			out = outputFile
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		publisher := domain.NewInMemoryOplogPublisher(cfg.Pipeline.Buffers.Oplogs, metrics.Recorder{})
+		readErrChan := make(chan error, 1)
+		go func() {
+			readErrChan <- oplogReader.ReadOplogs(ctx, publisher)
+		}()
+
+		oplogChan, err := publisher.GetOplogs()
+		if err != nil {
+			return withExitCode(exitFailure, err)
+		}
+
+		oplogService := service.NewOplogService(ctx, domain.NewDefaultUUIDGenerator(), cfg.Pipeline, metrics.Recorder{}, logger)
+		for sqlStmt := range oplogService.ProcessOplogs(oplogChan, func() {}) {
+			if err := writeDDL(out, sqlStmt.GetChannel()); err != nil {
+				return withExitCode(exitFailure, err)
+			}
+		}
+
+		return withExitCode(exitFailure, <-readErrChan)
+	},
+}
+
+func writeDDL(out io.Writer, sqlChan <-chan domain.SQLCommand) error {
+	for sqlCmd := range sqlChan {
+		if strings.HasPrefix(sqlCmd.Query, "CREATE ") || strings.HasPrefix(sqlCmd.Query, "ALTER ") {
+			if _, err := fmt.Fprintln(out, sqlCmd.Query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/reader"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/writer"
+	"github.com/spf13/cobra"
+)
+
+var snapshotSQLFile string
+var snapshotOrdering string
+
+func init() {
+	addPipelineFlags(snapshotCmd, &snapshotSQLFile, &snapshotOrdering)
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Copy the current documents of a running MongoDB to PostgreSQL",
+	Long: `snapshot reads every document of the user databases of the MongoDB configured by mongo.uri
+and inserts it into PostgreSQL, or writes the inserts to SQL files with -o. When applied to
+PostgreSQL, the oplog position at the start of the snapshot is saved as the checkpoint, so
+that a following tail replays the changes made while the snapshot was running, as upserts
+of the documents which the snapshot may already hold.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOrdering(snapshotOrdering); err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig(cmd, snapshotSQLFile == "")
+		if err != nil {
+			return err
+		}
+		filter, err := namespaceFilter(cfg)
+		if err != nil {
+			return err
+		}
+
+		logger := logging.NewLogger(cfg.Log)
+		ctx, cancel := startCommand(cfg, logger)
+		defer cancel()
+
+		start, err := reader.LatestTimestamp(ctx, cfg.Mongo, logger)
+		if err != nil {
+			return withExitCode(exitFailure, err)
+		}
+
+		p := pipeline{
+			cfg:      cfg,
+			logger:   logger,
+			ordering: snapshotOrdering,
+			sqlFile:  snapshotSQLFile,
+		}
+		if err := p.run(ctx, reader.NewSnapshotReader(cfg.Mongo, filter, logger)); err != nil {
+			return withExitCode(exitFailure, err)
+		}
+		if ctx.Err() != nil {
+			return withExitCode(exitFailure, ctx.Err())
+		}
+
+		// the changes made while the documents were read are replayed by the following tail,
+		// as upserts up to the end of the snapshot
+		end, err := reader.LatestTimestamp(ctx, cfg.Mongo, logger)
+		if err != nil {
+			return withExitCode(exitFailure, err)
+		}
+
+		logger.Info("snapshot completed", "start", start.String(), "end", end.String())
+		if snapshotSQLFile == "" {
+			err := writer.SaveCheckpoints(cfg.DBConfig, retry.NewPolicy(cfg.Retry), map[string]domain.Timestamp{
+				writer.GlobalCheckpoint:   start,
+				writer.SnapshotCheckpoint: end,
+			})
+			return withExitCode(exitFailure, err)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/reader"
+	"github.com/spf13/cobra"
+)
+
+// Formats of the source files.
+const (
+	formatAuto    = "auto"
+	formatJSON    = "json"
+	formatBSON    = "bson"
+	formatArchive = "archive"
+)
+
+// addSourceFlags adds the flags selecting the format of the source file and how it is read.
+func addSourceFlags(cmd *cobra.Command, format *string) {
+	cmd.Flags().StringVar(format, "format", formatAuto, "Source file format: 'json' (array or JSON lines), 'bson' (mongodump oplog.bson), 'archive' (mongodump --archive) or 'auto' to detect it from the extension")
+	cmd.Flags().BoolVar(&followInput, "follow", false, "Wait for new JSON entries at the end of the source file, like tail -f")
+	cmd.Flags().BoolVar(&skipBadLines, "skip-bad-lines", false, "Log and skip the JSON entries which cannot be decoded")
+}
+
+// newFileReader creates the reader of the source file, which is the standard input when it is "-".
+// Compressed files are detected from their content.
+func newFileReader(
+	filePath string,
+	format string,
+	cfg config.Config,
+	bounds domain.Bounds,
+	filter *domain.NamespaceFilter,
+	logger *slog.Logger,
+) (reader.OplogReader, error) {
+	if format == formatAuto {
+		format = detectFormat(filePath)
+	}
+
+	switch format {
+	case formatJSON:
+		return reader.NewFileReader(filePath, cfg.Input, bounds, filter, logger), nil
+	case formatBSON:
+		return reader.NewBSONReader(filePath, bounds, filter, logger), nil
+	case formatArchive:
+		// the documents of the dumped collections have no ts to bound
+		if bounds != (domain.Bounds{}) {
+			return nil, withExitCode(exitUsage, fmt.Errorf("bounds are not supported for archives"))
+		}
+		return reader.NewArchiveReader(filePath, filter, logger), nil
+	default:
+		return nil, withExitCode(exitUsage, fmt.Errorf("invalid format %q", format))
+	}
+}
+
+func detectFormat(filePath string) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(filePath), ".gz"), ".zst")
+	ext := filepath.Ext(name)
+	switch ext {
+	case ".bson":
+		return formatBSON
+	case ".archive", ".agz":
+		return formatArchive
+	default:
+		return formatJSON
+	}
+}
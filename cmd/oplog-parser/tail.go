@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/reader"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/writer"
+	"github.com/spf13/cobra"
+)
+
+var tailSQLFile string
+var tailOrdering string
+var tailBounds boundsFlags
+var tailOutput outputFlags
+
+func init() {
+	addPipelineFlags(tailCmd, &tailSQLFile, &tailOrdering)
+	addBoundsFlags(tailCmd, &tailBounds)
+	addOutputFlags(tailCmd, &tailOutput)
+}
+
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Tail the oplog of a running MongoDB and apply it to PostgreSQL",
+	Long: `tail follows the oplog of the MongoDB configured by mongo.uri and applies the equivalent SQL
+statements to PostgreSQL until it is interrupted. The position committed by every writer is
+checkpointed in PostgreSQL, so that a restart resumes exactly where the previous run stopped.
+With -o the statements are written to SQL files instead, without checkpoints, or the entries as
+Debezium change events with --output-format debezium.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateOrdering(tailOrdering); err != nil {
+			return err
+		}
+		if err := tailOutput.validate(tailSQLFile); err != nil {
+			return err
+		}
+		bounds, err := tailBounds.bounds()
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadConfig(cmd, tailSQLFile == "")
+		if err != nil {
+			return err
+		}
+		filter, err := namespaceFilter(cfg)
+		if err != nil {
+			return err
+		}
+		if cfg.Spool.Dir != "" && tailOutput.format == outputCSV {
+			return withExitCode(exitUsage, fmt.Errorf("spool.dir cannot be used with --output-format %s, whose export is replaced on every run", outputCSV))
+		}
+
+		logger := logging.NewLogger(cfg.Log)
+		ctx, cancel := startCommand(cfg, logger)
+		defer cancel()
+
+		retryPolicy := retry.NewPolicy(cfg.Retry)
+
+		// Checkpoints are kept when tailing MongoDB into PostgreSQL, so that the stream
+		// resumes exactly once from the lowest position committed by every writer
+		var coordinator *domain.CheckpointCoordinator
+		var checkpoint, snapshotEnd domain.Timestamp
+		if tailSQLFile == "" {
+			if checkpoint, err = writer.ReadCheckpoint(cfg.DBConfig, retryPolicy, writer.GlobalCheckpoint); err != nil {
+				return withExitCode(exitFailure, err)
+			}
+			if snapshotEnd, err = writer.ReadCheckpoint(cfg.DBConfig, retryPolicy, writer.SnapshotCheckpoint); err != nil {
+				return withExitCode(exitFailure, err)
+			}
+			coordinator = domain.NewCheckpointCoordinator(checkpoint)
+		}
+
+		p := pipeline{
+			cfg:         cfg,
+			logger:      logger,
+			ordering:    tailOrdering,
+			sqlFile:     tailSQLFile,
+			output:      tailOutput,
+			coordinator: coordinator,
+			// the changes made while the last snapshot was running may already be applied
+			upsertUntil: snapshotEnd,
+		}
+
+		// The spool keeps the oplogs read ahead of the writers on disk, the reader resumes
+		// after the last spooled one and the spooled ones are applied first. Its segments are
+		// removed once the writers have committed or flushed their entries, so the written
+		// position is tracked for the output files too
+		startAfter := checkpoint
+		if cfg.Spool.Dir != "" {
+			if coordinator == nil {
+				coordinator = domain.NewCheckpointCoordinator(checkpoint)
+				p.coordinator = coordinator
+			}
+
+			spool, err := domain.NewDiskOplogPublisher(cfg.Spool, cfg.Pipeline.Buffers.Oplogs, checkpoint, metrics.Recorder{}, logger)
+			if err != nil {
+				return withExitCode(exitFailure, err)
+			}
+			defer spool.Close()
+
+			coordinator.Subscribe(spool.Acknowledge)
+			if spool.LastTimestamp().After(startAfter) {
+				startAfter = spool.LastTimestamp()
+			}
+			p.publisher = spool
+		}
+
+		oplogReader := reader.NewMongoReader(cfg.Mongo, retryPolicy, startAfter, bounds, filter, logger)
+		return withExitCode(exitFailure, p.run(ctx, oplogReader))
+	},
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/reader"
+	"github.com/spf13/cobra"
+)
+
+var validateOplogFile string
+
+func init() {
+	validateCmd.Flags().StringVarP(&validateOplogFile, "source_file", "f", "", "Source oplog file")
+	validateCmd.MarkFlagRequired("source_file")
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check an oplog file without emitting SQL",
+	Long: `validate reads the oplog entries of a JSON array or JSON lines file, or of the standard input
+with "-", and reports every entry which cannot be converted into SQL, such as malformed JSON,
+unsupported operations, malformed namespaces or updates without a diff. It exits with code 65
+when an invalid entry is found.`,
+	Example: "  oplog2sql validate -f example-input.json",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig(cmd, false)
+		if err != nil {
+			return err
+		}
+
+		oplogFile, err := reader.Open(validateOplogFile)
+		if err != nil {
+			return withExitCode(exitFailure, err)
+		}
+		defer oplogFile.Close()
+
+		decoder := reader.NewJSONDecoder(oplogFile)
+
+		// validating does not record the generated tables
+		cfg.Pipeline.Schema.Manifest = ""
+		parser := domain.NewOplogParser(domain.NewDefaultUUIDGenerator(), cfg.Pipeline, metrics.Recorder{}, logging.NewLogger(cfg.Log))
+		cache := domain.NewCache()
+
+		out := cmd.OutOrStdout()
+		entries, invalid := 0, 0
+		for {
+			entry, err := decoder.Decode()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			entries++
+
+			var decodeErr *reader.DecodeError
+			if errors.As(err, &decodeErr) {
+				invalid++
+				fmt.Fprintf(out, "entry %d: %s\n", entries, err)
+				continue
+			}
+			if err != nil {
+				return withExitCode(exitInvalid, fmt.Errorf("entry %d: %w", entries, err))
+			}
+
+			err = entry.Validate()
+			if err == nil {
+				_, err = parser.ProcessOplog(entry, cache)
+			}
+			if err != nil {
+				invalid++
+				fmt.Fprintf(out, "entry %d (ts %s, ns %q): %s\n", entries, entry.Timestamp, entry.Namespace, err)
+			}
+		}
+
+		fmt.Fprintf(out, "%d entries, %d invalid\n", entries, invalid)
+		if invalid > 0 {
+			return withExitCode(exitInvalid, fmt.Errorf("%d invalid oplog entries", invalid))
+		}
+		return nil
+	},
+}
@@ -1,58 +1,441 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	MONGO_URI   = "MONGO_URI"
-	DB_ADAPTOR  = "DB_ADAPTOR"
-	DB_NAME     = "DB_NAME"
-	DB_HOST     = "DB_HOST"
-	DB_PORT     = "DB_PORT"
-	DB_USER     = "DB_USER"
-	DB_PASSWORD = "DB_PASSWORD"
-	ENV         = ".env"
+	ENV = ".env"
+
+	// DEFAULT_TARGET is the name of the target configured by the DB_* environment variables.
+	DEFAULT_TARGET = "default"
 )
 
 type DBConfig struct {
-	Adaptor  string
-	Name     string
-	Host     string
-	UserName string
-	Password string
-	Port     string
+	Adaptor  string `yaml:"adaptor" toml:"adaptor"`
+	Name     string `yaml:"name" toml:"name"`
+	Host     string `yaml:"host" toml:"host"`
+	UserName string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Port     string `yaml:"port" toml:"port"`
+
+	// PasswordFile is read into Password, so that the password can be mounted as a secret.
+	// The password authenticates with md5 or SCRAM-SHA-256, as requested by the server.
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+
+	// SSLMode is one of disable, require, verify-ca or verify-full. SSLRootCert is the CA
+	// verifying the server certificate, and SSLCert and SSLKey authenticate the client.
+	SSLMode     string `yaml:"sslmode" toml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert" toml:"sslrootcert"`
+	SSLCert     string `yaml:"sslcert" toml:"sslcert"`
+	SSLKey      string `yaml:"sslkey" toml:"sslkey"`
+
+	ApplicationName  string        `yaml:"application_name" toml:"application_name"`
+	StatementTimeout time.Duration `yaml:"statement_timeout" toml:"statement_timeout"`
+	ConnectTimeout   time.Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+}
+
+// MongoConfig holds the connection settings of MongoDB. The settings other than URI
+// override the options of the URI when they are set.
+type MongoConfig struct {
+	URI string `yaml:"uri" toml:"uri"`
+	// URIFile is read into URI, so that the credentials can be mounted as a secret.
+	URIFile string `yaml:"uri_file" toml:"uri_file"`
+
+	// Direct connects to the host of the URI only, rather than discovering the replica set.
+	Direct  bool   `yaml:"direct" toml:"direct"`
+	AppName string `yaml:"app_name" toml:"app_name"`
+
+	TLS  MongoTLSConfig  `yaml:"tls" toml:"tls"`
+	Auth MongoAuthConfig `yaml:"auth" toml:"auth"`
+}
+
+// MongoTLSConfig enables TLS. CAFile is the CA verifying the server certificate, and
+// CertFile and KeyFile, which may be the same PEM file, authenticate the client.
+type MongoTLSConfig struct {
+	Enabled            bool   `yaml:"enabled" toml:"enabled"`
+	CAFile             string `yaml:"ca_file" toml:"ca_file"`
+	CertFile           string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile            string `yaml:"key_file" toml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+}
+
+// MongoAuthConfig holds the credential. Mechanism is one of SCRAM-SHA-1, SCRAM-SHA-256 or
+// MONGODB-X509, which authenticates with the TLS client certificate and needs no password.
+type MongoAuthConfig struct {
+	Mechanism    string `yaml:"mechanism" toml:"mechanism"`
+	Source       string `yaml:"source" toml:"source"`
+	Username     string `yaml:"username" toml:"username"`
+	Password     string `yaml:"password" toml:"password"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+}
+
+// IsSet reports whether a credential is configured, rather than taken from the URI.
+func (a MongoAuthConfig) IsSet() bool {
+	return a.Mechanism != "" || a.Username != ""
+}
+
+// RetryConfig controls how transient connection errors are retried.
+type RetryConfig struct {
+	MaxAttempts     int           `yaml:"max_attempts" toml:"max_attempts"`
+	InitialInterval time.Duration `yaml:"initial_interval" toml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval" toml:"max_interval"`
+	Multiplier      float64       `yaml:"multiplier" toml:"multiplier"`
+}
+
+// FlushConfig controls when a batch of statements is committed. A zero value disables the trigger.
+type FlushConfig struct {
+	MaxStatements int           `yaml:"max_statements" toml:"max_statements"`
+	MaxBytes      int           `yaml:"max_bytes" toml:"max_bytes"`
+	MaxLatency    time.Duration `yaml:"max_latency" toml:"max_latency"`
+}
+
+// LogConfig controls the structured logger. Level is one of debug, info, warn or error,
+// Format is either text or json, and DebugSampleRate keeps one out of every n debug records.
+type LogConfig struct {
+	Level           string `yaml:"level" toml:"level"`
+	Format          string `yaml:"format" toml:"format"`
+	DebugSampleRate int    `yaml:"debug_sample_rate" toml:"debug_sample_rate"`
+}
+
+// BufferConfig holds the capacity of the channels between the stages of the pipeline.
+type BufferConfig struct {
+	Oplogs      int `yaml:"oplogs" toml:"oplogs"`
+	Databases   int `yaml:"databases" toml:"databases"`
+	Collections int `yaml:"collections" toml:"collections"`
+	Statements  int `yaml:"statements" toml:"statements"`
+}
+
+// InputConfig controls how oplog files are read. Follow waits for the file to grow at its end,
+// polling every PollInterval, and SkipBadLines logs and skips the entries which cannot be decoded.
+type InputConfig struct {
+	Follow       bool          `yaml:"follow" toml:"follow"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	SkipBadLines bool          `yaml:"skip_bad_lines" toml:"skip_bad_lines"`
+}
+
+// FilterConfig selects the namespaces (db.collection) to replicate with regular expressions.
+// A namespace is replicated when it matches any Include expression, or Include is empty,
+// and it matches no Exclude expression.
+type FilterConfig struct {
+	Include []string `yaml:"include" toml:"include"`
+	Exclude []string `yaml:"exclude" toml:"exclude"`
+}
+
+// MappingConfig holds the rules mapping MongoDB collections to tables.
+type MappingConfig struct {
+	// Collections holds the rule of every namespace (db.collection) which is not mapped as is.
+	Collections map[string]CollectionMapping `yaml:"collections" toml:"collections"`
+}
+
+// Modes of a collection mapping.
+const (
+	// MODE_COLUMNS stores every field in its own column and nested objects in child tables.
+	MODE_COLUMNS = "columns"
+	// MODE_JSONB stores every document as a JSONB column along with its _id.
+	MODE_JSONB = "jsonb"
+)
+
+// CollectionMapping is the rule mapping a MongoDB collection to a table.
+type CollectionMapping struct {
+	// Target is the schema.table the collection is replicated into, the namespace when empty.
+	Target string `yaml:"target" toml:"target"`
+	// Mode is either columns (default) or jsonb.
+	Mode string `yaml:"mode" toml:"mode"`
+	// Promote lists the top-level fields also kept in their own column in jsonb mode.
+	Promote []string `yaml:"promote" toml:"promote"`
+	// FlattenDepth overrides the schema.flatten_depth of the pipeline for the collection.
+	FlattenDepth *int `yaml:"flatten_depth" toml:"flatten_depth"`
+	// SoftDelete overrides the schema.soft_delete of the pipeline for the collection.
+	SoftDelete *bool `yaml:"soft_delete" toml:"soft_delete"`
+	// History overrides the schema.history of the pipeline for the collection.
+	History *bool `yaml:"history" toml:"history"`
+	// AuditColumns overrides the schema.audit_columns of the pipeline for the collection.
+	AuditColumns *bool `yaml:"audit_columns" toml:"audit_columns"`
+}
+
+// HasAuditColumns reports whether the rows record the oplog entry that last wrote them.
+func (m CollectionMapping) HasAuditColumns() bool {
+	return m.AuditColumns != nil && *m.AuditColumns
+}
+
+// KeepsHistory reports whether every version of the documents is recorded in a history table.
+func (m CollectionMapping) KeepsHistory() bool {
+	return m.History != nil && *m.History
+}
+
+// SoftDeletes reports whether deleted documents are kept, flagged as deleted.
+func (m CollectionMapping) SoftDeletes() bool {
+	return m.SoftDelete != nil && *m.SoftDelete
+}
+
+// Depth returns the number of levels of embedded objects flattened into their parent table.
+func (m CollectionMapping) Depth() int {
+	if m.FlattenDepth == nil {
+		return 0
+	}
+	return *m.FlattenDepth
+}
+
+// Fsync policies of the spool.
+const (
+	FSYNC_ALWAYS   = "always"
+	FSYNC_INTERVAL = "interval"
+	FSYNC_NEVER    = "never"
+)
+
+// SpoolConfig enables the disk-backed oplog buffer of tail when Dir is set. The buffer is an
+// append-only log of segments of up to SegmentBytes, holding up to MaxBytes, or unlimited when
+// zero. Fsync is one of always, interval (every FsyncInterval) or never.
+type SpoolConfig struct {
+	Dir           string        `yaml:"dir" toml:"dir"`
+	SegmentBytes  int64         `yaml:"segment_bytes" toml:"segment_bytes"`
+	MaxBytes      int64         `yaml:"max_bytes" toml:"max_bytes"`
+	Fsync         string        `yaml:"fsync" toml:"fsync"`
+	FsyncInterval time.Duration `yaml:"fsync_interval" toml:"fsync_interval"`
+}
+
+// SchemaConfig holds the settings of the tables generated from the documents.
+type SchemaConfig struct {
+	// FlattenDepth is the number of levels of embedded objects stored as prefixed columns of
+	// their parent table (address_city) instead of child tables, none when zero.
+	FlattenDepth int `yaml:"flatten_depth" toml:"flatten_depth"`
+	// Manifest is the JSON file describing the tree of the generated tables.
+	Manifest string `yaml:"manifest" toml:"manifest"`
+	// ForeignKeys adds deferrable FOREIGN KEY constraints from child tables to their parent.
+	ForeignKeys bool `yaml:"foreign_keys" toml:"foreign_keys"`
+	// SoftDelete keeps the rows of deleted documents, flagged in _deleted and _deleted_at.
+	SoftDelete bool `yaml:"soft_delete" toml:"soft_delete"`
+	// History records every version of the documents in an append-only <table>_history.
+	History bool `yaml:"history" toml:"history"`
+	// AuditColumns adds the _oplog_ts, _oplog_op, _source_ns, _replicated_at and _txn_number
+	// columns to every table, set by the inserts and updates.
+	AuditColumns bool `yaml:"audit_columns" toml:"audit_columns"`
+}
+
+// PipelineConfig holds the settings of the stages converting oplogs into SQL statements.
+type PipelineConfig struct {
+	Buffers BufferConfig  `yaml:"buffers" toml:"buffers"`
+	Mapping MappingConfig `yaml:"mapping" toml:"mapping"`
+	Schema  SchemaConfig  `yaml:"schema" toml:"schema"`
+}
+
+// Collection returns the mapping rule of the namespace, completed with the schema settings.
+func (cfg PipelineConfig) Collection(namespace string) CollectionMapping {
+	mapping := cfg.Mapping.Collections[namespace]
+	if mapping.FlattenDepth == nil {
+		depth := cfg.Schema.FlattenDepth
+		mapping.FlattenDepth = &depth
+	}
+	if mapping.SoftDelete == nil {
+		softDelete := cfg.Schema.SoftDelete
+		mapping.SoftDelete = &softDelete
+	}
+	if mapping.History == nil {
+		history := cfg.Schema.History
+		mapping.History = &history
+	}
+	if mapping.AuditColumns == nil {
+		auditColumns := cfg.Schema.AuditColumns
+		mapping.AuditColumns = &auditColumns
+	}
+	return mapping
 }
 
 type Config struct {
-	MongoURI string
-	DBConfig DBConfig
+	Mongo MongoConfig `yaml:"mongo" toml:"mongo"`
+
+	// DBConfig is the selected target, resolved from Targets by Load.
+	DBConfig DBConfig `yaml:"-" toml:"-"`
+	// Target is the name of the selected target.
+	Target  string              `yaml:"target" toml:"target"`
+	Targets map[string]DBConfig `yaml:"targets" toml:"targets"`
+
+	Retry    RetryConfig    `yaml:"retry" toml:"retry"`
+	Flush    FlushConfig    `yaml:"flush" toml:"flush"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	Input    InputConfig    `yaml:"input" toml:"input"`
+	Filter   FilterConfig   `yaml:"filter" toml:"filter"`
+	Pipeline PipelineConfig `yaml:"pipeline" toml:"pipeline"`
+	Spool    SpoolConfig    `yaml:"spool" toml:"spool"`
+
+	// HTTPAddr is the address of the metrics and health endpoints listener, which is disabled when empty.
+	HTTPAddr string `yaml:"http_addr" toml:"http_addr"`
 }
 
-func Load() Config {
-	err := godotenv.Load(ENV)
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	cfg := Config{
-		MongoURI: readFromEnvFile(MONGO_URI),
-		DBConfig: DBConfig{
-			Adaptor:  readFromEnvFile(DB_ADAPTOR),
-			Name:     readFromEnvFile(DB_NAME),
-			Host:     readFromEnvFile(DB_HOST),
-			Port:     readFromEnvFile(DB_PORT),
-			UserName: readFromEnvFile(DB_USER),
-			Password: readFromEnvFile(DB_PASSWORD),
+// Default returns the configuration used when nothing else is configured.
+func Default() Config {
+	return Config{
+		Mongo: MongoConfig{
+			Direct: true,
+		},
+		Target:  DEFAULT_TARGET,
+		Targets: map[string]DBConfig{},
+		Retry: RetryConfig{
+			MaxAttempts:     5,
+			InitialInterval: 500 * time.Millisecond,
+			MaxInterval:     30 * time.Second,
+			Multiplier:      2,
+		},
+		Flush: FlushConfig{
+			MaxStatements: 10000,
+			MaxBytes:      64 << 20,
+			MaxLatency:    time.Second,
+		},
+		Log: LogConfig{
+			Level:           "info",
+			Format:          "text",
+			DebugSampleRate: 1,
+		},
+		Input: InputConfig{
+			PollInterval: time.Second,
 		},
+		Pipeline: DefaultPipeline(),
+		Spool: SpoolConfig{
+			SegmentBytes:  64 << 20,
+			MaxBytes:      1 << 30,
+			Fsync:         FSYNC_INTERVAL,
+			FsyncInterval: time.Second,
+		},
+	}
+}
+
+// DefaultPipeline returns the pipeline settings used when nothing else is configured.
+func DefaultPipeline() PipelineConfig {
+	return PipelineConfig{
+		Buffers: BufferConfig{
+			Oplogs:      100,
+			Databases:   1000,
+			Collections: 1000,
+			Statements:  100,
+		},
+	}
+}
+
+func defaultDBConfig() DBConfig {
+	return DBConfig{
+		Adaptor:         "postgres",
+		Host:            "localhost",
+		Port:            "5432",
+		SSLMode:         "disable",
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 5 * time.Minute,
+	}
+}
+
+// withDefaults fills the settings which are not configured with their defaults.
+func (db DBConfig) withDefaults() DBConfig {
+	defaults := defaultDBConfig()
+	if db.Adaptor == "" {
+		db.Adaptor = defaults.Adaptor
+	}
+	if db.Host == "" {
+		db.Host = defaults.Host
+	}
+	if db.Port == "" {
+		db.Port = defaults.Port
+	}
+	if db.SSLMode == "" {
+		db.SSLMode = defaults.SSLMode
+	}
+	if db.MaxOpenConns == 0 {
+		db.MaxOpenConns = defaults.MaxOpenConns
+	}
+	if db.MaxIdleConns == 0 {
+		db.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if db.ConnMaxLifetime == 0 {
+		db.ConnMaxLifetime = defaults.ConnMaxLifetime
+	}
+	return db
+}
+
+// Load layers the configuration: the defaults, then the config file at path (YAML, or TOML
+// when it ends with .toml) unless path is empty, then the environment variables, which are
+// also read from an optional .env file. Command line flags are applied on top by the caller,
+// which calls Resolve afterwards.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if err := godotenv.Load(ENV); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, fmt.Errorf("error loading %s file: %w", ENV, err)
+	}
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, fmt.Errorf("error loading config file %s: %w", path, err)
+		}
+	}
+
+	loadEnv(&cfg)
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
 	}
 
-	return cfg
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		_, err = toml.Decode(string(data), cfg)
+		return err
+	}
+	return yaml.Unmarshal(data, cfg)
 }
 
-func readFromEnvFile(key string) string {
-	return os.Getenv(key)
+// Resolve selects the target, reads the secret files and validates the configuration.
+func (cfg *Config) Resolve() error {
+	target, ok := cfg.Targets[cfg.Target]
+	if !ok && cfg.Target != DEFAULT_TARGET {
+		return fmt.Errorf("unknown target %q", cfg.Target)
+	}
+	cfg.DBConfig = target.withDefaults()
+
+	if cfg.Mongo.URIFile != "" {
+		secret, err := readSecret(cfg.Mongo.URIFile)
+		if err != nil {
+			return err
+		}
+		cfg.Mongo.URI = secret
+	}
+	if cfg.Mongo.Auth.PasswordFile != "" {
+		secret, err := readSecret(cfg.Mongo.Auth.PasswordFile)
+		if err != nil {
+			return err
+		}
+		cfg.Mongo.Auth.Password = secret
+	}
+	if cfg.DBConfig.PasswordFile != "" {
+		secret, err := readSecret(cfg.DBConfig.PasswordFile)
+		if err != nil {
+			return err
+		}
+		cfg.DBConfig.Password = secret
+	}
+
+	return cfg.Validate()
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
 }
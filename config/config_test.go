@@ -0,0 +1,276 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		env      map[string]string
+		want     func(cfg *Config)
+	}{
+		{
+			name: "Defaults",
+			want: func(cfg *Config) {},
+		},
+		{
+			name: "YAML file",
+			file: "config.yaml",
+			contents: `
+flush:
+  max_statements: 500
+targets:
+  default:
+    host: db.internal
+    name: replica
+filter:
+  exclude: ["^admin\\."]
+`,
+			want: func(cfg *Config) {
+				cfg.Flush.MaxStatements = 500
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: {Host: "db.internal", Name: "replica"}}
+				cfg.Filter.Exclude = []string{`^admin\.`}
+			},
+		},
+		{
+			name: "TOML file",
+			file: "config.toml",
+			contents: `
+[retry]
+max_attempts = 2
+initial_interval = "1s"
+`,
+			want: func(cfg *Config) {
+				cfg.Retry.MaxAttempts = 2
+				cfg.Retry.InitialInterval = time.Second
+			},
+		},
+		{
+			name: "Environment over file",
+			file: "config.yaml",
+			contents: `
+log:
+  level: warn
+targets:
+  default:
+    host: db.internal
+`,
+			env: map[string]string{LOG_LEVEL: "debug", DB_HOST: "db.env", FLUSH_MAX_LATENCY: "5s"},
+			want: func(cfg *Config) {
+				cfg.Log.Level = "debug"
+				cfg.Flush.MaxLatency = 5 * time.Second
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: {Host: "db.env"}}
+			},
+		},
+		{
+			name: "Environment without file",
+			env:  map[string]string{DB_NAME: "replica"},
+			want: func(cfg *Config) {
+				target := defaultDBConfig()
+				target.Name = "replica"
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: target}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			path := ""
+			if test.file != "" {
+				path = filepath.Join(t.TempDir(), test.file)
+				if err := os.WriteFile(path, []byte(test.contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+
+			want := Default()
+			test.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Config does not match the expected result.\nWant: %+v\nGot: %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		targets    map[string]DBConfig
+		wantDB     DBConfig
+		wantErrMsg string
+	}{
+		{
+			name:   "Default target",
+			target: DEFAULT_TARGET,
+			wantDB: defaultDBConfig(),
+		},
+		{
+			name:    "Selected target with a password file",
+			target:  "replica",
+			targets: map[string]DBConfig{"replica": {Host: "replica.internal", PasswordFile: secretFile}},
+			wantDB: func() DBConfig {
+				db := defaultDBConfig()
+				db.Host = "replica.internal"
+				db.PasswordFile = secretFile
+				db.Password = "s3cret"
+				return db
+			}(),
+		},
+		{
+			name:       "Unknown target",
+			target:     "missing",
+			wantErrMsg: `unknown target "missing"`,
+		},
+		{
+			name:       "Missing password file",
+			target:     DEFAULT_TARGET,
+			targets:    map[string]DBConfig{DEFAULT_TARGET: {PasswordFile: secretFile + ".missing"}},
+			wantErrMsg: "error reading secret file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Target = test.target
+			if test.targets != nil {
+				cfg.Targets = test.targets
+			}
+
+			err := cfg.Resolve()
+			if test.wantErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErrMsg) {
+					t.Errorf("Error does not match the expected result.\nWant: %s\nGot: %v", test.wantErrMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve failed: %v", err)
+			}
+			if cfg.DBConfig != test.wantDB {
+				t.Errorf("Target does not match the expected result.\nWant: %+v\nGot: %+v", test.wantDB, cfg.DBConfig)
+			}
+		})
+	}
+}
+
+func TestValidateConnections(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     func(cfg *Config)
+		wantErrMsg string
+	}{
+		{
+			name: "Verified TLS with client certificates",
+			config: func(cfg *Config) {
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: {SSLMode: "verify-full", SSLCert: "client.pem", SSLKey: "client.key"}}
+				cfg.Mongo.TLS = MongoTLSConfig{Enabled: true, CertFile: "client.pem"}
+				cfg.Mongo.Auth = MongoAuthConfig{Mechanism: "MONGODB-X509"}
+			},
+		},
+		{
+			name: "Unknown sslmode",
+			config: func(cfg *Config) {
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: {SSLMode: "prefer-always"}}
+			},
+			wantErrMsg: `sslmode must be one of disable, require, verify-ca or verify-full, got "prefer-always"`,
+		},
+		{
+			name: "Client certificate without key",
+			config: func(cfg *Config) {
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: {SSLCert: "client.pem"}}
+			},
+			wantErrMsg: "sslcert and sslkey must be set together",
+		},
+		{
+			name: "Negative statement timeout",
+			config: func(cfg *Config) {
+				cfg.Targets = map[string]DBConfig{DEFAULT_TARGET: {StatementTimeout: -time.Second}}
+			},
+			wantErrMsg: "timeouts must not be negative",
+		},
+		{
+			name: "X.509 without client certificate",
+			config: func(cfg *Config) {
+				cfg.Mongo.Auth = MongoAuthConfig{Mechanism: "MONGODB-X509"}
+			},
+			wantErrMsg: "MONGODB-X509 needs a TLS client certificate",
+		},
+		{
+			name: "Unknown auth mechanism",
+			config: func(cfg *Config) {
+				cfg.Mongo.Auth = MongoAuthConfig{Mechanism: "PLAIN"}
+			},
+			wantErrMsg: `mongo.auth.mechanism must be one of SCRAM-SHA-1, SCRAM-SHA-256 or MONGODB-X509, got "PLAIN"`,
+		},
+		{
+			name: "Key file without certificate",
+			config: func(cfg *Config) {
+				cfg.Mongo.TLS = MongoTLSConfig{Enabled: true, KeyFile: "client.key"}
+			},
+			wantErrMsg: "key_file needs cert_file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Default()
+			test.config(&cfg)
+
+			err := cfg.Validate()
+			if test.wantErrMsg == "" {
+				if err != nil {
+					t.Errorf("Validate failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErrMsg) {
+				t.Errorf("Error does not match the expected result.\nWant: %s\nGot: %v", test.wantErrMsg, err)
+			}
+		})
+	}
+}
+
+// TestExampleConfig checks that the example configuration loads and, as it is meant to be
+// copied, neither filters namespaces nor maps collections.
+func TestExampleConfig(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "config.example.yaml"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if len(cfg.Filter.Include) > 0 || len(cfg.Filter.Exclude) > 0 {
+		t.Errorf("Example config filters namespaces: %+v", cfg.Filter)
+	}
+	if len(cfg.Pipeline.Mapping.Collections) > 0 {
+		t.Errorf("Example config maps collections: %+v", cfg.Pipeline.Mapping.Collections)
+	}
+
+	cfg.DBConfig = cfg.Targets[cfg.Target].withDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Example config is invalid: %v", err)
+	}
+}
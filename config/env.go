@@ -0,0 +1,141 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	CONFIG_FILE            = "OPLOG2SQL_CONFIG"
+	MONGO_URI              = "MONGO_URI"
+	MONGO_URI_FILE         = "MONGO_URI_FILE"
+	MONGO_TLS_CA_FILE      = "MONGO_TLS_CA_FILE"
+	MONGO_TLS_CERT_FILE    = "MONGO_TLS_CERT_FILE"
+	MONGO_TLS_KEY_FILE     = "MONGO_TLS_KEY_FILE"
+	MONGO_AUTH_MECHANISM   = "MONGO_AUTH_MECHANISM"
+	MONGO_AUTH_SOURCE      = "MONGO_AUTH_SOURCE"
+	MONGO_USER             = "MONGO_USER"
+	MONGO_PASSWORD         = "MONGO_PASSWORD"
+	MONGO_PASSWORD_FILE    = "MONGO_PASSWORD_FILE"
+	DB_TARGET              = "DB_TARGET"
+	DB_ADAPTOR             = "DB_ADAPTOR"
+	DB_NAME                = "DB_NAME"
+	DB_HOST                = "DB_HOST"
+	DB_PORT                = "DB_PORT"
+	DB_USER                = "DB_USER"
+	DB_PASSWORD            = "DB_PASSWORD"
+	DB_PASSWORD_FILE       = "DB_PASSWORD_FILE"
+	DB_SSLMODE             = "DB_SSLMODE"
+	DB_SSLROOTCERT         = "DB_SSLROOTCERT"
+	DB_SSLCERT             = "DB_SSLCERT"
+	DB_SSLKEY              = "DB_SSLKEY"
+	DB_APPLICATION_NAME    = "DB_APPLICATION_NAME"
+	DB_STATEMENT_TIMEOUT   = "DB_STATEMENT_TIMEOUT"
+	RETRY_MAX_ATTEMPTS     = "RETRY_MAX_ATTEMPTS"
+	RETRY_INITIAL_INTERVAL = "RETRY_INITIAL_INTERVAL"
+	RETRY_MAX_INTERVAL     = "RETRY_MAX_INTERVAL"
+	RETRY_MULTIPLIER       = "RETRY_MULTIPLIER"
+	FLUSH_MAX_STATEMENTS   = "FLUSH_MAX_STATEMENTS"
+	FLUSH_MAX_BYTES        = "FLUSH_MAX_BYTES"
+	FLUSH_MAX_LATENCY      = "FLUSH_MAX_LATENCY"
+	LOG_LEVEL              = "LOG_LEVEL"
+	LOG_FORMAT             = "LOG_FORMAT"
+	LOG_DEBUG_SAMPLE_RATE  = "LOG_DEBUG_SAMPLE_RATE"
+	HTTP_ADDR              = "HTTP_ADDR"
+	SPOOL_DIR              = "SPOOL_DIR"
+	SPOOL_MAX_BYTES        = "SPOOL_MAX_BYTES"
+	SPOOL_FSYNC            = "SPOOL_FSYNC"
+)
+
+// loadEnv overrides the configuration with the environment variables which are set.
+// The DB_* variables configure the selected target.
+func loadEnv(cfg *Config) {
+	readFromEnvFile(MONGO_URI, &cfg.Mongo.URI)
+	readFromEnvFile(MONGO_URI_FILE, &cfg.Mongo.URIFile)
+	readFromEnvFile(MONGO_TLS_CA_FILE, &cfg.Mongo.TLS.CAFile)
+	readFromEnvFile(MONGO_TLS_CERT_FILE, &cfg.Mongo.TLS.CertFile)
+	readFromEnvFile(MONGO_TLS_KEY_FILE, &cfg.Mongo.TLS.KeyFile)
+	if cfg.Mongo.TLS.CAFile != "" || cfg.Mongo.TLS.CertFile != "" {
+		cfg.Mongo.TLS.Enabled = true
+	}
+	readFromEnvFile(MONGO_AUTH_MECHANISM, &cfg.Mongo.Auth.Mechanism)
+	readFromEnvFile(MONGO_AUTH_SOURCE, &cfg.Mongo.Auth.Source)
+	readFromEnvFile(MONGO_USER, &cfg.Mongo.Auth.Username)
+	readFromEnvFile(MONGO_PASSWORD, &cfg.Mongo.Auth.Password)
+	readFromEnvFile(MONGO_PASSWORD_FILE, &cfg.Mongo.Auth.PasswordFile)
+
+	readFromEnvFile(DB_TARGET, &cfg.Target)
+	target, ok := cfg.Targets[cfg.Target]
+	if !ok {
+		target = defaultDBConfig()
+	}
+	readFromEnvFile(DB_ADAPTOR, &target.Adaptor)
+	readFromEnvFile(DB_NAME, &target.Name)
+	readFromEnvFile(DB_HOST, &target.Host)
+	readFromEnvFile(DB_PORT, &target.Port)
+	readFromEnvFile(DB_USER, &target.UserName)
+	readFromEnvFile(DB_PASSWORD, &target.Password)
+	readFromEnvFile(DB_PASSWORD_FILE, &target.PasswordFile)
+	readFromEnvFile(DB_SSLMODE, &target.SSLMode)
+	readFromEnvFile(DB_SSLROOTCERT, &target.SSLRootCert)
+	readFromEnvFile(DB_SSLCERT, &target.SSLCert)
+	readFromEnvFile(DB_SSLKEY, &target.SSLKey)
+	readFromEnvFile(DB_APPLICATION_NAME, &target.ApplicationName)
+	readDurationFromEnvFile(DB_STATEMENT_TIMEOUT, &target.StatementTimeout)
+	if ok || target != defaultDBConfig() {
+		if cfg.Targets == nil {
+			cfg.Targets = map[string]DBConfig{}
+		}
+		cfg.Targets[cfg.Target] = target
+	}
+
+	readIntFromEnvFile(RETRY_MAX_ATTEMPTS, &cfg.Retry.MaxAttempts)
+	readDurationFromEnvFile(RETRY_INITIAL_INTERVAL, &cfg.Retry.InitialInterval)
+	readDurationFromEnvFile(RETRY_MAX_INTERVAL, &cfg.Retry.MaxInterval)
+	readFloatFromEnvFile(RETRY_MULTIPLIER, &cfg.Retry.Multiplier)
+
+	readIntFromEnvFile(FLUSH_MAX_STATEMENTS, &cfg.Flush.MaxStatements)
+	readIntFromEnvFile(FLUSH_MAX_BYTES, &cfg.Flush.MaxBytes)
+	readDurationFromEnvFile(FLUSH_MAX_LATENCY, &cfg.Flush.MaxLatency)
+
+	readFromEnvFile(LOG_LEVEL, &cfg.Log.Level)
+	readFromEnvFile(LOG_FORMAT, &cfg.Log.Format)
+	readIntFromEnvFile(LOG_DEBUG_SAMPLE_RATE, &cfg.Log.DebugSampleRate)
+
+	readFromEnvFile(HTTP_ADDR, &cfg.HTTPAddr)
+
+	readFromEnvFile(SPOOL_DIR, &cfg.Spool.Dir)
+	readInt64FromEnvFile(SPOOL_MAX_BYTES, &cfg.Spool.MaxBytes)
+	readFromEnvFile(SPOOL_FSYNC, &cfg.Spool.Fsync)
+}
+
+func readFromEnvFile(key string, value *string) {
+	if env, ok := os.LookupEnv(key); ok && env != "" {
+		*value = env
+	}
+}
+
+func readIntFromEnvFile(key string, value *int) {
+	if env, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		*value = env
+	}
+}
+
+func readInt64FromEnvFile(key string, value *int64) {
+	if env, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		*value = env
+	}
+}
+
+func readFloatFromEnvFile(key string, value *float64) {
+	if env, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		*value = env
+	}
+}
+
+func readDurationFromEnvFile(key string, value *time.Duration) {
+	if env, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		*value = env
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Validate reports every invalid setting of the configuration in a single error.
+func (cfg Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if cfg.Retry.MaxAttempts < 1 {
+		invalid("retry.max_attempts must be at least 1")
+	}
+	if cfg.Retry.InitialInterval < 0 || cfg.Retry.MaxInterval < cfg.Retry.InitialInterval {
+		invalid("retry.max_interval must not be shorter than retry.initial_interval")
+	}
+	if cfg.Retry.Multiplier < 1 {
+		invalid("retry.multiplier must be at least 1")
+	}
+
+	if cfg.Flush.MaxStatements < 0 || cfg.Flush.MaxBytes < 0 || cfg.Flush.MaxLatency < 0 {
+		invalid("flush settings must not be negative")
+	}
+
+	switch strings.ToLower(cfg.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		invalid("log.level must be one of debug, info, warn or error, got %q", cfg.Log.Level)
+	}
+	switch strings.ToLower(cfg.Log.Format) {
+	case "text", "json":
+	default:
+		invalid("log.format must be either text or json, got %q", cfg.Log.Format)
+	}
+
+	if cfg.Input.PollInterval <= 0 {
+		invalid("input.poll_interval must be positive")
+	}
+
+	buffers := cfg.Pipeline.Buffers
+	if buffers.Oplogs < 1 || buffers.Databases < 1 || buffers.Collections < 1 || buffers.Statements < 1 {
+		invalid("pipeline.buffers sizes must be at least 1")
+	}
+
+	switch cfg.Spool.Fsync {
+	case FSYNC_ALWAYS, FSYNC_NEVER:
+	case FSYNC_INTERVAL:
+		if cfg.Spool.FsyncInterval <= 0 {
+			invalid("spool.fsync_interval must be positive")
+		}
+	default:
+		invalid("spool.fsync must be one of always, interval or never, got %q", cfg.Spool.Fsync)
+	}
+	if cfg.Spool.SegmentBytes < 1 {
+		invalid("spool.segment_bytes must be positive")
+	}
+	if cfg.Spool.MaxBytes != 0 && cfg.Spool.MaxBytes < 2*cfg.Spool.SegmentBytes {
+		invalid("spool.max_bytes must hold at least two segments")
+	}
+
+	for _, expr := range append(append([]string{}, cfg.Filter.Include...), cfg.Filter.Exclude...) {
+		if _, err := regexp.Compile(expr); err != nil {
+			invalid("filter expression %q: %v", expr, err)
+		}
+	}
+
+	if cfg.Pipeline.Schema.FlattenDepth < 0 {
+		invalid("pipeline.schema.flatten_depth cannot be negative")
+	}
+	for ns, mapping := range cfg.Pipeline.Mapping.Collections {
+		if len(strings.SplitN(ns, ".", 2)) != 2 {
+			invalid("mapping namespace %q must be db.collection", ns)
+		}
+		if parts := strings.Split(mapping.Target, "."); mapping.Target != "" && (len(parts) != 2 || parts[0] == "" || parts[1] == "") {
+			invalid("mapping target %q of %s must be schema.table", mapping.Target, ns)
+		}
+		if mapping.Depth() < 0 {
+			invalid("mapping flatten_depth of %s cannot be negative", ns)
+		}
+		switch mapping.Mode {
+		case "", MODE_COLUMNS:
+			if len(mapping.Promote) > 0 {
+				invalid("mapping of %s promotes fields, which requires mode jsonb", ns)
+			}
+		case MODE_JSONB:
+			for _, field := range mapping.Promote {
+				if field == "" || field == "_id" || field == "doc" || strings.Contains(field, ".") {
+					invalid("mapping of %s cannot promote field %q", ns, field)
+				}
+			}
+		default:
+			invalid("mapping mode %q of %s must be columns or jsonb", mapping.Mode, ns)
+		}
+	}
+
+	for name, target := range cfg.Targets {
+		if target.Adaptor != "" && target.Adaptor != "postgres" {
+			invalid("target %s: unsupported adaptor %q", name, target.Adaptor)
+		}
+		switch target.SSLMode {
+		case "", "disable", "require", "verify-ca", "verify-full":
+		default:
+			invalid("target %s: sslmode must be one of disable, require, verify-ca or verify-full, got %q", name, target.SSLMode)
+		}
+		if (target.SSLCert == "") != (target.SSLKey == "") {
+			invalid("target %s: sslcert and sslkey must be set together", name)
+		}
+		if target.StatementTimeout < 0 || target.ConnectTimeout < 0 {
+			invalid("target %s: timeouts must not be negative", name)
+		}
+	}
+
+	switch cfg.Mongo.Auth.Mechanism {
+	case "", "SCRAM-SHA-1", "SCRAM-SHA-256":
+	case "MONGODB-X509":
+		if cfg.Mongo.TLS.CertFile == "" {
+			invalid("mongo.auth: MONGODB-X509 needs a TLS client certificate")
+		}
+	default:
+		invalid("mongo.auth.mechanism must be one of SCRAM-SHA-1, SCRAM-SHA-256 or MONGODB-X509, got %q", cfg.Mongo.Auth.Mechanism)
+	}
+	if cfg.Mongo.TLS.KeyFile != "" && cfg.Mongo.TLS.CertFile == "" {
+		invalid("mongo.tls: key_file needs cert_file")
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateTarget reports whether the selected target is complete enough to connect to.
+func (cfg Config) ValidateTarget() error {
+	var errs []error
+	if cfg.DBConfig.Name == "" {
+		errs = append(errs, fmt.Errorf("target %s: database name is required", cfg.Target))
+	}
+	if cfg.DBConfig.UserName == "" {
+		errs = append(errs, fmt.Errorf("target %s: user is required", cfg.Target))
+	}
+	return errors.Join(errs...)
+}
@@ -1,14 +1,14 @@
-CREATE SCHEMA student;
-CREATE TABLE student.students (_id VARCHAR(255) PRIMARY KEY, age FLOAT, name VARCHAR(255), subject VARCHAR(255));
+CREATE SCHEMA IF NOT EXISTS student;
+CREATE TABLE IF NOT EXISTS student.students (_id VARCHAR(255) PRIMARY KEY, age FLOAT, name VARCHAR(255), subject VARCHAR(255));
 INSERT INTO student.students (_id, age, name, subject) VALUES ('64798c213f273a7ca2cf516a', 25, 'Nathan Lindgren', 'Maths');
 INSERT INTO student.students (_id, age, name, subject) VALUES ('64798c213f273a7ca2cf516b', 18, 'Meggie Hoppe', 'English');
-CREATE SCHEMA employee;
-CREATE TABLE employee.employees (_id VARCHAR(255) PRIMARY KEY, age FLOAT, name VARCHAR(255), position VARCHAR(255), salary FLOAT);
+CREATE SCHEMA IF NOT EXISTS employee;
+CREATE TABLE IF NOT EXISTS employee.employees (_id VARCHAR(255) PRIMARY KEY, age FLOAT, name VARCHAR(255), position VARCHAR(255), salary FLOAT);
 INSERT INTO employee.employees (_id, age, name, position, salary) VALUES ('64798c213f273a7ca2cf516c', 35, 'Raymond Monahan', 'Engineer', 3767.925634753098);
-CREATE TABLE employee.address (_id VARCHAR(255) PRIMARY KEY, employees__id VARCHAR(255), line1 VARCHAR(255), zip VARCHAR(255));
+CREATE TABLE IF NOT EXISTS employee.address (_id VARCHAR(255) PRIMARY KEY, employees__id VARCHAR(255), line1 VARCHAR(255), zip VARCHAR(255));
 INSERT INTO employee.address (_id, employees__id, line1, zip) VALUES ('22f96d13-a022-4b61-8930-71419f07e2b3', '64798c213f273a7ca2cf516c', '32550 Port Gatewaytown', '18399');
 INSERT INTO employee.address (_id, employees__id, line1, zip) VALUES ('4f56d437-6a44-488b-bdfd-287abf90fa4c', '64798c213f273a7ca2cf516c', '3840 Cornermouth', '83941');
-CREATE TABLE employee.phone (_id VARCHAR(255) PRIMARY KEY, employees__id VARCHAR(255), personal VARCHAR(255), work VARCHAR(255));
+CREATE TABLE IF NOT EXISTS employee.phone (_id VARCHAR(255) PRIMARY KEY, employees__id VARCHAR(255), personal VARCHAR(255), work VARCHAR(255));
 INSERT INTO employee.phone (_id, employees__id, personal, work) VALUES ('ba8ba0f2-c450-4601-a110-1d7df9553001', '64798c213f273a7ca2cf516c', '8764255212', '2762135091');
 DELETE FROM student.students WHERE _id = '64798c213f273a7ca2cf516a';
 INSERT INTO student.students (_id, age, name, subject) VALUES ('64798c213f273a7ca2cf516d', 19, 'Tevin Heathcote', 'English');
@@ -26,12 +26,12 @@ INSERT INTO employee.address (_id, employees__id, line1, zip) VALUES ('29b24089-
 INSERT INTO employee.address (_id, employees__id, line1, zip) VALUES ('3dfb010e-d7e9-46ed-9166-59736b8a84e9', '64798c213f273a7ca2cf5171', '79033 West Locksmouth', '43555');
 INSERT INTO employee.phone (_id, employees__id, personal, work) VALUES ('635f43fc-c414-40cb-a2b8-86a4fd03cea6', '64798c213f273a7ca2cf5171', '4613562303', '1889316722');
 UPDATE employee.employees SET Age = 23 WHERE _id = '64798c213f273a7ca2cf5171';
-ALTER TABLE employee.employees ADD COLUMN phone VARCHAR(255), ADD COLUMN workhours FLOAT, ADD COLUMN address VARCHAR(255);
+ALTER TABLE employee.employees ADD COLUMN IF NOT EXISTS phone VARCHAR(255), ADD COLUMN IF NOT EXISTS workhours FLOAT, ADD COLUMN IF NOT EXISTS address VARCHAR(255);
 INSERT INTO employee.employees (_id, age, name, position, salary, workhours) VALUES ('64798c213f273a7ca2cf5172', 20, 'Delta Bahringer', 'Developer', 2980.1271103167737, 6);
 INSERT INTO employee.address (_id, employees__id, line1, zip) VALUES ('4f25649b-eb29-4ba3-9884-fa721940ec7a', '64798c213f273a7ca2cf5172', '2787 Trackview', '23598');
 INSERT INTO employee.address (_id, employees__id, line1, zip) VALUES ('d8af9260-786f-4eb2-9f9d-995f2d04cb8b', '64798c213f273a7ca2cf5172', '33659 South Mountainchester', '45086');
 INSERT INTO employee.phone (_id, employees__id, personal, work) VALUES ('6d615612-e5c5-4132-bd4d-5cd3d3ec7fa4', '64798c213f273a7ca2cf5172', '9829848796', '5636590993');
-ALTER TABLE student.students ADD COLUMN is_graduated BOOLEAN;
+ALTER TABLE student.students ADD COLUMN IF NOT EXISTS is_graduated BOOLEAN;
 INSERT INTO student.students (_id, age, is_graduated, name, subject) VALUES ('64798c213f273a7ca2cf5173', 20, false, 'Freda Dare', 'Maths');
 INSERT INTO student.students (_id, age, is_graduated, name, subject) VALUES ('64798c213f273a7ca2cf5174', 23, true, 'Kamille Jast', 'Maths');
 INSERT INTO student.students (_id, age, is_graduated, name, subject) VALUES ('64798c213f273a7ca2cf5175', 19, false, 'Arden Kessler', 'Social Studies');
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+// Audit columns, added to every table of the collections keeping them.
+const (
+	AUDIT_OPLOG_TS_COLUMN      string = "_oplog_ts"
+	AUDIT_OPLOG_OP_COLUMN      string = "_oplog_op"
+	AUDIT_SOURCE_NS_COLUMN     string = "_source_ns"
+	AUDIT_REPLICATED_AT_COLUMN string = "_replicated_at"
+	AUDIT_TXN_NUMBER_COLUMN    string = "_txn_number"
+)
+
+// replicationTime is the value of the AUDIT_REPLICATED_AT_COLUMN, the time the row is written.
+const replicationTime sqlExpression = "now()"
+
+// auditValue is the value of an audit column, along with the SQL type of the column, which
+// does not depend on the value as the types of the document fields do.
+type auditValue struct {
+	dataType string
+	value    interface{}
+}
+
+// entryMapping is the mapping rule of the collection of an oplog entry, along with the values
+// of the audit columns written by the entry when the rule keeps them.
+type entryMapping struct {
+	config.CollectionMapping
+	audit map[string]interface{}
+	// upsert is set when the document inserted by the entry may already be stored
+	upsert bool
+	// rows are the rows inserted by the statements of the entry, keyed by statement
+	rows map[string]*Row
+}
+
+// newEntryMapping returns the mapping of the entry, read before its namespace is mapped so that
+// the source namespace is the one of MongoDB.
+func newEntryMapping(entry OplogEntry, mapping config.CollectionMapping) entryMapping {
+	if !mapping.HasAuditColumns() {
+		return entryMapping{CollectionMapping: mapping, upsert: entry.Upsert}
+	}
+
+	var txnNumber interface{}
+	if entry.TxnNumber != nil {
+		txnNumber = *entry.TxnNumber
+	}
+	return entryMapping{
+		CollectionMapping: mapping,
+		audit: map[string]interface{}{
+			AUDIT_OPLOG_TS_COLUMN:      auditValue{"BIGINT", int64(entry.Timestamp.T)<<32 | int64(entry.Timestamp.I)},
+			AUDIT_OPLOG_OP_COLUMN:      auditValue{"CHAR(1)", entry.Operation},
+			AUDIT_SOURCE_NS_COLUMN:     auditValue{"VARCHAR(255)", entry.Namespace},
+			AUDIT_REPLICATED_AT_COLUMN: auditValue{"TIMESTAMPTZ", replicationTime},
+			AUDIT_TXN_NUMBER_COLUMN:    auditValue{"BIGINT", txnNumber},
+		},
+		upsert: entry.Upsert,
+	}
+}
+
+// addAuditColumns sets the audit columns in the data of a row, replacing the document fields
+// of the same name, so that they are created, altered and inserted along with the fields.
+func (m entryMapping) addAuditColumns(data map[string]interface{}) {
+	for columnName, value := range m.audit {
+		data[columnName] = value
+	}
+}
+
+// addRow records the row inserted by the query, with the values of the columns in the data. The
+// row is left out when one of its values is an SQL expression, such as a sub-query.
+func (m entryMapping) addRow(query string, tableName string, columnNames []string, data map[string]interface{}) {
+	if m.rows == nil {
+		return
+	}
+	row := &Row{Table: tableName, Columns: columnNames, Values: make([]*string, len(columnNames))}
+	for i, columnName := range columnNames {
+		value, ok := rowValue(data[columnName])
+		if !ok {
+			return
+		}
+		row.Values[i] = value
+	}
+	m.rows[query] = row
+}
+
+// isAuditColumn reports whether the column is one of the audit columns.
+func isAuditColumn(columnName string) bool {
+	switch columnName {
+	case AUDIT_OPLOG_TS_COLUMN, AUDIT_OPLOG_OP_COLUMN, AUDIT_SOURCE_NS_COLUMN, AUDIT_REPLICATED_AT_COLUMN, AUDIT_TXN_NUMBER_COLUMN:
+		return true
+	}
+	return false
+}
+
+// auditAssignments returns the sorted assignments of the audit columns of an UPDATE.
+func (m entryMapping) auditAssignments() []string {
+	setCols := make([]string, 0, len(m.audit))
+	for _, columnName := range sortColumns(m.audit) {
+		setCols = append(setCols, fmt.Sprintf("%s = %s", columnName, getColumnValue(m.audit[columnName])))
+	}
+	return setCols
+}
@@ -0,0 +1,69 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds is the window of the oplog to replay. From and To are inclusive, and Until is
+// the latest wall clock time. A zero value leaves the window open on that side.
+type Bounds struct {
+	From  Timestamp
+	To    Timestamp
+	Until time.Time
+}
+
+// HasUpper reports whether the window is closed at its end.
+func (b Bounds) HasUpper() bool {
+	return !b.To.IsZero() || !b.Until.IsZero()
+}
+
+// Before reports whether the entry precedes the window.
+func (b Bounds) Before(entry OplogEntry) bool {
+	return !b.From.IsZero() && b.From.After(entry.Timestamp)
+}
+
+// Past reports whether the entry follows the window, after which no entry of the window is expected.
+func (b Bounds) Past(entry OplogEntry) bool {
+	return b.PastPosition(entry.Timestamp, entry.Wall)
+}
+
+// PastPosition reports whether the oplog position ts, written at wall, follows the window.
+func (b Bounds) PastPosition(ts Timestamp, wall time.Time) bool {
+	if !b.To.IsZero() && ts.After(b.To) {
+		return true
+	}
+	return !b.Until.IsZero() && !wall.IsZero() && wall.After(b.Until)
+}
+
+// ReachedEnd reports whether the oplog position ts, written at wall, is at or past the end of the window.
+func (b Bounds) ReachedEnd(ts Timestamp, wall time.Time) bool {
+	if !b.To.IsZero() && !b.To.After(ts) {
+		return true
+	}
+	return !b.Until.IsZero() && !wall.IsZero() && wall.After(b.Until)
+}
+
+// MaxIncrement is the increment making a timestamp written as "T" include every entry of second T.
+const MaxIncrement uint32 = math.MaxUint32
+
+// ParseTimestamp parses a timestamp written as "T.I", as returned by Timestamp.String, or "T",
+// in which case the increment is defaultI.
+func ParseTimestamp(value string, defaultI uint32) (Timestamp, error) {
+	t, i, hasI := strings.Cut(value, ".")
+	seconds, err := strconv.ParseUint(t, 10, 32)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	increment := uint64(defaultI)
+	if hasI {
+		if increment, err = strconv.ParseUint(i, 10, 32); err != nil {
+			return Timestamp{}, fmt.Errorf("invalid timestamp %q", value)
+		}
+	}
+	return Timestamp{T: uint32(seconds), I: uint32(increment)}, nil
+}
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		defaultI uint32
+		want     Timestamp
+		wantErr  bool
+	}{
+		{name: "Seconds and increment", value: "1700000000.5", want: Timestamp{T: 1700000000, I: 5}},
+		{name: "Seconds of a lower bound", value: "1700000000", want: Timestamp{T: 1700000000}},
+		{name: "Seconds of an upper bound", value: "1700000000", defaultI: MaxIncrement, want: Timestamp{T: 1700000000, I: MaxIncrement}},
+		{name: "Invalid seconds", value: "yesterday", wantErr: true},
+		{name: "Invalid increment", value: "1700000000.x", wantErr: true},
+		{name: "Seconds out of range", value: "4294967296", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseTimestamp(test.value, test.defaultI)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseTimestamp error does not match the expected result.\nWant error: %v\nGot: %v", test.wantErr, err)
+			}
+			if got != test.want {
+				t.Errorf("Timestamp does not match the expected result.\nWant: %s\nGot: %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestBounds(t *testing.T) {
+	wall := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	bounds := Bounds{From: Timestamp{T: 10, I: 2}, To: Timestamp{T: 20, I: 1}, Until: wall}
+
+	tests := []struct {
+		name           string
+		entry          OplogEntry
+		wantBefore     bool
+		wantPast       bool
+		wantReachedEnd bool
+	}{
+		{name: "Before the window", entry: OplogEntry{Timestamp: Timestamp{T: 10, I: 1}}, wantBefore: true},
+		{name: "Lower bound", entry: OplogEntry{Timestamp: Timestamp{T: 10, I: 2}}},
+		{name: "Upper bound", entry: OplogEntry{Timestamp: Timestamp{T: 20, I: 1}}, wantReachedEnd: true},
+		{name: "Past the upper bound", entry: OplogEntry{Timestamp: Timestamp{T: 20, I: 2}}, wantPast: true, wantReachedEnd: true},
+		{name: "Wall time bound", entry: OplogEntry{Timestamp: Timestamp{T: 15}, Wall: wall}},
+		{name: "Past the wall time", entry: OplogEntry{Timestamp: Timestamp{T: 15}, Wall: wall.Add(time.Millisecond)}, wantPast: true, wantReachedEnd: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before, past := bounds.Before(test.entry), bounds.Past(test.entry)
+			reached := bounds.ReachedEnd(test.entry.Timestamp, test.entry.Wall)
+			if before != test.wantBefore || past != test.wantPast || reached != test.wantReachedEnd {
+				t.Errorf(
+					"Bounds do not match the expected result.\nWant: before %v, past %v, reached end %v\nGot: before %v, past %v, reached end %v",
+					test.wantBefore, test.wantPast, test.wantReachedEnd, before, past, reached,
+				)
+			}
+		})
+	}
+
+	if (Bounds{}).HasUpper() || !(Bounds{Until: wall}).HasUpper() || !(Bounds{To: Timestamp{T: 1}}).HasUpper() {
+		t.Errorf("HasUpper does not match the expected result")
+	}
+}
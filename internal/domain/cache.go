@@ -1,14 +1,25 @@
 package domain
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 type Cache interface {
 	Get(key string) bool
 	LoadOrStore(key string, value bool) bool
+	// ColumnType returns the SQL type of the column of the table, if the column is known.
+	ColumnType(table, column string) (string, bool)
+	// SetColumnType records the SQL type of the column of the table.
+	SetColumnType(table, column, dataType string)
+	// Columns returns the sorted names of the known columns of the table.
+	Columns(table string) []string
 }
 
 type cache struct {
 	dataMap sync.Map
+	// columns maps the name of a table to the sync.Map of the types of its columns.
+	columns sync.Map
 }
 
 func NewCache() Cache {
@@ -27,3 +38,31 @@ func (c *cache) LoadOrStore(key string, value bool) bool {
 	_, loaded := c.dataMap.LoadOrStore(key, value)
 	return loaded
 }
+
+func (c *cache) ColumnType(table, column string) (string, bool) {
+	columns, ok := c.columns.Load(table)
+	if !ok {
+		return "", false
+	}
+	if val, ok := columns.(*sync.Map).Load(column); ok {
+		return val.(string), true
+	}
+	return "", false
+}
+
+func (c *cache) SetColumnType(table, column, dataType string) {
+	columns, _ := c.columns.LoadOrStore(table, &sync.Map{})
+	columns.(*sync.Map).Store(column, dataType)
+}
+
+func (c *cache) Columns(table string) []string {
+	names := []string{}
+	if columns, ok := c.columns.Load(table); ok {
+		columns.(*sync.Map).Range(func(column, _ any) bool {
+			names = append(names, column.(string))
+			return true
+		})
+	}
+	sort.Strings(names)
+	return names
+}
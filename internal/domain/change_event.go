@@ -0,0 +1,241 @@
+package domain
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Operations of the change events.
+const (
+	CHANGE_EVENT_CREATE string = "c"
+	CHANGE_EVENT_UPDATE string = "u"
+	CHANGE_EVENT_DELETE string = "d"
+)
+
+// Version is the version of the build, set at link time with
+// -ldflags "-X github.com/one2nc/mongo-oplog-to-sql/internal/domain.Version=<version>".
+var Version string
+
+// BuildVersion returns the version of the build, which is the version of the module when it is
+// installed with go install and the Version is not set.
+func BuildVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// ChangeEvent is an oplog entry in the envelope of the change events of the Debezium MongoDB
+// connector. Documents are serialized as relaxed Extended JSON strings, as Debezium does.
+// The oplog holds the diff of an update rather than the document, so the after of an update
+// is null and its changes are described by the UpdateDescription, and the before of a delete
+// is null. The Key is the key Debezium gives to the message of the event.
+type ChangeEvent struct {
+	Key               ChangeEventKey     `json:"key"`
+	Before            *string            `json:"before"`
+	After             *string            `json:"after"`
+	UpdateDescription *UpdateDescription `json:"updateDescription"`
+	Source            ChangeEventSource  `json:"source"`
+	Op                string             `json:"op"`
+	TsMs              int64              `json:"ts_ms"`
+}
+
+// ChangeEventKey identifies the document of a change event.
+type ChangeEventKey struct {
+	ID string `json:"id"`
+}
+
+// UpdateDescription lists the fields changed by an update, by their dotted path.
+type UpdateDescription struct {
+	RemovedFields   []string         `json:"removedFields"`
+	UpdatedFields   string           `json:"updatedFields"`
+	TruncatedArrays []TruncatedArray `json:"truncatedArrays"`
+}
+
+// TruncatedArray is an array truncated to a new size by an update.
+type TruncatedArray struct {
+	Field string `json:"field"`
+	Size  int    `json:"size"`
+}
+
+// ChangeEventSource is the source block of a change event, locating the entry in the oplog.
+type ChangeEventSource struct {
+	Version    string  `json:"version"`
+	Connector  string  `json:"connector"`
+	Name       string  `json:"name"`
+	TsMs       int64   `json:"ts_ms"`
+	Snapshot   string  `json:"snapshot"`
+	Db         string  `json:"db"`
+	Sequence   *string `json:"sequence"`
+	Rs         string  `json:"rs"`
+	Collection string  `json:"collection"`
+	Ord        uint32  `json:"ord"`
+	Lsid       *string `json:"lsid"`
+	TxnNumber  *int64  `json:"txnNumber"`
+	WallTime   *int64  `json:"wallTime"`
+}
+
+// NewChangeEvent converts the oplog entry into a change event of the server name, the prefix
+// of the Debezium topics, processed at now.
+func NewChangeEvent(entry OplogEntry, serverName string, now time.Time) (ChangeEvent, error) {
+	if err := entry.Validate(); err != nil {
+		return ChangeEvent{}, err
+	}
+
+	event := ChangeEvent{
+		Source: ChangeEventSource{
+			Version:    BuildVersion(),
+			Connector:  "mongodb",
+			Name:       serverName,
+			TsMs:       int64(entry.Timestamp.T) * 1000,
+			Snapshot:   "false",
+			Db:         entry.SchemaName(),
+			Collection: strings.SplitN(entry.Namespace, SEPERATOR, 2)[1],
+			Ord:        entry.Timestamp.I,
+			TxnNumber:  entry.TxnNumber,
+		},
+		TsMs: now.UnixMilli(),
+	}
+	if !entry.Wall.IsZero() {
+		wallTime := entry.Wall.UnixMilli()
+		event.Source.WallTime = &wallTime
+	}
+
+	var id interface{}
+	var err error
+	switch entry.Operation {
+	case "i":
+		event.Op = CHANGE_EVENT_CREATE
+		id = entry.Object["_id"]
+		var after string
+		if after, err = extendedJSON(entry.Object); err != nil {
+			return ChangeEvent{}, err
+		}
+		event.After = &after
+	case "u":
+		event.Op = CHANGE_EVENT_UPDATE
+		id = entry.Object2["_id"]
+		if event.UpdateDescription, err = newUpdateDescription(entry.Object["diff"].(map[string]interface{})); err != nil {
+			return ChangeEvent{}, err
+		}
+	case "d":
+		event.Op = CHANGE_EVENT_DELETE
+		id = entry.Object["_id"]
+	}
+
+	if id == nil {
+		return ChangeEvent{}, fmt.Errorf("entry without _id")
+	}
+	if event.Key.ID, err = extendedJSONValue(id); err != nil {
+		return ChangeEvent{}, err
+	}
+	return event, nil
+}
+
+// newUpdateDescription flattens the diff of an update into the dotted paths of the fields it
+// sets, removes and truncates.
+func newUpdateDescription(diffMap map[string]interface{}) (*UpdateDescription, error) {
+	description := &UpdateDescription{RemovedFields: []string{}, TruncatedArrays: []TruncatedArray{}}
+	updatedFields := make(map[string]interface{})
+	describeDiff("", diffMap, description, updatedFields)
+
+	sort.Strings(description.RemovedFields)
+	sort.Slice(description.TruncatedArrays, func(i, j int) bool {
+		return description.TruncatedArrays[i].Field < description.TruncatedArrays[j].Field
+	})
+
+	var err error
+	description.UpdatedFields, err = extendedJSON(updatedFields)
+	return description, err
+}
+
+func describeDiff(prefix string, diffMap map[string]interface{}, description *UpdateDescription, updatedFields map[string]interface{}) {
+	if isArray, _ := diffMap["a"].(bool); isArray {
+		if length, ok := diffMap["l"]; ok {
+			if size, err := strconv.Atoi(fmt.Sprintf("%v", length)); err == nil {
+				description.TruncatedArrays = append(
+					description.TruncatedArrays,
+					TruncatedArray{Field: strings.TrimSuffix(prefix, "."), Size: size},
+				)
+			}
+		}
+	}
+
+	for key, value := range diffMap {
+		switch {
+		case key == "d":
+			if fields, ok := value.(map[string]interface{}); ok {
+				for field := range fields {
+					description.RemovedFields = append(description.RemovedFields, prefix+field)
+				}
+			}
+		case key == "u" || key == "i":
+			if fields, ok := value.(map[string]interface{}); ok {
+				for field, fieldValue := range fields {
+					updatedFields[prefix+field] = fieldValue
+				}
+			}
+		case len(key) > 1 && key[0] == 'u':
+			// replaced element of an array
+			updatedFields[prefix+key[1:]] = value
+		case len(key) > 1 && key[0] == 's':
+			if subDiff, ok := value.(map[string]interface{}); ok {
+				describeDiff(prefix+key[1:]+".", subDiff, description, updatedFields)
+			}
+		}
+	}
+}
+
+// extendedJSON returns the relaxed Extended JSON of a document, with its fields sorted and
+// its _id first.
+func extendedJSON(document map[string]interface{}) (string, error) {
+	data, err := bson.MarshalExtJSON(sortedDocument(document), false, false)
+	return string(data), err
+}
+
+// extendedJSONValue returns the relaxed Extended JSON of a value, such as an _id.
+func extendedJSONValue(value interface{}) (string, error) {
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: sortedValue(value)}}, false, false)
+	if err != nil {
+		return "", err
+	}
+	// strip the {"v": ...} wrapper required to marshal a document
+	return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"v":`), "}"), nil
+}
+
+func sortedDocument(document map[string]interface{}) bson.D {
+	sorted := make(bson.D, 0, len(document))
+	if id, ok := document["_id"]; ok {
+		sorted = append(sorted, bson.E{Key: "_id", Value: sortedValue(id)})
+	}
+	for _, key := range sortColumns(document) {
+		if key != "_id" {
+			sorted = append(sorted, bson.E{Key: key, Value: sortedValue(document[key])})
+		}
+	}
+	return sorted
+}
+
+func sortedValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return sortedDocument(v)
+	case []interface{}:
+		array := make(bson.A, len(v))
+		for i, element := range v {
+			array[i] = sortedValue(element)
+		}
+		return array
+	default:
+		return value
+	}
+}
@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewChangeEvent(t *testing.T) {
+	Version = "1.2.3"
+	defer func() { Version = "" }()
+
+	id, _ := primitive.ObjectIDFromHex("635b79e231d82a8ab1de863b")
+	txnNumber := int64(7)
+	tests := []struct {
+		name  string
+		entry OplogEntry
+		want  string
+	}{
+		{
+			name: "Insert",
+			entry: OplogEntry{
+				Operation: "i",
+				Namespace: "test.student",
+				Object: map[string]interface{}{
+					"_id":     id,
+					"name":    "Selena Miller",
+					"address": map[string]interface{}{"zip": "89799", "city": "Springfield"},
+					"tags":    []interface{}{"a", int32(1)},
+				},
+				Timestamp: Timestamp{T: 1700000000, I: 2},
+				Wall:      time.Date(2023, 11, 14, 22, 13, 20, 500000000, time.UTC),
+			},
+			want: `{"key":{"id":"{\"$oid\":\"635b79e231d82a8ab1de863b\"}"},"before":null,"after":"{\"_id\":{\"$oid\":\"635b79e231d82a8ab1de863b\"},\"address\":{\"city\":\"Springfield\",\"zip\":\"89799\"},\"name\":\"Selena Miller\",\"tags\":[\"a\",1]}","updateDescription":null,"source":{"version":"1.2.3","connector":"mongodb","name":"server","ts_ms":1700000000000,"snapshot":"false","db":"test","sequence":null,"rs":"","collection":"student","ord":2,"lsid":null,"txnNumber":null,"wallTime":1700000000500},"op":"c","ts_ms":1700000001000}`,
+		},
+		{
+			name: "Update of nested fields and arrays",
+			entry: OplogEntry{
+				Operation: "u",
+				Namespace: "test.student",
+				Object: map[string]interface{}{
+					"$v": int32(2),
+					"diff": map[string]interface{}{
+						"u": map[string]interface{}{"name": "Selena"},
+						"d": map[string]interface{}{"age": false},
+						"saddress": map[string]interface{}{
+							"i": map[string]interface{}{"line2": "Apt 1"},
+							"d": map[string]interface{}{"zip": false},
+						},
+						"stags": map[string]interface{}{"a": true, "l": int32(1), "u0": "b"},
+						"sphones": map[string]interface{}{
+							"a":  true,
+							"s1": map[string]interface{}{"u": map[string]interface{}{"work": "8130097989"}},
+						},
+					},
+				},
+				Object2:   map[string]interface{}{"_id": "s1"},
+				Timestamp: Timestamp{T: 1700000000, I: 3},
+				TxnNumber: &txnNumber,
+			},
+			want: `{"key":{"id":"\"s1\""},"before":null,"after":null,"updateDescription":{"removedFields":["address.zip","age"],"updatedFields":"{\"address.line2\":\"Apt 1\",\"name\":\"Selena\",\"phones.1.work\":\"8130097989\",\"tags.0\":\"b\"}","truncatedArrays":[{"field":"tags","size":1}]},"source":{"version":"1.2.3","connector":"mongodb","name":"server","ts_ms":1700000000000,"snapshot":"false","db":"test","sequence":null,"rs":"","collection":"student","ord":3,"lsid":null,"txnNumber":7,"wallTime":null},"op":"u","ts_ms":1700000001000}`,
+		},
+		{
+			name: "Delete",
+			entry: OplogEntry{
+				Operation: "d",
+				Namespace: "test.student",
+				Object:    map[string]interface{}{"_id": int64(42)},
+				Timestamp: Timestamp{T: 1700000000, I: 4},
+			},
+			want: `{"key":{"id":"42"},"before":null,"after":null,"updateDescription":null,"source":{"version":"1.2.3","connector":"mongodb","name":"server","ts_ms":1700000000000,"snapshot":"false","db":"test","sequence":null,"rs":"","collection":"student","ord":4,"lsid":null,"txnNumber":null,"wallTime":null},"op":"d","ts_ms":1700000001000}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := NewChangeEvent(test.entry, "server", time.UnixMilli(1700000001000))
+			if err != nil {
+				t.Fatalf("NewChangeEvent failed: %v", err)
+			}
+			got, err := json.Marshal(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != test.want {
+				t.Errorf("Change event does not match the expected result.\nWant: %s\nGot: %s", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package domain
+
+import "sync"
+
+// CheckpointCoordinator tracks the oplogs dispatched to every stream of the writers and the
+// position committed in each stream, to derive the lowest fully committed ts of the oplog.
+// Every oplog at or before that ts has been committed by its writer, so it is a single
+// position from which the whole oplog can resume. It is the only owner of that watermark:
+// the writers only commit the positions of their streams, which are applied in oplog order
+// within a stream, while the streams of a writer may be applied out of oplog order.
+type CheckpointCoordinator struct {
+	mu sync.Mutex
+
+	// pending holds the dispatched oplogs which are not part of the watermark yet, in oplog order.
+	pending   []dispatchedOplog
+	committed map[string]Timestamp
+	watermark Timestamp
+
+	// subscribers are notified of every advance of the watermark
+	subscribers []func(Timestamp)
+}
+
+type dispatchedOplog struct {
+	stream string
+	ts     Timestamp
+}
+
+// StreamKey returns the name under which the coordinator tracks the stream of the writer,
+// the oplogs of a collection for the per-database writers, or every oplog of the writer when
+// stream is empty.
+func StreamKey(writer, stream string) string {
+	if stream == "" {
+		return writer
+	}
+	return writer + "/" + stream
+}
+
+// NewCheckpointCoordinator creates a new instance of CheckpointCoordinator starting at the given checkpoint.
+func NewCheckpointCoordinator(checkpoint Timestamp) *CheckpointCoordinator {
+	return &CheckpointCoordinator{
+		committed: make(map[string]Timestamp),
+		watermark: checkpoint,
+	}
+}
+
+// Track forwards the oplogs to the returned channel, recording for each of them the key of
+// the stream it is dispatched to. Oplogs must be tracked in oplog order.
+func (c *CheckpointCoordinator) Track(
+	oplogChan <-chan OplogEntry,
+	streamKey func(OplogEntry) string,
+) <-chan OplogEntry {
+	trackedChan := make(chan OplogEntry, cap(oplogChan))
+
+	go func() {
+		defer close(trackedChan)
+
+		for oplog := range oplogChan {
+			c.Dispatch(streamKey(oplog), oplog.Timestamp)
+			trackedChan <- oplog
+		}
+	}()
+
+	return trackedChan
+}
+
+// Dispatch records that the oplog at ts is dispatched to the stream of the given key. Oplogs
+// must be dispatched in oplog order.
+func (c *CheckpointCoordinator) Dispatch(stream string, ts Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending = append(c.pending, dispatchedOplog{stream: stream, ts: ts})
+}
+
+// Watermark returns the lowest fully committed ts of the oplog once the streams of the given
+// keys have committed up to their ts, without recording the commit.
+func (c *CheckpointCoordinator) Watermark(positions map[string]Timestamp) Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	watermark, _ := c.advance(positions)
+	return watermark
+}
+
+// Subscribe registers fn to be called with the watermark every time it advances.
+func (c *CheckpointCoordinator) Subscribe(fn func(watermark Timestamp)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscribers = append(c.subscribers, fn)
+}
+
+// Commit records that the streams of the given keys have committed every oplog up to their
+// ts and returns the lowest fully committed ts of the oplog.
+func (c *CheckpointCoordinator) Commit(positions map[string]Timestamp) Timestamp {
+	c.mu.Lock()
+	for stream, ts := range positions {
+		if ts.After(c.committed[stream]) {
+			c.committed[stream] = ts
+		}
+	}
+	watermark, n := c.advance(nil)
+	c.pending = c.pending[n:]
+	advanced := watermark.After(c.watermark)
+	c.watermark = watermark
+	subscribers := c.subscribers
+	c.mu.Unlock()
+
+	if advanced {
+		for _, fn := range subscribers {
+			fn(watermark)
+		}
+	}
+	return watermark
+}
+
+// advance returns the watermark, once the streams of the given keys have committed up to their
+// ts, and the number of pending oplogs it covers.
+func (c *CheckpointCoordinator) advance(positions map[string]Timestamp) (Timestamp, int) {
+	watermark := c.watermark
+
+	n := 0
+	for _, oplog := range c.pending {
+		committed := c.committed[oplog.stream]
+		if ts, ok := positions[oplog.stream]; ok && ts.After(committed) {
+			committed = ts
+		}
+
+		if oplog.ts.After(committed) {
+			break
+		}
+		watermark = oplog.ts
+		n++
+	}
+
+	return watermark, n
+}
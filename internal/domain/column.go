@@ -1,6 +1,19 @@
 package domain
 
-import "fmt"
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlExpression is a value given as an SQL expression, such as a sub-query.
+type sqlExpression string
 
 type Column struct {
 	dataType string
@@ -17,28 +30,159 @@ func (c Column) PrimaryKey() bool {
 }
 
 func getColumnValue(value interface{}) string {
-	switch value.(type) {
-	case int, int8, int16, int32, int64, float32, float64:
+	switch v := value.(type) {
+	case float32:
+		return floatValue(float64(v), 32)
+	case float64:
+		return floatValue(v, 64)
+	case int, int8, int16, int32, int64:
 		return fmt.Sprintf("%v", value)
 	case bool:
 		return fmt.Sprintf("%t", value)
+	case string:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(v, "'", "''"))
+	case primitive.ObjectID:
+		return fmt.Sprintf("'%s'", v.Hex())
+	case primitive.DateTime:
+		return fmt.Sprintf("'%s'", v.Time().UTC().Format(time.RFC3339Nano))
+	case time.Time:
+		return fmt.Sprintf("'%s'", v.UTC().Format(time.RFC3339Nano))
+	case primitive.Decimal128:
+		return fmt.Sprintf("'%s'", v.String())
+	case primitive.Binary:
+		return fmt.Sprintf("'\\x%s'", hex.EncodeToString(v.Data))
+	case primitive.Timestamp:
+		return fmt.Sprintf("%d", uint64(v.T)<<32|uint64(v.I))
+	case primitive.Null, primitive.Undefined:
+		return "NULL"
+	case sqlExpression:
+		return string(v)
+	case auditValue:
+		if v.value == nil {
+			return "NULL"
+		}
+		return getColumnValue(v.value)
+	case nil:
+		return "NULL"
+	default:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(fmt.Sprint(value), "'", "''"))
+	}
+}
+
+// rowValue returns the value as the text PostgreSQL reads the value of its column from, nil
+// standing for NULL, like getColumnValue returns its literal. It reports false for the SQL
+// expressions, which have no text, but the replication time, which is the current time.
+func rowValue(value interface{}) (*string, bool) {
+	var text string
+	switch v := value.(type) {
+	case float32:
+		text = floatText(float64(v), 32)
+	case float64:
+		text = floatText(v, 64)
+	case string:
+		text = v
+	case primitive.ObjectID:
+		text = v.Hex()
+	case primitive.DateTime:
+		text = v.Time().UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		text = v.UTC().Format(time.RFC3339Nano)
+	case primitive.Decimal128:
+		text = v.String()
+	case primitive.Binary:
+		text = "\\x" + hex.EncodeToString(v.Data)
+	case primitive.Timestamp:
+		text = fmt.Sprintf("%d", uint64(v.T)<<32|uint64(v.I))
+	case primitive.Null, primitive.Undefined, nil:
+		return nil, true
+	case sqlExpression:
+		if v != replicationTime {
+			return nil, false
+		}
+		text = time.Now().UTC().Format(time.RFC3339Nano)
+	case auditValue:
+		return rowValue(v.value)
 	default:
-		return fmt.Sprintf("'%v'", value)
+		// integers and booleans print as their literal, the other values as a string
+		text = fmt.Sprint(value)
+	}
+	return &text, true
+}
+
+// floatText returns the text of a float of the given bit size, the special values included.
+func floatText(v float64, bitSize int) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return strconv.FormatFloat(v, 'g', -1, bitSize)
+}
+
+// floatValue returns the literal of a float of the given bit size, the special values having no
+// numeric literal.
+func floatValue(v float64, bitSize int) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("'%s'::double precision", floatText(v, bitSize))
+	}
+	return floatText(v, bitSize)
+}
+
+// valueKind returns the kind of the value, which is Invalid for a null value.
+func valueKind(value interface{}) reflect.Kind {
+	if value == nil {
+		return reflect.Invalid
 	}
+	return reflect.TypeOf(value).Kind()
 }
 
 func getColumnSQLDataType(columnName string, value interface{}) string {
 	var columnDataType string
 	switch value.(type) {
-	case int, int8, int16, int32, int64:
+	case int, int8, int16, int32:
 		columnDataType = "INTEGER"
+	case int64, primitive.Timestamp:
+		columnDataType = "BIGINT"
 	case float32, float64:
 		columnDataType = "FLOAT"
 	case bool:
 		columnDataType = "BOOLEAN"
+	case primitive.DateTime, time.Time:
+		columnDataType = "TIMESTAMPTZ"
+	case primitive.Decimal128:
+		columnDataType = "NUMERIC"
+	case primitive.Binary:
+		columnDataType = "BYTEA"
+	case auditValue:
+		columnDataType = value.(auditValue).dataType
 	default:
-		// For simplicity, treat all non-numeric values as string
+		// For simplicity, treat all non-numeric values as string, ObjectIds included
 		columnDataType = "VARCHAR(255)"
 	}
 	return columnDataType
 }
+
+// numericWidening is the narrowest numeric type holding the values of both types of a pair.
+var numericWidening = map[[2]string]string{
+	{"INTEGER", "BIGINT"}:  "BIGINT",
+	{"INTEGER", "FLOAT"}:   "FLOAT",
+	{"INTEGER", "NUMERIC"}: "NUMERIC",
+	{"BIGINT", "FLOAT"}:    "NUMERIC",
+	{"BIGINT", "NUMERIC"}:  "NUMERIC",
+	{"FLOAT", "NUMERIC"}:   "NUMERIC",
+}
+
+// widenDataType returns the type of a column of the current type which has to hold a value of
+// the next type: the narrowest numeric type holding both, or the current type otherwise.
+func widenDataType(current, next string) string {
+	if widened, ok := numericWidening[[2]string{current, next}]; ok {
+		return widened
+	}
+	if widened, ok := numericWidening[[2]string{next, current}]; ok {
+		return widened
+	}
+	return current
+}
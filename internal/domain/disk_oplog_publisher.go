@@ -0,0 +1,566 @@
+package domain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+const (
+	segmentSuffix = ".log"
+	// recordHeaderSize is the size of the length and the CRC-32 preceding every record.
+	recordHeaderSize = 8
+	// maxRecordSize bounds the size of a record, which holds at most two 16MiB documents.
+	maxRecordSize = 48 * 1024 * 1024
+)
+
+// ErrPublisherClosed is returned when publishing to a closed publisher.
+var ErrPublisherClosed = errors.New("oplog publisher closed")
+
+// spoolRegistry decodes the spooled documents into the plain map and slice types handled by the
+// parser, while the other BSON types keep their primitive types.
+var spoolRegistry = func() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(map[string]interface{}{}))
+	registry.RegisterTypeMapEntry(bsontype.Array, reflect.TypeOf([]interface{}{}))
+	return registry
+}()
+
+// spooledOplog is the BSON form of a spooled oplog entry.
+type spooledOplog struct {
+	Operation string                 `bson:"op"`
+	Namespace string                 `bson:"ns"`
+	Object    map[string]interface{} `bson:"o"`
+	Object2   map[string]interface{} `bson:"o2"`
+	T         uint32                 `bson:"t"`
+	I         uint32                 `bson:"i"`
+	Wall      time.Time              `bson:"wall"`
+	TxnNumber *int64                 `bson:"txn,omitempty"`
+}
+
+// segment is a file of the spool, holding the records in oplog order.
+type segment struct {
+	path   string
+	size   int64
+	read   int64
+	lastTS Timestamp
+}
+
+// DiskOplogPublisher is an OplogPublisher buffering the oplog entries in an append-only log of
+// segment files, so that the reader drains MongoDB at full speed while the writers catch up,
+// and the buffered entries survive a restart. A segment is removed once it has been read and
+// every entry of it has been acknowledged by the writers.
+type DiskOplogPublisher struct {
+	config        config.SpoolConfig
+	metrics       Metrics
+	logger        *slog.Logger
+	channel       chan OplogEntry
+	done          chan struct{}
+	consumerGroup sync.WaitGroup
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// segments are in oplog order, records are appended to the last one.
+	segments  []*segment
+	file      *os.File
+	readIndex int
+	nextID    uint64
+	total     int64
+	lastTS    Timestamp
+	ackTS     Timestamp
+	dirty     bool
+	stopped   bool
+	closed    bool
+	// err is the failure to read the spool back, which stops the delivery
+	err error
+}
+
+// NewDiskOplogPublisher opens the spool in cfg.Dir, keeping the entries buffered by a previous run,
+// and delivers them through a channel buffering up to size entries. The entries up to acknowledged
+// are committed already and not delivered again, the segments are kept until Acknowledge covers them.
+func NewDiskOplogPublisher(cfg config.SpoolConfig, size int, acknowledged Timestamp, m Metrics, logger *slog.Logger) (*DiskOplogPublisher, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	p := &DiskOplogPublisher{
+		config:  cfg,
+		metrics: m,
+		logger:  logger,
+		channel: make(chan OplogEntry, size),
+		done:    make(chan struct{}),
+		ackTS:   acknowledged,
+	}
+	m.OplogBuffer(func() int { return len(p.channel) })
+	p.cond = sync.NewCond(&p.mu)
+
+	if err := p.recover(); err != nil {
+		return nil, err
+	}
+	if err := p.rotate(); err != nil {
+		return nil, err
+	}
+	p.metrics.SpoolBytes(p.total)
+
+	if len(p.segments) > 1 {
+		logger.Info("resuming spooled oplogs", "dir", cfg.Dir, "bytes", p.total, "ts", p.lastTS.String())
+	}
+
+	p.consumerGroup.Add(1)
+	go p.consume()
+	if cfg.Fsync == config.FSYNC_INTERVAL {
+		p.consumerGroup.Add(1)
+		go p.syncPeriodically()
+	}
+	return p, nil
+}
+
+// LastTimestamp returns the ts of the last spooled entry, from which the reader resumes.
+func (p *DiskOplogPublisher) LastTimestamp() Timestamp {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lastTS
+}
+
+// PublishOplog appends the entry to the spool, waiting while the spool is full.
+func (p *DiskOplogPublisher) PublishOplog(entry OplogEntry) error {
+	payload, err := bson.Marshal(spooledOplog{
+		Operation: entry.Operation,
+		Namespace: entry.Namespace,
+		Object:    entry.Object,
+		Object2:   entry.Object2,
+		T:         entry.Timestamp.T,
+		I:         entry.Timestamp.I,
+		Wall:      entry.Wall,
+		TxnNumber: entry.TxnNumber,
+	})
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// a record larger than the spool is still written once every other segment is removed
+	for !p.closed && p.err == nil && p.config.MaxBytes > 0 && p.total+int64(len(record)) > p.config.MaxBytes && len(p.segments) > 1 {
+		p.cond.Wait()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if p.closed || p.stopped {
+		return ErrPublisherClosed
+	}
+
+	active := p.segments[len(p.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > p.config.SegmentBytes {
+		if err := p.rotate(); err != nil {
+			return err
+		}
+		active = p.segments[len(p.segments)-1]
+	}
+
+	if _, err := p.file.Write(record); err != nil {
+		return err
+	}
+	if p.config.Fsync == config.FSYNC_ALWAYS {
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+	} else {
+		p.dirty = true
+	}
+
+	active.size += int64(len(record))
+	active.lastTS = entry.Timestamp
+	p.lastTS = entry.Timestamp
+	p.total += int64(len(record))
+	p.metrics.SpoolBytes(p.total)
+
+	p.cond.Broadcast()
+	return nil
+}
+
+// GetOplogs returns the channel delivering the spooled entries in order.
+func (p *DiskOplogPublisher) GetOplogs() (<-chan OplogEntry, error) {
+	return p.channel, nil
+}
+
+// Stop marks the end of the published entries, the channel is closed once they are delivered.
+func (p *DiskOplogPublisher) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	p.cond.Broadcast()
+}
+
+// Acknowledge records that every entry up to ts has been committed, which removes the segments
+// it covers.
+func (p *DiskOplogPublisher) Acknowledge(ts Timestamp) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ts.After(p.ackTS) {
+		p.ackTS = ts
+		p.removeSegments()
+	}
+}
+
+// Err returns the error which stopped the delivery of the spooled entries, if any.
+func (p *DiskOplogPublisher) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Close stops delivering entries and syncs and closes the spool, keeping the entries which are
+// not removed yet for the next run.
+func (p *DiskOplogPublisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.consumerGroup.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return errors.Join(p.file.Sync(), p.file.Close())
+}
+
+// consume delivers the spooled records to the channel in order. A record which cannot be read
+// back stops the delivery, the channel being closed so that the pipeline stops with the error.
+func (p *DiskOplogPublisher) consume() {
+	defer p.consumerGroup.Done()
+
+	if err := p.deliver(); err != nil {
+		p.logger.Error("failed to read the spool", "error", err)
+
+		p.mu.Lock()
+		p.err = err
+		closed := p.closed
+		p.cond.Broadcast()
+		p.mu.Unlock()
+
+		if !closed {
+			close(p.channel)
+		}
+	}
+}
+
+// deliver sends the spooled records to the channel until the spool is stopped and drained or
+// closed.
+func (p *DiskOplogPublisher) deliver() error {
+	var reader *os.File
+	var readerPath string
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+	}()
+
+	for {
+		p.mu.Lock()
+		seg, more := p.nextReadable()
+		for seg == nil && more {
+			p.cond.Wait()
+			seg, more = p.nextReadable()
+		}
+		p.mu.Unlock()
+
+		if seg == nil {
+			if !p.isClosed() {
+				close(p.channel)
+			}
+			return nil
+		}
+
+		if readerPath != seg.path {
+			if reader != nil {
+				reader.Close()
+			}
+			var err error
+			if reader, err = os.Open(seg.path); err != nil {
+				return err
+			}
+			readerPath = seg.path
+		}
+
+		entry, n, err := readRecord(reader, seg.read)
+		if err != nil {
+			return fmt.Errorf("spool segment %s could not be read at offset %d: %w", seg.path, seg.read, err)
+		}
+
+		// the entries of a segment kept by the previous run may already be acknowledged
+		p.mu.Lock()
+		acknowledged := !entry.Timestamp.After(p.ackTS)
+		p.mu.Unlock()
+
+		if !acknowledged {
+			select {
+			case p.channel <- entry:
+			case <-p.done:
+				return nil
+			}
+		}
+
+		p.mu.Lock()
+		seg.read += n
+		p.removeSegments()
+		p.mu.Unlock()
+	}
+}
+
+// nextReadable returns the segment holding the next unread record, or nil and whether more
+// records may still come.
+func (p *DiskOplogPublisher) nextReadable() (*segment, bool) {
+	if p.closed {
+		return nil, false
+	}
+	for p.readIndex < len(p.segments) {
+		seg := p.segments[p.readIndex]
+		if seg.read < seg.size {
+			return seg, true
+		}
+		if p.readIndex == len(p.segments)-1 {
+			break
+		}
+		p.readIndex++
+		p.removeSegments()
+	}
+	return nil, !p.stopped
+}
+
+func (p *DiskOplogPublisher) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
+
+// removeSegments removes the sealed segments which have been read and acknowledged.
+func (p *DiskOplogPublisher) removeSegments() {
+	removed := false
+	for p.readIndex > 0 && len(p.segments) > 1 {
+		seg := p.segments[0]
+		if seg.lastTS.After(p.ackTS) {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			p.logger.Error("failed to remove spool segment", "path", seg.path, "error", err)
+			break
+		}
+		p.total -= seg.size
+		p.segments = p.segments[1:]
+		p.readIndex--
+		removed = true
+	}
+
+	if removed {
+		p.metrics.SpoolBytes(p.total)
+		p.cond.Broadcast()
+	}
+}
+
+// rotate seals the active segment and starts a new one.
+func (p *DiskOplogPublisher) rotate() error {
+	if p.file != nil {
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+		if err := p.file.Close(); err != nil {
+			return err
+		}
+		p.dirty = false
+	}
+
+	path := filepath.Join(p.config.Dir, fmt.Sprintf("%020d%s", p.nextID, segmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(p.config.Dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	p.file = file
+	p.nextID++
+	p.segments = append(p.segments, &segment{path: path, lastTS: p.lastTS})
+	return nil
+}
+
+// syncPeriodically syncs the active segment every FsyncInterval.
+func (p *DiskOplogPublisher) syncPeriodically() {
+	defer p.consumerGroup.Done()
+
+	ticker := time.NewTicker(p.config.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			if p.dirty {
+				if err := p.file.Sync(); err != nil {
+					p.logger.Error("failed to sync spool segment", "error", err)
+				}
+				p.dirty = false
+			}
+			p.mu.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// recover loads the segments left by a previous run, truncating a record torn by a crash.
+func (p *DiskOplogPublisher) recover() error {
+	dirEntries, err := os.ReadDir(p.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() && strings.HasSuffix(dirEntry.Name(), segmentSuffix) {
+			names = append(names, dirEntry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var id uint64
+		if _, err := fmt.Sscanf(name, "%d"+segmentSuffix, &id); err != nil {
+			return fmt.Errorf("unexpected file %s in spool directory", name)
+		}
+
+		seg, err := scanSegment(filepath.Join(p.config.Dir, name), p.logger)
+		if err != nil {
+			return err
+		}
+		p.nextID = id + 1
+		if seg.size == 0 {
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p.segments = append(p.segments, seg)
+		p.total += seg.size
+		p.lastTS = seg.lastTS
+	}
+	return nil
+}
+
+// scanSegment reads the records of a segment to find its size and last ts.
+func scanSegment(path string, logger *slog.Logger) (*segment, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seg := &segment{path: path}
+	for {
+		entry, n, err := readRecord(file, seg.size)
+		if errors.Is(err, io.EOF) {
+			return seg, nil
+		}
+		if err != nil {
+			logger.Warn("truncating torn spool segment", "path", path, "offset", seg.size, "error", err)
+			if err := file.Truncate(seg.size); err != nil {
+				return nil, err
+			}
+			return seg, file.Sync()
+		}
+		seg.size += n
+		seg.lastTS = entry.Timestamp
+	}
+}
+
+// readRecord reads the record at offset, returning io.EOF at the end of the segment.
+func readRecord(file *os.File, offset int64) (OplogEntry, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := file.ReadAt(header, offset)
+	if errors.Is(err, io.EOF) && n == 0 {
+		return OplogEntry{}, 0, io.EOF
+	}
+	if errors.Is(err, io.EOF) {
+		return OplogEntry{}, 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return OplogEntry{}, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return OplogEntry{}, 0, fmt.Errorf("invalid record size %d", size)
+	}
+	payload := make([]byte, size)
+	if _, err := file.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return OplogEntry{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return OplogEntry{}, 0, errors.New("record checksum mismatch")
+	}
+
+	var spooled spooledOplog
+	if err := bson.UnmarshalWithRegistry(spoolRegistry, payload, &spooled); err != nil {
+		return OplogEntry{}, 0, err
+	}
+	entry := OplogEntry{
+		Operation: spooled.Operation,
+		Namespace: spooled.Namespace,
+		Object:    spooled.Object,
+		Object2:   spooled.Object2,
+		Timestamp: Timestamp{T: spooled.T, I: spooled.I},
+		Wall:      spooled.Wall,
+		TxnNumber: spooled.TxnNumber,
+	}
+	return entry, int64(recordHeaderSize + len(payload)), nil
+}
+
+// syncDir syncs a directory so that the files created in it survive a crash.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
@@ -0,0 +1,248 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+)
+
+func TestDiskOplogPublisher(t *testing.T) {
+	tests := []struct {
+		name string
+		// segmentBytes of zero holds every record in a single segment
+		segmentBytes int64
+		// published are the ts of the entries published before the restart
+		published []uint32
+		// read is the number of entries received before the restart
+		read int
+		// acknowledged is the ts committed by the writers before the restart
+		acknowledged uint32
+		// tear appends a partial record to the last segment before the restart
+		tear bool
+		// want are the ts of the entries delivered after the restart
+		want []uint32
+		// wantSegments is the number of segments left before the restart
+		wantSegments int
+	}{
+		{
+			name:         "Read entries are kept until acknowledged",
+			published:    []uint32{1, 2, 3},
+			read:         3,
+			want:         []uint32{1, 2, 3},
+			wantSegments: 1,
+		},
+		{
+			name:         "Acknowledged entries are not delivered again",
+			published:    []uint32{1, 2, 3},
+			read:         3,
+			acknowledged: 2,
+			want:         []uint32{3},
+			wantSegments: 1,
+		},
+		{
+			name:         "Rotated segments are removed once read and acknowledged",
+			segmentBytes: 1,
+			published:    []uint32{1, 2, 3, 4},
+			read:         4,
+			acknowledged: 2,
+			want:         []uint32{3, 4},
+			wantSegments: 2,
+		},
+		{
+			name:         "Torn record is truncated",
+			published:    []uint32{1, 2},
+			tear:         true,
+			want:         []uint32{1, 2},
+			wantSegments: 1,
+		},
+		{
+			name:         "Torn record of a rotated segment is truncated",
+			segmentBytes: 1,
+			published:    []uint32{1, 2},
+			read:         2,
+			acknowledged: 1,
+			tear:         true,
+			want:         []uint32{2},
+			wantSegments: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.SpoolConfig{
+				Dir:          t.TempDir(),
+				SegmentBytes: test.segmentBytes,
+				Fsync:        config.FSYNC_NEVER,
+			}
+			if cfg.SegmentBytes == 0 {
+				cfg.SegmentBytes = 1 << 20
+			}
+
+			publisher := openSpool(t, cfg, Timestamp{})
+			for _, ts := range test.published {
+				if err := publisher.PublishOplog(spoolTestEntry(ts)); err != nil {
+					t.Fatalf("PublishOplog failed: %v", err)
+				}
+			}
+			oplogChan, _ := publisher.GetOplogs()
+			for i := 0; i < test.read; i++ {
+				receiveOplog(t, oplogChan)
+			}
+			publisher.Acknowledge(Timestamp{T: test.acknowledged})
+			if err := publisher.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			// the active segment left empty by the rotation is not a segment of the spool
+			segments := spoolSegments(t, cfg.Dir)
+			if nonEmpty := countNonEmpty(t, segments); nonEmpty != test.wantSegments {
+				t.Errorf("Segments do not match the expected result.\nWant: %d\nGot: %d", test.wantSegments, nonEmpty)
+			}
+			if test.tear {
+				tearSegment(t, lastNonEmpty(t, segments))
+			}
+
+			publisher = openSpool(t, cfg, Timestamp{T: test.acknowledged})
+			defer publisher.Close()
+			if err := publisher.PublishOplog(spoolTestEntry(10)); err != nil {
+				t.Fatalf("PublishOplog after restart failed: %v", err)
+			}
+			publisher.Stop()
+
+			got := []uint32{}
+			oplogChan, _ = publisher.GetOplogs()
+			for entry := range oplogChan {
+				got = append(got, entry.Timestamp.T)
+			}
+			if err := publisher.Err(); err != nil {
+				t.Fatalf("Spool failed: %v", err)
+			}
+
+			want := append(append([]uint32{}, test.want...), 10)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Delivered oplogs do not match the expected result.\nWant: %v\nGot: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestDiskOplogPublisherEntry(t *testing.T) {
+	txnNumber := int64(7)
+	entry := OplogEntry{
+		Operation: "u",
+		Namespace: "test.student",
+		Object: map[string]interface{}{
+			"$v":   int32(2),
+			"diff": map[string]interface{}{"u": map[string]interface{}{"tags": []interface{}{"a", int32(1)}}},
+		},
+		Object2:   map[string]interface{}{"_id": "635b79e231d82a8ab1de863b"},
+		Timestamp: Timestamp{T: 1, I: 2},
+		Wall:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		TxnNumber: &txnNumber,
+	}
+
+	publisher := openSpool(t, config.SpoolConfig{Dir: t.TempDir(), SegmentBytes: 1 << 20, Fsync: config.FSYNC_ALWAYS}, Timestamp{})
+	defer publisher.Close()
+	if err := publisher.PublishOplog(entry); err != nil {
+		t.Fatalf("PublishOplog failed: %v", err)
+	}
+
+	oplogChan, _ := publisher.GetOplogs()
+	if got := receiveOplog(t, oplogChan); !reflect.DeepEqual(got, entry) {
+		t.Errorf("Spooled oplog does not match the expected result.\nWant: %+v\nGot: %+v", entry, got)
+	}
+	if got := publisher.LastTimestamp(); got != entry.Timestamp {
+		t.Errorf("Last timestamp does not match the expected result.\nWant: %s\nGot: %s", entry.Timestamp, got)
+	}
+}
+
+func openSpool(t *testing.T, cfg config.SpoolConfig, acknowledged Timestamp) *DiskOplogPublisher {
+	t.Helper()
+
+	publisher, err := NewDiskOplogPublisher(cfg, 100, acknowledged, NopMetrics{}, logging.NewNopLogger())
+	if err != nil {
+		t.Fatalf("NewDiskOplogPublisher failed: %v", err)
+	}
+	return publisher
+}
+
+func spoolTestEntry(ts uint32) OplogEntry {
+	return OplogEntry{
+		Operation: "i",
+		Namespace: "test.student",
+		Object:    map[string]interface{}{"_id": "s1", "n": int32(ts)},
+		Timestamp: Timestamp{T: ts},
+	}
+}
+
+func receiveOplog(t *testing.T, oplogChan <-chan OplogEntry) OplogEntry {
+	t.Helper()
+
+	select {
+	case entry := <-oplogChan:
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatal("no oplog delivered")
+		return OplogEntry{}
+	}
+}
+
+func spoolSegments(t *testing.T, dir string) []string {
+	t.Helper()
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(segments)
+	return segments
+}
+
+func countNonEmpty(t *testing.T, segments []string) int {
+	t.Helper()
+
+	n := 0
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func lastNonEmpty(t *testing.T, segments []string) string {
+	t.Helper()
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		if info, err := os.Stat(segments[i]); err == nil && info.Size() > 0 {
+			return segments[i]
+		}
+	}
+	t.Fatal("no spooled segment")
+	return ""
+}
+
+// tearSegment appends the beginning of a record to the segment, as a crash while writing it does.
+func tearSegment(t *testing.T, path string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write([]byte{0x40, 0, 0, 0, 0xde, 0xad, 0xbe, 0xef, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,334 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DOCUMENT_COLUMN is the JSONB column holding the documents of a collection in jsonb mode.
+const DOCUMENT_COLUMN string = "doc"
+
+// generateDocumentTableAndInsertSQL stores the document of a jsonb mode collection in the
+// DOCUMENT_COLUMN along with its _id and promoted fields.
+func generateDocumentTableAndInsertSQL(
+	namespace string,
+	cache Cache,
+	mapping entryMapping,
+	data map[string]interface{},
+) ([]string, error) {
+	docText, err := jsonText(data)
+	if err != nil {
+		return nil, err
+	}
+	doc := fmt.Sprintf("%s::jsonb", getColumnValue(docText))
+
+	columns := promotedColumns(data, mapping.Promote)
+	columns["_id"] = data["_id"]
+	mapping.addAuditColumns(columns)
+
+	var systemColumns []string
+	if mapping.SoftDeletes() {
+		systemColumns = softDeleteColumns()
+	}
+
+	sqlStatements := []string{}
+	if !cache.LoadOrStore(namespace, true) {
+		sqlStatements = append(sqlStatements, generateCreateDocumentTableSQL(namespace, cache, systemColumns, columns))
+	} else if isEligibleForAlterTable(namespace, cache, columns) {
+		sqlStatements = append(sqlStatements, generateAlterTableSQL(namespace, cache, columns))
+	}
+
+	columnNames := []string{"_id", DOCUMENT_COLUMN}
+	values := []string{getColumnValue(data["_id"]), doc}
+	for _, columnName := range sortColumns(columns) {
+		if columnName == "_id" {
+			continue
+		}
+		columnNames = append(columnNames, columnName)
+		values = append(values, getColumnValue(columns[columnName]))
+	}
+
+	insertSQL := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s);",
+		namespace,
+		strings.Join(columnNames, ", "),
+		strings.Join(values, ", "),
+	)
+	if mapping.SoftDeletes() {
+		insertSQL = undeleteInsertSQL(insertSQL, namespace, cache, columnNames)
+	} else if mapping.upsert {
+		insertSQL = upsertInsertSQL(insertSQL, columnNames)
+	}
+	// the row of an overwriting insert may replace a stored one, which a new row cannot do
+	if mapping.SoftDeletes() || !mapping.upsert {
+		columns[DOCUMENT_COLUMN] = docText
+		mapping.addRow(insertSQL, namespace, columnNames, columns)
+	}
+	sqlStatements = append(sqlStatements, insertSQL)
+	return sqlStatements, nil
+}
+
+func generateCreateDocumentTableSQL(
+	namespace string,
+	cache Cache,
+	systemColumns []string,
+	columns map[string]interface{},
+) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (%s, %s JSONB",
+		namespace,
+		createColumn(namespace, Column{Name: "_id", Value: columns["_id"]}, cache),
+		DOCUMENT_COLUMN,
+	))
+
+	for _, columnName := range sortColumns(columns) {
+		if columnName == "_id" {
+			continue
+		}
+		column := Column{Name: columnName, Value: columns[columnName]}
+		sb.WriteString(fmt.Sprintf(", %s", createColumn(namespace, column, cache)))
+	}
+	for _, systemColumn := range systemColumns {
+		sb.WriteString(fmt.Sprintf(", %s", systemColumn))
+	}
+
+	sb.WriteString(");")
+	return sb.String()
+}
+
+// promotedColumns returns the promoted fields of the document holding a scalar value.
+func promotedColumns(data map[string]interface{}, promote []string) map[string]interface{} {
+	columns := make(map[string]interface{})
+	for _, field := range promote {
+		switch value := data[field].(type) {
+		case nil, map[string]interface{}, []interface{}:
+			continue
+		default:
+			columns[field] = value
+		}
+	}
+	return columns
+}
+
+// generateDocumentUpdateSQL translates the diff of an update into jsonb_set and #- operations
+// on the DOCUMENT_COLUMN, also updating the promoted columns it changes.
+func generateDocumentUpdateSQL(entry OplogEntry, mapping entryMapping) (string, error) {
+	diffMap, ok := entry.Object["diff"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("invalid diff oplog")
+	}
+
+	doc, err := applyDocumentDiff(DOCUMENT_COLUMN, nil, diffMap)
+	if err != nil {
+		return "", err
+	}
+	setCols := []string{fmt.Sprintf("%s = %s", DOCUMENT_COLUMN, doc)}
+
+	setMap := make(map[string]interface{})
+	for _, key := range []string{"u", "i"} {
+		if fields, ok := diffMap[key].(map[string]interface{}); ok {
+			for field, value := range fields {
+				setMap[field] = value
+			}
+		}
+	}
+	unsetMap, _ := diffMap["d"].(map[string]interface{})
+
+	sortedPromote := append([]string(nil), mapping.Promote...)
+	sort.Strings(sortedPromote)
+	for _, field := range sortedPromote {
+		if value, ok := setMap[field]; ok {
+			if _, ok := promotedColumns(setMap, []string{field})[field]; ok {
+				setCols = append(setCols, fmt.Sprintf("%s = %s", field, getColumnValue(value)))
+			} else {
+				setCols = append(setCols, fmt.Sprintf("%s = NULL", field))
+			}
+		} else if _, ok := unsetMap[field]; ok {
+			setCols = append(setCols, fmt.Sprintf("%s = NULL", field))
+		}
+	}
+	setCols = append(setCols, mapping.auditAssignments()...)
+
+	return fmt.Sprintf(
+		"UPDATE %s SET %s %s;",
+		entry.Namespace,
+		strings.Join(setCols, ", "),
+		generateWhereClause(entry.Object2),
+	), nil
+}
+
+// generateDocumentDeleteSQL deletes the document of a jsonb mode collection by its _id.
+func generateDocumentDeleteSQL(entry OplogEntry) (string, error) {
+	id, ok := entry.Object["_id"]
+	if !ok {
+		return "", fmt.Errorf("invalid oplog")
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE _id = %s;", entry.Namespace, getColumnValue(id)), nil
+}
+
+// applyDocumentDiff returns the expression applying the diff of the object or array at path
+// to the JSONB expression expr. Fields are removed first, then set, then the sub-diffs applied.
+func applyDocumentDiff(expr string, path []string, diffMap map[string]interface{}) (string, error) {
+	if isArray, _ := diffMap["a"].(bool); isArray {
+		return applyArrayDiff(expr, path, diffMap)
+	}
+
+	if unsetMap, ok := diffMap["d"].(map[string]interface{}); ok {
+		for _, field := range sortColumns(unsetMap) {
+			expr = fmt.Sprintf("(%s #- %s)", expr, jsonPath(path, field))
+		}
+	}
+
+	for _, key := range []string{"u", "i"} {
+		setMap, ok := diffMap[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, field := range sortColumns(setMap) {
+			value, err := jsonLiteral(setMap[field])
+			if err != nil {
+				return "", err
+			}
+			expr = fmt.Sprintf("jsonb_set(%s, %s, %s)", expr, jsonPath(path, field), value)
+		}
+	}
+
+	for _, key := range sortColumns(diffMap) {
+		subDiff, ok := diffMap[key].(map[string]interface{})
+		if !ok || !strings.HasPrefix(key, "s") {
+			continue
+		}
+		var err error
+		if expr, err = applyDocumentDiff(expr, appendPath(path, key[1:]), subDiff); err != nil {
+			return "", err
+		}
+	}
+	return expr, nil
+}
+
+// applyArrayDiff applies the diff of an array, resizing it to the new length "l" first and then
+// updating ("u<index>") or diffing ("s<index>") its elements in index order.
+func applyArrayDiff(expr string, path []string, diffMap map[string]interface{}) (string, error) {
+	if length, ok := diffMap["l"]; ok {
+		truncated := fmt.Sprintf(
+			"(SELECT COALESCE(jsonb_agg(e ORDER BY i), '[]'::jsonb) FROM jsonb_array_elements(%s #> %s) WITH ORDINALITY AS t(e, i) WHERE i <= %s)",
+			expr, jsonPath(path), getColumnValue(length),
+		)
+		expr = fmt.Sprintf("jsonb_set(%s, %s, %s)", expr, jsonPath(path), truncated)
+	}
+
+	indexes := make([]int, 0, len(diffMap))
+	for key := range diffMap {
+		if len(key) < 2 || (key[0] != 'u' && key[0] != 's') {
+			continue
+		}
+		if index, err := strconv.Atoi(key[1:]); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		element := strconv.Itoa(index)
+		if value, ok := diffMap["u"+element]; ok {
+			literal, err := jsonLiteral(value)
+			if err != nil {
+				return "", err
+			}
+			expr = fmt.Sprintf("jsonb_set(%s, %s, %s)", expr, jsonPath(path, element), literal)
+		}
+		if subDiff, ok := diffMap["s"+element].(map[string]interface{}); ok {
+			var err error
+			if expr, err = applyDocumentDiff(expr, appendPath(path, element), subDiff); err != nil {
+				return "", err
+			}
+		}
+	}
+	return expr, nil
+}
+
+func appendPath(path []string, key string) []string {
+	return append(append([]string(nil), path...), key)
+}
+
+// jsonPath returns the text array literal of a JSONB path.
+func jsonPath(path []string, keys ...string) string {
+	elements := make([]string, 0, len(path)+len(keys))
+	for _, key := range append(append([]string(nil), path...), keys...) {
+		key = strings.ReplaceAll(key, `\`, `\\`)
+		key = strings.ReplaceAll(key, `"`, `\"`)
+		elements = append(elements, fmt.Sprintf(`"%s"`, key))
+	}
+	return getColumnValue(fmt.Sprintf("{%s}", strings.Join(elements, ",")))
+}
+
+// jsonLiteral returns the JSONB literal of a value.
+func jsonLiteral(value interface{}) (string, error) {
+	text, err := jsonText(value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s::jsonb", getColumnValue(text)), nil
+}
+
+// jsonText returns the JSON text of a value.
+func jsonText(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(jsonValue(value)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// jsonValue converts the BSON types of a value into the JSON values matching their columns:
+// ObjectIds become their hex string, dates an RFC 3339 string and binary data base64.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, fieldValue := range v {
+			object[key] = jsonValue(fieldValue)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			array[i] = jsonValue(element)
+		}
+		return array
+	case float32:
+		return jsonValue(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprintf("%v", v)
+		}
+		return v
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case primitive.Decimal128:
+		return v.String()
+	case primitive.Binary:
+		return v.Data
+	case primitive.Timestamp:
+		return uint64(v.T)<<32 | uint64(v.I)
+	case primitive.Null, primitive.Undefined:
+		return nil
+	default:
+		return value
+	}
+}
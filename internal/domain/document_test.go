@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestApplyDocumentDiff(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("635b79e231d82a8ab1de863b")
+
+	tests := []struct {
+		name string
+		diff map[string]interface{}
+		want string
+	}{
+		{
+			name: "Set and unset fields",
+			diff: map[string]interface{}{
+				"d": map[string]interface{}{"age": false, "phone": false},
+				"u": map[string]interface{}{"name": "Selena", "ref": id},
+				"i": map[string]interface{}{"tags": []interface{}{"a"}},
+			},
+			want: `jsonb_set(jsonb_set(jsonb_set(((doc #- '{"age"}') #- '{"phone"}'), '{"name"}', '"Selena"'::jsonb), '{"ref"}', '"635b79e231d82a8ab1de863b"'::jsonb), '{"tags"}', '["a"]'::jsonb)`,
+		},
+		{
+			name: "Nested object",
+			diff: map[string]interface{}{
+				"saddress": map[string]interface{}{
+					"u":    map[string]interface{}{"it's \"home\"": 1.5},
+					"sgeo": map[string]interface{}{"d": map[string]interface{}{"lat": false}},
+				},
+			},
+			want: `(jsonb_set(doc, '{"address","it''s \"home\""}', '1.5'::jsonb) #- '{"address","geo","lat"}')`,
+		},
+		{
+			name: "Array truncated and elements updated",
+			diff: map[string]interface{}{
+				"stags": map[string]interface{}{"a": true, "l": int32(2), "u1": "b", "u10": "c"},
+			},
+			want: `jsonb_set(jsonb_set(jsonb_set(doc, '{"tags"}', (SELECT COALESCE(jsonb_agg(e ORDER BY i), '[]'::jsonb) FROM jsonb_array_elements(doc #> '{"tags"}') WITH ORDINALITY AS t(e, i) WHERE i <= 2)), '{"tags","1"}', '"b"'::jsonb), '{"tags","10"}', '"c"'::jsonb)`,
+		},
+		{
+			name: "Array element diffed",
+			diff: map[string]interface{}{
+				"sphones": map[string]interface{}{
+					"a":  true,
+					"s0": map[string]interface{}{"u": map[string]interface{}{"work": "8130097989"}},
+				},
+			},
+			want: `jsonb_set(doc, '{"phones","0","work"}', '"8130097989"'::jsonb)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := applyDocumentDiff(DOCUMENT_COLUMN, nil, test.diff)
+			if err != nil {
+				t.Fatalf("applyDocumentDiff failed: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Expression does not match the expected result.\nWant: %s\nGot: %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestGenerateDocumentUpdateSQL(t *testing.T) {
+	entry := OplogEntry{
+		Operation: "u",
+		Namespace: "test.events",
+		Object: map[string]interface{}{
+			"$v": int32(2),
+			"diff": map[string]interface{}{
+				"u": map[string]interface{}{"kind": "view", "meta": map[string]interface{}{"a": 1.0}},
+				"d": map[string]interface{}{"user": false},
+			},
+		},
+		Object2: map[string]interface{}{"_id": "e1"},
+	}
+	mapping := entryMapping{CollectionMapping: config.CollectionMapping{Mode: config.MODE_JSONB, Promote: []string{"user", "meta", "kind", "ignored"}}}
+
+	got, err := generateDocumentUpdateSQL(entry, mapping)
+	if err != nil {
+		t.Fatalf("generateDocumentUpdateSQL failed: %v", err)
+	}
+	want := `UPDATE test.events SET doc = jsonb_set(jsonb_set((doc #- '{"user"}'), '{"kind"}', '"view"'::jsonb), '{"meta"}', '{"a":1}'::jsonb), kind = 'view', meta = NULL, user = NULL WHERE _id = 'e1';`
+	if got != want {
+		t.Errorf("Generated SQL does not match the expected result.\nWant: %s\nGot: %s", want, got)
+	}
+}
@@ -0,0 +1,332 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// flattenDocument returns the data with the embedded objects up to depth levels replaced by
+// their fields, prefixed with the name of the object, along with the path of every field below
+// the given path.
+func flattenDocument(data map[string]interface{}, depth int, path []string) (map[string]interface{}, map[string][]string) {
+	flat := make(map[string]interface{}, len(data))
+	paths := make(map[string][]string, len(data))
+	for name, value := range data {
+		fieldPath := append(append([]string(nil), path...), name)
+		if object, ok := value.(map[string]interface{}); ok && depth > 0 {
+			fields, fieldPaths := flattenDocument(object, depth-1, fieldPath)
+			for field, fieldValue := range fields {
+				flat[name+"_"+field] = fieldValue
+				paths[name+"_"+field] = fieldPaths[field]
+			}
+			continue
+		}
+		flat[name] = value
+		paths[name] = fieldPath
+	}
+	return flat, paths
+}
+
+// columnPrefix returns the prefix of the columns flattened from the embedded object at the path.
+func columnPrefix(path []string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return strings.Join(path, "_") + "_"
+}
+
+// samePath reports whether both paths are the same.
+func samePath(a, b []string) bool {
+	return len(a) == len(b) && underPath(a, b)
+}
+
+// underPath reports whether the path is the given one or below it.
+func underPath(path, parent []string) bool {
+	if len(path) < len(parent) {
+		return false
+	}
+	for i := range parent {
+		if path[i] != parent[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// flatten returns the data at the path of the rows of the table with its embedded objects up to
+// depth levels flattened into prefixed columns, and records the path of every column and child
+// field named from them in the manifest. The fields of the data are named as those of the
+// columns recorded before, which checkFlatten verifies beforehand.
+func (m *schemaManifest) flatten(tbl table, path []string, data map[string]interface{}, depth int) map[string]interface{} {
+	// the fields below a flattened object are recorded even past the depth
+	if depth < 1 && len(path) == 0 {
+		return data
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.tableKey(tbl)
+	manifestTable, ok := m.byPath[key]
+	flat, paths, _ := m.flattenFields(tbl, path, data, depth, manifestTable.Columns)
+	if !ok {
+		return flat
+	}
+
+	changed := false
+	for name, fieldPath := range paths {
+		if _, recorded := manifestTable.Columns[name]; recorded {
+			continue
+		}
+		if manifestTable.Columns == nil {
+			manifestTable.Columns = make(map[string][]string)
+		}
+		manifestTable.Columns[name] = fieldPath
+		changed = true
+	}
+	if changed {
+		m.byPath[key] = manifestTable
+		m.write()
+	}
+	return flat
+}
+
+// flattenFields returns the data at the path of the rows of the table with its embedded objects
+// up to depth levels flattened, and the path of each of its fields keyed by column name. An
+// object is kept whole, and stored in a child table, when it already has one, or when one of its
+// columns would be named as another field of the data or a column recorded from another path.
+// It fails when a field kept as is is named as a column recorded from another path.
+func (m *schemaManifest) flattenFields(
+	tbl table,
+	path []string,
+	data map[string]interface{},
+	depth int,
+	recorded map[string][]string,
+) (map[string]interface{}, map[string][]string, error) {
+	prefix := columnPrefix(path)
+
+	flat := make(map[string]interface{}, len(data))
+	paths := make(map[string][]string, len(data))
+	objects := make(map[string]map[string]interface{})
+	objectPaths := make(map[string]map[string][]string)
+	for name, value := range data {
+		fieldPath := append(append([]string(nil), path...), name)
+		if object, ok := value.(map[string]interface{}); ok && depth > 0 {
+			whole, known := m.byPath[pathKey(tbl.collection, append(append([]string(nil), tbl.path...), prefix+name))]
+			if !known || whole.Kind != TABLE_OBJECT {
+				objects[name], objectPaths[name] = flattenDocument(object, depth-1, fieldPath)
+				continue
+			}
+		}
+		flat[prefix+name] = value
+		paths[prefix+name] = fieldPath
+	}
+
+	owners := make(map[string]int, len(flat))
+	for columnName := range flat {
+		owners[columnName]++
+	}
+	for name, fields := range objects {
+		for field := range fields {
+			owners[prefix+name+"_"+field]++
+		}
+	}
+	for name, fields := range objects {
+		whole := false
+		for field, fieldPath := range objectPaths[name] {
+			columnName := prefix + name + "_" + field
+			if recordedPath, ok := recorded[columnName]; owners[columnName] > 1 || ok && !samePath(recordedPath, fieldPath) {
+				whole = true
+				break
+			}
+		}
+		if whole {
+			flat[prefix+name] = data[name]
+			paths[prefix+name] = append(append([]string(nil), path...), name)
+			continue
+		}
+		for field, value := range fields {
+			flat[prefix+name+"_"+field] = value
+			paths[prefix+name+"_"+field] = objectPaths[name][field]
+		}
+	}
+
+	// the columns are returned without the prefix, which the caller adds
+	unprefixed := make(map[string]interface{}, len(flat))
+	for columnName, value := range flat {
+		unprefixed[strings.TrimPrefix(columnName, prefix)] = value
+	}
+
+	columnNames := make([]string, 0, len(paths))
+	for columnName := range paths {
+		columnNames = append(columnNames, columnName)
+	}
+	sort.Strings(columnNames)
+	for _, columnName := range columnNames {
+		if recordedPath, ok := recorded[columnName]; ok && !samePath(recordedPath, paths[columnName]) {
+			return unprefixed, paths, fmt.Errorf(
+				"field %s is named as the column flattened from %s",
+				strings.Join(paths[columnName], "."),
+				strings.Join(recordedPath, "."),
+			)
+		}
+	}
+	return unprefixed, paths, nil
+}
+
+// tableKey returns the key of the table in the manifest.
+func (m *schemaManifest) tableKey(tbl table) string {
+	if len(tbl.path) == 0 {
+		if key, ok := m.byName[tbl.namespace]; ok {
+			return key
+		}
+	}
+	return pathKey(tbl.collection, tbl.path)
+}
+
+// fieldPath returns the path of the field of the rows of the table held by the column or child
+// table of the given name, which is the name itself unless recorded when flattened.
+func (m *schemaManifest) fieldPath(tbl table, name string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if path, ok := m.byPath[m.tableKey(tbl)].Columns[name]; ok {
+		return path
+	}
+	return []string{name}
+}
+
+// flattened reports whether the embedded object of the field of the rows of the table is
+// flattened into their columns, rather than kept whole in a child table.
+func (m *schemaManifest) flattened(tbl table, field string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	child, ok := m.byPath[pathKey(tbl.collection, append(append([]string(nil), tbl.path...), field))]
+	return !ok || child.Kind != TABLE_OBJECT
+}
+
+// checkFlatten returns an error when a field of the data at the path of the rows of the table,
+// or of the embedded objects and arrays stored in its child tables, would be named as a column
+// flattened from another path. The columns the data would flatten are claimed, keyed by table,
+// so that the fields of the same document are checked against each other.
+func (m *schemaManifest) checkFlatten(
+	tbl table,
+	path []string,
+	data map[string]interface{},
+	depth int,
+	maxDepth int,
+	claims map[string]map[string][]string,
+) error {
+	if maxDepth < 1 {
+		return nil
+	}
+
+	m.mu.Lock()
+	key := m.tableKey(tbl)
+	recorded := make(map[string][]string)
+	for columnName, fieldPath := range m.byPath[key].Columns {
+		recorded[columnName] = fieldPath
+	}
+	for columnName, fieldPath := range claims[key] {
+		recorded[columnName] = fieldPath
+	}
+	flat, paths, err := m.flattenFields(tbl, path, data, depth, recorded)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if claims[key] == nil {
+		claims[key] = make(map[string][]string)
+	}
+	for columnName, fieldPath := range paths {
+		if _, ok := recorded[columnName]; !ok {
+			claims[key][columnName] = fieldPath
+		}
+	}
+
+	prefix := columnPrefix(path)
+	for _, columnName := range sortColumns(flat) {
+		child := table{collection: tbl.collection, path: append(append([]string(nil), tbl.path...), prefix+columnName)}
+		if err := m.checkValue(child, flat[columnName], maxDepth, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue checks the embedded object or the elements of the array stored in the child table
+// like checkFlatten.
+func (m *schemaManifest) checkValue(child table, value interface{}, maxDepth int, claims map[string]map[string][]string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return m.checkFlatten(child, nil, v, maxDepth, maxDepth, claims)
+	case []interface{}:
+		for _, element := range v {
+			if object, ok := element.(map[string]interface{}); ok {
+				if err := m.checkFlatten(child, nil, object, maxDepth, maxDepth, claims); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkDiff checks the fields set by the diff of an update of the rows of the table like
+// checkFlatten, in the embedded objects flattened up to depth levels below the path.
+func (p *OplogParser) checkDiff(
+	tbl table,
+	path []string,
+	depth int,
+	diffMap map[string]interface{},
+	mapping entryMapping,
+	claims map[string]map[string][]string,
+) error {
+	maxDepth := mapping.Depth()
+	for _, key := range []string{"u", "i"} {
+		if setMap, ok := diffMap[key].(map[string]interface{}); ok {
+			if err := p.manifest.checkFlatten(tbl, path, setMap, depth, maxDepth, claims); err != nil {
+				return err
+			}
+		}
+	}
+
+	prefix := columnPrefix(path)
+	for _, key := range sortColumns(diffMap) {
+		subDiff, ok := diffMap[key].(map[string]interface{})
+		if !ok || !strings.HasPrefix(key, "s") {
+			continue
+		}
+		field := prefix + key[1:]
+		child := table{collection: tbl.collection, path: append(append([]string(nil), tbl.path...), field)}
+
+		if isArray, _ := subDiff["a"].(bool); isArray {
+			for _, index := range sortColumns(subDiff) {
+				var err error
+				if element, ok := subDiff[index].(map[string]interface{}); ok && strings.HasPrefix(index, "s") {
+					err = p.checkDiff(child, nil, maxDepth, element, mapping, claims)
+				} else if strings.HasPrefix(index, "u") {
+					err = p.manifest.checkValue(child, []interface{}{subDiff[index]}, maxDepth, claims)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		var err error
+		if depth > 0 && p.manifest.flattened(tbl, field) {
+			err = p.checkDiff(tbl, append(append([]string(nil), path...), key[1:]), depth-1, subDiff, mapping, claims)
+		} else {
+			err = p.checkDiff(child, nil, maxDepth, subDiff, mapping, claims)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
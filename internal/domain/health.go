@@ -0,0 +1,17 @@
+package domain
+
+// Health receives the progress of the writers, so that the writers do not depend on how it is
+// reported.
+type Health interface {
+	// WriterConnected records whether the writer of a database can reach it.
+	WriterConnected(db string, connected bool)
+	// Committed records a batch of statements, ddl of them DDL, committed by the writer of a
+	// database up to the oplog at ts.
+	Committed(db string, ts Timestamp, statements, ddl int)
+}
+
+// NopHealth is a Health discarding the progress.
+type NopHealth struct{}
+
+func (NopHealth) WriterConnected(db string, connected bool)              {}
+func (NopHealth) Committed(db string, ts Timestamp, statements, ddl int) {}
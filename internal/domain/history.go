@@ -0,0 +1,183 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+// Columns of the history tables.
+const (
+	HISTORY_OPERATION_COLUMN  string = "operation"
+	HISTORY_TXN_NUMBER_COLUMN string = "txn_number"
+	HISTORY_TS_COLUMN         string = "ts"
+	HISTORY_VALID_FROM_COLUMN string = "valid_from"
+	HISTORY_VALID_TO_COLUMN   string = "valid_to"
+)
+
+// generateHistorySQL returns the statements recording the version of the document written by
+// the entry in the history table of its collection, to run after the statements applying the
+// entry to the current-state table. The version still open is closed at the wall time of the
+// entry, then the new version is appended: the row of the current-state table along with the
+// rows of its child tables as a JSONB document, or a NULL document for a delete. Versions are keyed by _id and ts so that
+// replaying an entry does not record it twice.
+func (p *OplogParser) generateHistorySQL(entry OplogEntry, mapping entryMapping, cache Cache) []string {
+	var id interface{}
+	var ok bool
+	if entry.Operation == "u" {
+		id, ok = entry.Object2["_id"]
+	} else {
+		id, ok = entry.Object["_id"]
+	}
+	if !ok {
+		return nil
+	}
+
+	history := p.manifest.history(entry.Namespace)
+	sqlStatements := []string{}
+	if !cache.LoadOrStore(history, true) {
+		sqlStatements = append(sqlStatements, generateCreateHistoryTableSQL(history, id))
+	}
+
+	ts := sqlExpression(fmt.Sprintf("%d", uint64(entry.Timestamp.T)<<32|uint64(entry.Timestamp.I)))
+	validFrom := wallTime(entry)
+	txnNumber := sqlExpression("NULL")
+	if entry.TxnNumber != nil {
+		txnNumber = sqlExpression(fmt.Sprintf("%d", *entry.TxnNumber))
+	}
+
+	sqlStatements = append(sqlStatements, fmt.Sprintf(
+		"UPDATE %s SET %s = %s WHERE _id = %s AND %s IS NULL AND %s < %s;",
+		history,
+		HISTORY_VALID_TO_COLUMN,
+		getColumnValue(validFrom),
+		getColumnValue(id),
+		HISTORY_VALID_TO_COLUMN,
+		HISTORY_TS_COLUMN,
+		getColumnValue(ts),
+	))
+
+	columns := strings.Join([]string{
+		"_id",
+		DOCUMENT_COLUMN,
+		HISTORY_OPERATION_COLUMN,
+		HISTORY_TXN_NUMBER_COLUMN,
+		HISTORY_TS_COLUMN,
+		HISTORY_VALID_FROM_COLUMN,
+	}, ", ")
+	metadata := strings.Join([]string{
+		getColumnValue(entry.Operation),
+		getColumnValue(txnNumber),
+		getColumnValue(ts),
+		getColumnValue(validFrom),
+	}, ", ")
+
+	if entry.Operation == "d" {
+		sqlStatements = append(sqlStatements, fmt.Sprintf(
+			"INSERT INTO %s (%s) VALUES (%s, NULL, %s) ON CONFLICT (_id, %s) DO NOTHING;",
+			history, columns, getColumnValue(id), metadata, HISTORY_TS_COLUMN,
+		))
+		return sqlStatements
+	}
+
+	// the document of a jsonb mode collection is stored as is, without its promoted columns
+	doc := DOCUMENT_COLUMN
+	if mapping.Mode != config.MODE_JSONB {
+		var omitted []string
+		if mapping.SoftDeletes() {
+			omitted = []string{DELETED_COLUMN, DELETED_AT_COLUMN}
+		}
+		doc = p.documentSQL(entry.Namespace, "t", cache, omitted)
+	}
+	sqlStatements = append(sqlStatements, fmt.Sprintf(
+		"INSERT INTO %s (%s) SELECT _id, %s, %s FROM %s AS t WHERE _id = %s ON CONFLICT (_id, %s) DO NOTHING;",
+		history, columns, doc, metadata, entry.Namespace, getColumnValue(id), HISTORY_TS_COLUMN,
+	))
+	return sqlStatements
+}
+
+// documentSQL returns the JSONB document of the row aliased alias of the table, without the
+// omitted columns, merged with the documents of its child tables nested under their field: an
+// object for an object table, and an array of its elements in order for an array table.
+// Fields without child rows are left out, as they are from the document.
+func (p *OplogParser) documentSQL(namespace string, alias string, cache Cache, omitted []string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("to_jsonb(%s)", alias))
+	for _, columnName := range omitted {
+		sb.WriteString(fmt.Sprintf(" - %s", getColumnValue(columnName)))
+	}
+
+	for i, child := range p.manifest.children(namespace) {
+		if !cache.Get(child.Table) {
+			continue
+		}
+		childAlias := fmt.Sprintf("%s%d", alias, i+1)
+		field := getColumnValue(child.Path[len(child.Path)-1])
+		where := fmt.Sprintf("%s.%s = %s._id", childAlias, child.ForeignKey, alias)
+
+		var value string
+		if child.Kind == TABLE_ARRAY {
+			value = fmt.Sprintf(
+				"jsonb_agg(%s ORDER BY %s.%s)",
+				p.elementSQL(child, childAlias, cache),
+				childAlias,
+				ORDINAL_COLUMN,
+			)
+			where += " HAVING count(*) > 0"
+		} else {
+			value = p.documentSQL(child.Table, childAlias, cache, []string{child.ForeignKey})
+		}
+		sb.WriteString(fmt.Sprintf(
+			" || COALESCE((SELECT jsonb_build_object(%s, %s) FROM %s AS %s WHERE %s), '{}')",
+			field,
+			value,
+			child.Table,
+			childAlias,
+			where,
+		))
+	}
+	return sb.String()
+}
+
+// elementSQL returns the JSONB value of the array element of the row aliased alias of the array
+// table: the ELEMENT_COLUMN of a scalar element, or the document of an embedded object.
+func (p *OplogParser) elementSQL(array ManifestTable, alias string, cache Cache) string {
+	omitted := []string{array.ForeignKey, ORDINAL_COLUMN}
+	if _, ok := cache.ColumnType(array.Table, ELEMENT_COLUMN); !ok {
+		return p.documentSQL(array.Table, alias, cache, omitted)
+	}
+
+	// an array of scalars only has the columns of its rows besides the ELEMENT_COLUMN
+	objects := len(p.manifest.children(array.Table)) > 0
+	for _, columnName := range cache.Columns(array.Table) {
+		switch columnName {
+		case "_id", ELEMENT_COLUMN, ORDINAL_COLUMN, array.ForeignKey:
+		default:
+			objects = objects || !isAuditColumn(columnName)
+		}
+	}
+	element := fmt.Sprintf("to_jsonb(%s.%s)", alias, ELEMENT_COLUMN)
+	if !objects {
+		return element
+	}
+	document := p.documentSQL(array.Table, alias, cache, append(omitted, ELEMENT_COLUMN))
+	return fmt.Sprintf("CASE WHEN %s.%s IS NOT NULL THEN %s ELSE %s END", alias, ELEMENT_COLUMN, element, document)
+}
+
+// generateCreateHistoryTableSQL creates the history table of a collection, whose _id has the
+// type of the given one.
+func generateCreateHistoryTableSQL(history string, id interface{}) string {
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (_id %s, %s JSONB, %s CHAR(1), %s BIGINT, %s BIGINT, %s TIMESTAMPTZ, %s TIMESTAMPTZ, PRIMARY KEY (_id, %s));",
+		history,
+		getColumnSQLDataType("_id", id),
+		DOCUMENT_COLUMN,
+		HISTORY_OPERATION_COLUMN,
+		HISTORY_TXN_NUMBER_COLUMN,
+		HISTORY_TS_COLUMN,
+		HISTORY_VALID_FROM_COLUMN,
+		HISTORY_VALID_TO_COLUMN,
+		HISTORY_TS_COLUMN,
+	)
+}
@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// Metrics receives the measurements taken while buffering, converting and applying the oplogs,
+// so that the domain and the writers do not depend on how they are exported.
+type Metrics interface {
+	// StatementsGenerated counts the SQL statements generated for the source database.
+	StatementsGenerated(db string, count int)
+	// SpoolBytes sets the size of the oplog entries spooled on disk.
+	SpoolBytes(bytes int64)
+	// OplogBuffer registers the depth of the oplog buffer, read whenever it is measured.
+	OplogBuffer(depth func() int)
+	// StatementBuffer registers the depth of the statement buffer of a database, read
+	// whenever it is measured.
+	StatementBuffer(db string, depth func() int)
+	// ApplyError counts an error while executing or committing the statements of a database.
+	ApplyError(db string)
+	// BatchCommitted records a batch of statements committed to a database in duration, up to
+	// the oplog at ts.
+	BatchCommitted(db string, statements int, duration time.Duration, ts Timestamp)
+}
+
+// NopMetrics is a Metrics discarding the measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) StatementsGenerated(db string, count int)    {}
+func (NopMetrics) SpoolBytes(bytes int64)                      {}
+func (NopMetrics) OplogBuffer(depth func() int)                {}
+func (NopMetrics) StatementBuffer(db string, depth func() int) {}
+func (NopMetrics) ApplyError(db string)                        {}
+func (NopMetrics) BatchCommitted(db string, statements int, duration time.Duration, ts Timestamp) {
+}
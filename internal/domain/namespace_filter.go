@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"regexp"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+// NamespaceFilter selects the namespaces (db.collection) to replicate. A namespace is selected
+// when it matches any include expression, or there is none, and it matches no exclude expression.
+// A nil filter selects every namespace.
+type NamespaceFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// NewNamespaceFilter compiles the expressions of the filter configuration.
+func NewNamespaceFilter(cfg config.FilterConfig) (*NamespaceFilter, error) {
+	include, err := compileAll(cfg.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileAll(cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &NamespaceFilter{include: include, exclude: exclude}, nil
+}
+
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	regexps := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+// Match reports whether the namespace is selected by the filter.
+func (f *NamespaceFilter) Match(namespace string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(namespace) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// Include returns the include expressions, so that readers can push them down to the source.
+func (f *NamespaceFilter) Include() []string {
+	if f == nil {
+		return nil
+	}
+	return patterns(f.include)
+}
+
+// Exclude returns the exclude expressions, so that readers can push them down to the source.
+func (f *NamespaceFilter) Exclude() []string {
+	if f == nil {
+		return nil
+	}
+	return patterns(f.exclude)
+}
+
+func patterns(regexps []*regexp.Regexp) []string {
+	exprs := make([]string, 0, len(regexps))
+	for _, re := range regexps {
+		exprs = append(exprs, re.String())
+	}
+	return exprs
+}
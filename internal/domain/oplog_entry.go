@@ -1,6 +1,11 @@
 package domain
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 const (
 	SEPERATOR string = "."
@@ -11,12 +16,53 @@ type OplogEntry struct {
 	Namespace string                 `json:"ns"`
 	Object    map[string]interface{} `json:"o"`
 	Object2   map[string]interface{} `json:"o2"`
+	Timestamp Timestamp              `json:"ts"`
+	Wall      time.Time              `json:"wall"`
+	// TxnNumber is the transaction number of the session of retryable writes and transactions.
+	TxnNumber *int64 `json:"txnNumber"`
+	// Upsert is set on the entries which may already be applied to the target, such as the
+	// changes made while a snapshot was running, whose inserts overwrite the document.
+	Upsert bool `json:"-"`
 }
 
 func (o OplogEntry) DatabaseName() string {
 	return strings.ToLower(strings.Split(o.Namespace, SEPERATOR)[0])
 }
 
+// SchemaName returns the database part of the namespace as it is used in SQL statements.
+func (o OplogEntry) SchemaName() string {
+	return strings.Split(o.Namespace, SEPERATOR)[0]
+}
+
 func (o OplogEntry) TableName() string {
 	return strings.ToLower(strings.Split(o.Namespace, SEPERATOR)[1])
 }
+
+// Validate checks that the entry is an insert, update or delete that can be converted into SQL.
+func (o OplogEntry) Validate() error {
+	nsParts := strings.Split(o.Namespace, SEPERATOR)
+	if len(nsParts) < 2 || nsParts[0] == "" || nsParts[1] == "" {
+		return fmt.Errorf("invalid namespace %q", o.Namespace)
+	}
+
+	switch o.Operation {
+	case "i":
+		if len(o.Object) == 0 {
+			return errors.New("insert without document")
+		}
+	case "u":
+		if _, ok := o.Object["diff"].(map[string]interface{}); !ok {
+			return errors.New("update without diff")
+		}
+		if len(o.Object2) == 0 {
+			return errors.New("update without o2")
+		}
+	case "d":
+		if len(o.Object) == 0 {
+			return errors.New("delete without document")
+		}
+	default:
+		return fmt.Errorf("unsupported operation %q", o.Operation)
+	}
+	return nil
+}
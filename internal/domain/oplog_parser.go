@@ -2,22 +2,53 @@ package domain
 
 import (
 	"fmt"
+	"log/slog"
 	"reflect"
 	"sort"
 	"strings"
 	"sync"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+const (
+	// ORDINAL_COLUMN holds the position of an array element in the sub table of the array.
+	ORDINAL_COLUMN string = "_idx"
+	// ELEMENT_COLUMN holds the value of a scalar array element.
+	ELEMENT_COLUMN string = "value"
 )
 
 type OplogParser struct {
 	uuidGenerator UUIDGenerator
+	config        config.PipelineConfig
+	manifest      *schemaManifest
+	metrics       Metrics
+	logger        *slog.Logger
 }
 
-func NewOplogParser(uuidGenerator UUIDGenerator) *OplogParser {
+func NewOplogParser(uuidGenerator UUIDGenerator, cfg config.PipelineConfig, m Metrics, logger *slog.Logger) *OplogParser {
 	return &OplogParser{
 		uuidGenerator: uuidGenerator,
+		config:        cfg,
+		manifest:      newSchemaManifest(cfg.Schema.Manifest, cfg.Schema.ForeignKeys, logger),
+		metrics:       m,
+		logger:        logger,
 	}
 }
 
+// mapNamespace returns the entry with its namespace replaced by the target of its mapping rule, if any.
+func (p *OplogParser) mapNamespace(entry OplogEntry) OplogEntry {
+	if mapping, ok := p.config.Mapping.Collections[entry.Namespace]; ok && mapping.Target != "" {
+		entry.Namespace = mapping.Target
+	}
+	return entry
+}
+
+// ProcessCollectionOplog fans out the oplogs of a database to one goroutine per collection.
+// Statements of a collection are published in oplog order, so every document sees its
+// operations in order, but statements of different collections may interleave freely.
+// They are published in the stream of the namespace of their oplog, whose checkpoints reach
+// the writer in oplog order.
 func (p *OplogParser) ProcessCollectionOplog(
 	oplogChan <-chan OplogEntry,
 	sqlStmt SQLStatement,
@@ -31,14 +62,28 @@ func (p *OplogParser) ProcessCollectionOplog(
 	// WaitGroup for tables
 	var wgTable sync.WaitGroup
 	for oplog := range oplogChan {
-		tableName := oplog.TableName()
+		target := p.mapNamespace(oplog)
+
+		// create the schema before fanning out, so that no collection goroutine
+		// can publish its CREATE TABLE ahead of the CREATE SCHEMA. It is published in the
+		// stream of the oplog, checkpointed by the collection goroutine after its statements
+		if target.Operation == "i" && !collectionCache.LoadOrStore(target.SchemaName(), true) {
+			stream := sqlStmt.WithStream(oplog.Namespace)
+			stream.Publish(generateCreateSchemaSQL(target.SchemaName()), oplog.Timestamp)
+		}
+
+		tableName := target.TableName()
 		if _, ok := tableMap[tableName]; !ok {
-			tableChan := make(chan OplogEntry, 1000)
+			// the collection tables are recorded before any collection goroutine can name a
+			// table after them, so that a collection keeps its name from its first oplog
+			p.manifest.collection(target.Namespace)
+
+			tableChan := make(chan OplogEntry, p.config.Buffers.Collections)
 			tableMap[tableName] = tableChan
 			wgTable.Add(1)
 
-			go p.processTableOplog(tableChan, collectionCache, &wgTable, sqlStmt)
-
+			logger := p.logger.With("db", sqlStmt.GetDBName(), "collection", tableName)
+			go p.processTableOplog(tableChan, collectionCache, &wgTable, sqlStmt, logger)
 		}
 		tableMap[tableName] <- oplog
 	}
@@ -55,28 +100,76 @@ func (p *OplogParser) processTableOplog(
 	cache Cache,
 	wg *sync.WaitGroup,
 	sqlStmt SQLStatement,
+	logger *slog.Logger,
 ) {
 	defer wg.Done()
 
 	for entry := range oplogChan {
-		// process the Oplog entry
-		sqls, err := p.ProcessOplog(entry, cache)
+		stream := sqlStmt.WithStream(entry.Namespace)
+
+		// process the Oplog entry, invalid entries are skipped
+		sqlCmds, err := p.ProcessOplogCommands(entry, cache)
 		if err != nil {
-			break
+			logger.Warn("skipping oplog", "ts", entry.Timestamp.String(), "op", entry.Operation, "error", err)
 		}
 
-		for _, sql := range sqls {
-			sqlStmt.Publish(sql)
+		for _, sqlCmd := range sqlCmds {
+			stream.PublishRow(sqlCmd.Query, sqlCmd.Row, entry.Timestamp)
 		}
+		stream.PublishCheckpoint(entry.Timestamp)
 	}
 }
 
 func (p *OplogParser) ProcessOplog(entry OplogEntry, cache Cache) ([]string, error) {
+	sqlCmds, err := p.ProcessOplogCommands(entry, cache)
+	if err != nil {
+		return []string{}, err
+	}
+	sqlStatements := make([]string, 0, len(sqlCmds))
+	for _, sqlCmd := range sqlCmds {
+		sqlStatements = append(sqlStatements, sqlCmd.Query)
+	}
+	return sqlStatements, nil
+}
+
+// ProcessOplogCommands returns the commands of the SQL statements of the oplog entry, along with
+// the rows they insert.
+func (p *OplogParser) ProcessOplogCommands(entry OplogEntry, cache Cache) ([]SQLCommand, error) {
+	mapping := newEntryMapping(entry, p.config.Collection(entry.Namespace))
+	mapping.rows = make(map[string]*Row)
+
+	sqlStatements, err := p.processOplog(entry, mapping, cache)
+	if err != nil {
+		return []SQLCommand{}, err
+	}
+	sqlCmds := make([]SQLCommand, 0, len(sqlStatements))
+	for _, sql := range sqlStatements {
+		sqlCmds = append(sqlCmds, SQLCommand{Query: sql, Timestamp: entry.Timestamp, Row: mapping.rows[sql]})
+	}
+	return sqlCmds, nil
+}
+
+func (p *OplogParser) processOplog(entry OplogEntry, mapping entryMapping, cache Cache) ([]string, error) {
+	source := entry.DatabaseName()
+	entry = p.mapNamespace(entry)
+	entry.Namespace = p.manifest.collection(entry.Namespace)
+
+	if mapping.Mode == config.MODE_JSONB {
+		return p.processDocumentOplog(entry, mapping, cache, source)
+	}
+
 	sqlStatements := []string{}
 	switch entry.Operation {
 	case "i":
-		nsParts := strings.Split(entry.Namespace, ".")
-		schemaName := nsParts[0]
+		// a document with a field named as a column flattened from another path is rejected
+		// before any of its statements is generated
+		root := table{namespace: entry.Namespace, collection: entry.Namespace}
+		claims := make(map[string]map[string][]string)
+		if err := p.manifest.checkFlatten(root, nil, entry.Object, mapping.Depth(), mapping.Depth(), claims); err != nil {
+			return []string{}, err
+		}
+
+		schemaName := entry.SchemaName()
 		// create table if not exists
 		if !cache.LoadOrStore(schemaName, true) {
 			sqlStatements = append(sqlStatements, generateCreateSchemaSQL(schemaName))
@@ -85,45 +178,127 @@ func (p *OplogParser) ProcessOplog(entry OplogEntry, cache Cache) ([]string, err
 		sqlStatements = append(
 			sqlStatements,
 			p.generateTableAndInsertSQL(
-				entry.Namespace,
+				root,
 				cache,
+				mapping,
 				nil,
 				entry.Object,
 			)...)
 	case "u":
-		if sql, err := generateUpdateSQL(entry); err == nil {
+		sqls, err := p.generateUpdateSQL(entry, mapping, cache)
+		if err != nil {
+			return []string{}, err
+		}
+		sqlStatements = append(sqlStatements, sqls...)
+	case "d":
+		generate := generateDeleteSQL
+		if mapping.SoftDeletes() {
+			generate = func(entry OplogEntry) (string, error) {
+				return generateSoftDeleteSQL(entry, mapping)
+			}
+		}
+		if sql, err := generate(entry); err == nil {
+			sqlStatements = append(sqlStatements, sql)
+		}
+	}
+
+	if len(sqlStatements) == 0 {
+		return []string{}, fmt.Errorf("invalid oplog")
+	}
+	if mapping.KeepsHistory() {
+		sqlStatements = append(sqlStatements, p.generateHistorySQL(entry, mapping, cache)...)
+	}
+	p.metrics.StatementsGenerated(source, len(sqlStatements))
+
+	return sqlStatements, nil
+}
+
+// processDocumentOplog converts the oplog of a collection in jsonb mode.
+func (p *OplogParser) processDocumentOplog(
+	entry OplogEntry,
+	mapping entryMapping,
+	cache Cache,
+	source string,
+) ([]string, error) {
+	sqlStatements := []string{}
+	var err error
+	switch entry.Operation {
+	case "i":
+		schemaName := entry.SchemaName()
+		if !cache.LoadOrStore(schemaName, true) {
+			sqlStatements = append(sqlStatements, generateCreateSchemaSQL(schemaName))
+		}
+
+		var insertStatements []string
+		if insertStatements, err = generateDocumentTableAndInsertSQL(entry.Namespace, cache, mapping, entry.Object); err == nil {
+			sqlStatements = append(sqlStatements, insertStatements...)
+		}
+	case "u":
+		var sql string
+		if sql, err = generateDocumentUpdateSQL(entry, mapping); err == nil {
 			sqlStatements = append(sqlStatements, sql)
 		}
 	case "d":
-		if sql, err := generateDeleteSQL(entry); err == nil {
+		var sql string
+		if mapping.SoftDeletes() {
+			sql, err = generateSoftDeleteSQL(entry, mapping)
+		} else {
+			sql, err = generateDocumentDeleteSQL(entry)
+		}
+		if err == nil {
 			sqlStatements = append(sqlStatements, sql)
 		}
 	}
 
+	if err != nil {
+		return []string{}, err
+	}
 	if len(sqlStatements) == 0 {
 		return []string{}, fmt.Errorf("invalid oplog")
 	}
+	if mapping.KeepsHistory() {
+		sqlStatements = append(sqlStatements, p.generateHistorySQL(entry, mapping, cache)...)
+	}
+	p.metrics.StatementsGenerated(source, len(sqlStatements))
 
 	return sqlStatements, nil
 }
 
 func generateCreateSchemaSQL(schemaName string) string {
-	return fmt.Sprintf("CREATE SCHEMA %s;", schemaName)
+	return fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", schemaName)
 }
 
 func (p *OplogParser) generateTableAndInsertSQL(
-	namespace string,
+	tbl table,
 	cache Cache,
+	mapping entryMapping,
 	foreignColumn *Column,
 	data map[string]interface{},
 ) []string {
 	sqlStatements := []string{}
 
+	// embedded objects up to the flatten depth become columns of the table
+	data = p.manifest.flatten(tbl, nil, data, mapping.Depth())
+	mapping.addAuditColumns(data)
+	namespace := tbl.namespace
+
+	// deleted documents are kept as rows of the collection table in soft delete mode
+	softDelete := mapping.SoftDeletes() && len(tbl.path) == 0
+
 	// create table if not exists
 	if !cache.LoadOrStore(namespace, true) {
+
+		references := ""
+		if p.config.Schema.ForeignKeys {
+			references = tbl.parent
+		}
+		var systemColumns []string
+		if softDelete {
+			systemColumns = softDeleteColumns()
+		}
 		sqlStatements = append(
 			sqlStatements,
-			generateCreateTableSQL(namespace, cache, foreignColumn, data),
+			generateCreateTableSQL(namespace, cache, foreignColumn, references, tbl.primaryKey, systemColumns, data),
 		)
 	} else if isEligibleForAlterTable(namespace, cache, data) { // alter table if applicable
 		sqlStatements = append(sqlStatements, generateAlterTableSQL(namespace, cache, data))
@@ -134,32 +309,42 @@ func (p *OplogParser) generateTableAndInsertSQL(
 		data[foreignColumn.Name] = foreignColumn.Value
 	}
 
-	// generate insert statement
-	sqlStatements = append(sqlStatements, p.generateInsertSQL(namespace, data))
-
-	nsParts := strings.Split(namespace, ".")
-	schema := nsParts[0]
-	collection := nsParts[1]
+	// generate insert statement, which undeletes a soft deleted document inserted again, and
+	// overwrites the row of a document which may already be stored
+	insertSQL := p.generateInsertSQL(namespace, data)
+	replace := mapping.upsert && len(tbl.path) == 0
+	switch {
+	case softDelete:
+		insertSQL = undeleteInsertSQL(insertSQL, namespace, cache, scalarColumns(data))
+	case replace:
+		insertSQL = upsertInsertSQL(insertSQL, scalarColumns(data))
+	}
+	// the row of an overwriting insert may replace a stored one, which a new row cannot do
+	if !replace {
+		mapping.addRow(insertSQL, namespace, scalarColumns(data), data)
+	}
+	sqlStatements = append(sqlStatements, insertSQL)
 
-	// generate SQL statements for nested objects or arrays of objects
+	// generate SQL statements for nested objects or arrays of objects, in child tables
+	// named from the path of the field
 	columnNames := sortColumns(data)
 	for _, columnName := range columnNames {
 		value := data[columnName]
 
-		switch reflect.TypeOf(value).Kind() {
+		switch kind := valueKind(value); kind {
 		case reflect.Slice, reflect.Map:
-			foreignTableName := fmt.Sprintf("%s_%s", collection, columnName)
+			childTable, foreignKey := p.manifest.child(tbl, columnName, tableKind(kind))
 			foreignColumn := &Column{
-				Name:  collection + "__id",
+				Name:  foreignKey,
 				Value: data["_id"],
 			}
 
 			sqlStatements = append(
 				sqlStatements,
 				p.generateSQLForNestedObject(
-					schema,
+					childTable,
 					cache,
-					foreignTableName,
+					mapping,
 					foreignColumn,
 					value,
 				)...)
@@ -168,28 +353,95 @@ func (p *OplogParser) generateTableAndInsertSQL(
 		}
 	}
 
+	// the child rows of the document overwritten by the insert are deleted before the new ones
+	// are inserted, once the tables created for them exist
+	switch {
+	case replace:
+		sqlStatements = purgeBeforeInsertSQL(sqlStatements, p.deleteChildrenSQL(namespace, cache, data["_id"], ""))
+	case softDelete:
+		sqlStatements = purgeBeforeInsertSQL(sqlStatements, p.purgeDeletedChildrenSQL(tbl, cache, data["_id"]))
+	}
+
+	return sqlStatements
+}
+
+// purgeBeforeInsertSQL returns the statements inserting a document with the purge statements
+// moved after its CREATE and ALTER statements and before its rows.
+func purgeBeforeInsertSQL(sqlStatements []string, purgeStatements []string) []string {
+	ddl := make([]string, 0, len(sqlStatements)+len(purgeStatements))
+	rows := []string{}
+	for _, sql := range sqlStatements {
+		if strings.HasPrefix(sql, "CREATE ") || strings.HasPrefix(sql, "ALTER ") {
+			ddl = append(ddl, sql)
+		} else {
+			rows = append(rows, sql)
+		}
+	}
+	return append(append(ddl, purgeStatements...), rows...)
+}
+
+// deleteChildrenSQL deletes the rows of the descendant tables of the table belonging to the row
+// with the given _id, deepest first and only when the condition holds, if any.
+func (p *OplogParser) deleteChildrenSQL(namespace string, cache Cache, id interface{}, condition string) []string {
+	return p.deleteDescendantsSQL(namespace, cache, "= "+getColumnValue(id), condition)
+}
+
+// deleteDescendantsSQL deletes the rows of the descendant tables of the table whose foreign key
+// matches parents, deepest first.
+func (p *OplogParser) deleteDescendantsSQL(namespace string, cache Cache, parents string, condition string) []string {
+	sqlStatements := []string{}
+	for _, child := range p.manifest.children(namespace) {
+		if !cache.Get(child.Table) {
+			continue
+		}
+		where := fmt.Sprintf("%s %s", child.ForeignKey, parents)
+		sqlStatements = append(sqlStatements, p.deleteDescendantsSQL(
+			child.Table,
+			cache,
+			fmt.Sprintf("IN (SELECT _id FROM %s WHERE %s)", child.Table, where),
+			condition,
+		)...)
+		if condition != "" {
+			where = fmt.Sprintf("%s AND %s", where, condition)
+		}
+		sqlStatements = append(sqlStatements, fmt.Sprintf("DELETE FROM %s WHERE %s;", child.Table, where))
+	}
 	return sqlStatements
 }
 
+// generateCreateTableSQL creates the table of the data, along with the given system column
+// definitions, unless it exists from a previous run. The foreign column of a sub table references the _id of the references table
+// when given, with a constraint checked at commit so that the rows of a transaction can be
+// inserted in any order. A sub table is keyed by its primary key columns, its _id by default.
 func generateCreateTableSQL(
 	tableName string,
 	cache Cache,
 	foreignColumn *Column,
+	references string,
+	primaryKey []string,
+	systemColumns []string,
 	data map[string]interface{},
 ) string {
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("CREATE TABLE %s (", tableName))
+	sb.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (", tableName))
 
 	columnNames := sortColumns(data)
 
 	sep := ""
+	keyedByID := len(primaryKey) == 0 || len(primaryKey) == 1 && primaryKey[0] == "_id"
 	if foreignColumn != nil {
-		// create primary key for sub table
-		primaryKeyCol := Column{
+		// the _id of a sub table keyed by other columns, such as array elements keyed by their
+		// parent and position, still identifies its rows in their own sub tables
+		idColumn := Column{
 			Name:  "_id",
 			Value: "",
 		}
-		sb.WriteString(createColumn(tableName, primaryKeyCol, cache))
+		cache.SetColumnType(tableName, idColumn.Name, idColumn.DataType())
+		idConstraint := "PRIMARY KEY"
+		if !keyedByID {
+			idConstraint = "UNIQUE"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s %s", idColumn.Name, idColumn.DataType(), idConstraint))
 		sep = ", "
 		// create foreign key in the sub table
 		sb.WriteString(fmt.Sprintf("%s%s", sep, createColumn(tableName, *foreignColumn, cache)))
@@ -199,7 +451,7 @@ func generateCreateTableSQL(
 		value := data[columnName]
 
 		// skip nested objects or arrays of objects
-		switch reflect.TypeOf(value).Kind() {
+		switch valueKind(value) {
 		case reflect.Slice, reflect.Map:
 			continue
 		}
@@ -210,13 +462,27 @@ func generateCreateTableSQL(
 		sep = ", "
 	}
 
+	for _, systemColumn := range systemColumns {
+		sb.WriteString(fmt.Sprintf("%s%s", sep, systemColumn))
+		sep = ", "
+	}
+
+	if foreignColumn != nil && !keyedByID {
+		sb.WriteString(fmt.Sprintf(", PRIMARY KEY (%s)", strings.Join(primaryKey, ", ")))
+	}
+	if foreignColumn != nil && references != "" {
+		sb.WriteString(fmt.Sprintf(
+			", FOREIGN KEY (%s) REFERENCES %s (_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED",
+			foreignColumn.Name,
+			references,
+		))
+	}
 	sb.WriteString(");")
 	return sb.String()
 }
 
 func createColumn(tableName string, column Column, cache Cache) string {
-	cacheKey := fmt.Sprintf("%s.%s.%s", tableName, column.Name, column.DataType())
-	cache.LoadOrStore(cacheKey, true)
+	cache.SetColumnType(tableName, column.Name, column.DataType())
 
 	if column.PrimaryKey() {
 		return fmt.Sprintf("%s %s PRIMARY KEY", column.Name, column.DataType())
@@ -225,37 +491,63 @@ func createColumn(tableName string, column Column, cache Cache) string {
 }
 
 func (p *OplogParser) generateSQLForNestedObject(
-	schema string,
+	tbl table,
 	cache Cache,
-	tableName string,
+	mapping entryMapping,
 	foreignColumn *Column,
 	value interface{},
 ) []string {
-	namespace := fmt.Sprintf("%s.%s", schema, tableName)
-
-	switch reflect.TypeOf(value).Kind() {
+	switch valueKind(value) {
 	case reflect.Slice:
 		sqlStatements := []string{}
 		entries := value.([]interface{})
-		for _, entry := range entries {
-			subData := entry.(map[string]interface{})
-			subStatements := p.generateTableAndInsertSQL(
-				namespace,
+		for index, entry := range entries {
+			subStatements := p.generateElementSQL(
+				tbl,
 				cache,
+				mapping,
 				foreignColumn,
-				subData,
+				index,
+				entry,
 			)
 			sqlStatements = append(sqlStatements, subStatements...)
 		}
 		return sqlStatements
 	case reflect.Map:
 		subData := value.(map[string]interface{})
-		return p.generateTableAndInsertSQL(namespace, cache, foreignColumn, subData)
+		return p.generateTableAndInsertSQL(tbl, cache, mapping, foreignColumn, subData)
 	}
 
 	return []string{}
 }
 
+// tableKind returns the manifest kind of the sub table of a field of the given kind.
+func tableKind(kind reflect.Kind) string {
+	if kind == reflect.Slice {
+		return TABLE_ARRAY
+	}
+	return TABLE_OBJECT
+}
+
+// generateElementSQL inserts the array element at index, with its position in the ORDINAL_COLUMN.
+// Scalar elements are stored in the ELEMENT_COLUMN.
+func (p *OplogParser) generateElementSQL(
+	tbl table,
+	cache Cache,
+	mapping entryMapping,
+	foreignColumn *Column,
+	index int,
+	element interface{},
+) []string {
+	subData, ok := element.(map[string]interface{})
+	if !ok {
+		subData = map[string]interface{}{ELEMENT_COLUMN: element}
+	}
+	subData[ORDINAL_COLUMN] = index
+
+	return p.generateTableAndInsertSQL(tbl, cache, mapping, foreignColumn, subData)
+}
+
 func isEligibleForAlterTable(
 	namespace string,
 	cache Cache,
@@ -263,15 +555,11 @@ func isEligibleForAlterTable(
 ) bool {
 	for columnName, value := range data {
 		// skip nested objects or arrays of objects
-		switch reflect.TypeOf(value).Kind() {
+		switch valueKind(value) {
 		case reflect.Slice, reflect.Map:
 			continue
 		}
-		column := Column{Name: columnName, Value: value}
-		columnDataType := column.DataType()
-
-		cacheKey := fmt.Sprintf("%s.%s.%s", namespace, columnName, columnDataType)
-		if !cache.Get(cacheKey) {
+		if clause, _ := alterColumnSQL(namespace, cache, columnName, value); clause != "" {
 			return true
 		}
 	}
@@ -287,26 +575,35 @@ func generateAlterTableSQL(
 	sb.WriteString(fmt.Sprintf("ALTER TABLE %s", namespace))
 
 	sep := " "
-	for columnName, value := range data {
-		// skip nested objects or arrays of objects
-		switch reflect.TypeOf(value).Kind() {
-		case reflect.Slice, reflect.Map:
+	for _, columnName := range scalarColumns(data) {
+		clause, columnDataType := alterColumnSQL(namespace, cache, columnName, data[columnName])
+		if clause == "" {
 			continue
 		}
-		column := Column{Name: columnName, Value: value}
-		columnDataType := column.DataType()
-
-		cacheKey := fmt.Sprintf("%s.%s.%s", namespace, columnName, columnDataType)
-		if !cache.LoadOrStore(cacheKey, true) {
-			sb.WriteString(fmt.Sprintf("%sADD COLUMN %s %s", sep, columnName, columnDataType))
-			sep = ", "
-		}
+		cache.SetColumnType(namespace, columnName, columnDataType)
+		sb.WriteString(sep + clause)
+		sep = ", "
 	}
 
 	sb.WriteString(";")
 	return sb.String()
 }
 
+// alterColumnSQL returns the ALTER TABLE clause adding the column of the value to the table, or
+// widening the numeric type of the column to hold the value, along with the resulting type of
+// the column. The clause is empty when the column already holds the value.
+func alterColumnSQL(namespace string, cache Cache, columnName string, value interface{}) (string, string) {
+	columnDataType := getColumnSQLDataType(columnName, value)
+	current, ok := cache.ColumnType(namespace, columnName)
+	if !ok {
+		return fmt.Sprintf("ADD COLUMN IF NOT EXISTS %s %s", columnName, columnDataType), columnDataType
+	}
+	if widened := widenDataType(current, columnDataType); widened != current {
+		return fmt.Sprintf("ALTER COLUMN %s TYPE %s", columnName, widened), widened
+	}
+	return "", current
+}
+
 func (p *OplogParser) generateInsertSQL(tableName string, data map[string]interface{}) string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("INSERT INTO %s ", tableName))
@@ -316,46 +613,37 @@ func (p *OplogParser) generateInsertSQL(tableName string, data map[string]interf
 		data["_id"] = p.uuidGenerator.UUID()
 	}
 
-	columns := make([]string, 0, len(data))
-	values := make([]string, 0, len(data))
-
-	columnNames := sortColumns(data)
-	for _, columnName := range columnNames {
-		value := data[columnName]
-		// skip nested objects or arrays of objects
-		switch reflect.TypeOf(value).Kind() {
-		case reflect.Slice, reflect.Map:
-			continue
-		}
-
-		columns = append(columns, columnName)
-		values = append(values, getColumnValue(value))
+	// skip nested objects or arrays of objects
+	columns := scalarColumns(data)
+	values := make([]string, 0, len(columns))
+	for _, columnName := range columns {
+		values = append(values, getColumnValue(data[columnName]))
 	}
 
 	sb.WriteString(fmt.Sprintf("(%s) VALUES (%s);", strings.Join(columns, ", "), strings.Join(values, ", ")))
 	return sb.String()
 }
 
-func generateUpdateSQL(entry OplogEntry) (string, error) {
-	diffMap, ok := entry.Object["diff"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("invalid diff oplog")
+// upsertInsertSQL turns an INSERT into an upsert, which overwrites the columns of the row of
+// the same _id along with the given assignments.
+func upsertInsertSQL(insertSQL string, columnNames []string, setCols ...string) string {
+	assignments := []string{}
+	for _, columnName := range columnNames {
+		if columnName == "_id" {
+			continue
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", columnName, columnName))
 	}
+	assignments = append(assignments, setCols...)
 
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("UPDATE %s SET ", entry.Namespace))
-
-	var setUnsetCols string
-	if setMap, ok := diffMap["u"].(map[string]interface{}); ok {
-		setUnsetCols = generateSetClause(setMap)
-	} else if unsetMap, ok := diffMap["d"].(map[string]interface{}); ok {
-		setUnsetCols = generateUnsetClause(unsetMap)
-	} else {
-		return "", fmt.Errorf("invalid operation in diff oplog")
+	if len(assignments) == 0 {
+		return fmt.Sprintf("%s ON CONFLICT (_id) DO NOTHING;", strings.TrimSuffix(insertSQL, ";"))
 	}
-
-	sb.WriteString(fmt.Sprintf("%s %s;", setUnsetCols, generateWhereClause(entry.Object2)))
-	return sb.String(), nil
+	return fmt.Sprintf(
+		"%s ON CONFLICT (_id) DO UPDATE SET %s;",
+		strings.TrimSuffix(insertSQL, ";"),
+		strings.Join(assignments, ", "),
+	)
 }
 
 func generateDeleteSQL(entry OplogEntry) (string, error) {
@@ -368,20 +656,6 @@ func generateDeleteSQL(entry OplogEntry) (string, error) {
 	return sb.String(), nil
 }
 
-func generateSetClause(setMap map[string]interface{}) string {
-	setCols := getSortedCols(setMap)
-	return strings.Join(setCols, ", ")
-}
-
-func generateUnsetClause(unsetMap map[string]interface{}) string {
-	unsetColNames := sortColumns(unsetMap)
-	var unsetCols []string
-	for _, columnName := range unsetColNames {
-		unsetCols = append(unsetCols, fmt.Sprintf("%s = NULL", columnName))
-	}
-	return strings.Join(unsetCols, ", ")
-}
-
 func generateWhereClause(whereMap map[string]interface{}) string {
 	whereCols := getSortedCols(whereMap)
 	return fmt.Sprintf("WHERE %s", strings.Join(whereCols, " AND "))
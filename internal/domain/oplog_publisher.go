@@ -1,5 +1,7 @@
 package domain
 
+import "sync"
+
 // OplogPublisher defines the interface for publishing Oplog entries.
 type OplogPublisher interface {
 	PublishOplog(entry OplogEntry) error
@@ -10,19 +12,36 @@ type OplogPublisher interface {
 // InMemoryOplogPublisher is an in-memory implementation of the OplogPublisher.
 type InMemoryOplogPublisher struct {
 	channel chan OplogEntry
+	// done is closed with the publisher, which releases a reader waiting for room in the channel
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-// NewInMemoryOplogPublisher creates a new instance of InMemoryOplogPublisher.
-func NewInMemoryOplogPublisher() OplogPublisher {
+// NewInMemoryOplogPublisher creates a new instance of InMemoryOplogPublisher buffering up to size entries.
+func NewInMemoryOplogPublisher(size int, m Metrics) OplogPublisher {
+	channel := make(chan OplogEntry, size)
+	m.OplogBuffer(func() int { return len(channel) })
 	return &InMemoryOplogPublisher{
-		channel: make(chan OplogEntry, 100),
+		channel: channel,
+		done:    make(chan struct{}),
 	}
 }
 
-// PublishOplog publishes the given Oplog entry by sending it to the channel.
+// PublishOplog publishes the given Oplog entry by sending it to the channel, waiting while the
+// channel is full until the publisher is closed.
 func (p *InMemoryOplogPublisher) PublishOplog(entry OplogEntry) error {
-	p.channel <- entry
-	return nil
+	select {
+	case <-p.done:
+		return ErrPublisherClosed
+	default:
+	}
+
+	select {
+	case p.channel <- entry:
+		return nil
+	case <-p.done:
+		return ErrPublisherClosed
+	}
 }
 
 // GetOplogs retrieves a channel of Oplog entries from the in-memory publisher.
@@ -34,3 +53,11 @@ func (p *InMemoryOplogPublisher) GetOplogs() (<-chan OplogEntry, error) {
 func (p *InMemoryOplogPublisher) Stop() {
 	close(p.channel)
 }
+
+// Close rejects the entries published from now on, once the consumer of the channel has stopped.
+func (p *InMemoryOplogPublisher) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
@@ -0,0 +1,365 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxIdentifierLength is the length limit of PostgreSQL identifiers.
+const maxIdentifierLength = 63
+
+// Kinds of the tables of the manifest.
+const (
+	TABLE_COLLECTION = "collection"
+	TABLE_OBJECT     = "object"
+	TABLE_ARRAY      = "array"
+	TABLE_HISTORY    = "history"
+)
+
+// table identifies a generated table by the collection and the path of the documents it holds.
+type table struct {
+	namespace  string
+	collection string
+	path       []string
+	// parent is the table referenced by the foreign key of a child table.
+	parent string
+	// primaryKey are the columns keying the rows of a child table.
+	primaryKey []string
+}
+
+// ManifestTable describes a generated table: the table of a collection, or the child table of
+// the embedded objects or arrays at a path of its documents, along with the foreign key column
+// referencing the _id of its parent. The Columns of a table flattening embedded objects map the
+// names of its columns and child fields to their path in its rows.
+type ManifestTable struct {
+	Table      string   `json:"table"`
+	Collection string   `json:"collection"`
+	Path       []string `json:"path"`
+	Kind       string   `json:"kind"`
+	PrimaryKey []string `json:"primary_key"`
+	Parent     string   `json:"parent,omitempty"`
+	ForeignKey string   `json:"foreign_key,omitempty"`
+	References string   `json:"references,omitempty"`
+
+	Columns map[string][]string `json:"columns,omitempty"`
+}
+
+// schemaManifest names the child tables from the full path of their documents, and records
+// the schema tree in the manifest file when configured, from which the names are reloaded on
+// restart so that a path keeps its table.
+//
+// The name of a child table is the name of its collection followed by the fields of its path,
+// the first field joined with an underscore and the next ones with two, as in
+// student_address__geo, and the history table of a collection is named <collection>_history.
+// Names are suffixed with a hash of the path when they exceed the identifier limit, or when
+// another path of the collection could be joined to the same name: a field holding two
+// underscores, a field starting or ending with one under a nested path, or the field history.
+// A name is never changed once given. The paths of different collections may still be joined
+// to the same name, emp.a and the collection emp_a, in which case the table named last is
+// suffixed, the table of a collection included.
+type schemaManifest struct {
+	path        string
+	foreignKeys bool
+	logger      *slog.Logger
+
+	mu     sync.Mutex
+	byPath map[string]ManifestTable
+	byName map[string]string
+}
+
+func newSchemaManifest(path string, foreignKeys bool, logger *slog.Logger) *schemaManifest {
+	m := &schemaManifest{
+		path:        path,
+		foreignKeys: foreignKeys,
+		logger:      logger,
+		byPath:      make(map[string]ManifestTable),
+		byName:      make(map[string]string),
+	}
+	if path == "" {
+		return m
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m
+	}
+	var manifest struct {
+		Tables []ManifestTable `json:"tables"`
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		logger.Warn("ignoring unreadable schema manifest", "path", path, "error", err)
+		return m
+	}
+
+	for _, manifestTable := range manifest.Tables {
+		key := manifestTable.key()
+		m.byPath[key] = manifestTable
+		m.byName[manifestTable.Table] = key
+	}
+	return m
+}
+
+// collection records the table of a collection and returns its name, the namespace unless
+// the name is held by a table of another collection.
+func (m *schemaManifest) collection(namespace string) string {
+	key := pathKey(namespace, nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if collection, ok := m.byPath[key]; ok {
+		return collection.Table
+	}
+
+	collection := ManifestTable{
+		Collection: namespace,
+		Path:       []string{},
+		Kind:       TABLE_COLLECTION,
+		PrimaryKey: []string{"_id"},
+	}
+	m.add(&collection, key)
+	return collection.Table
+}
+
+// history returns the name of the history table of a collection.
+func (m *schemaManifest) history(namespace string) string {
+	key := historyKey(namespace)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if history, ok := m.byPath[key]; ok {
+		return history.Table
+	}
+
+	history := ManifestTable{
+		Collection: namespace,
+		Path:       []string{},
+		Kind:       TABLE_HISTORY,
+		PrimaryKey: []string{"_id", HISTORY_TS_COLUMN},
+	}
+	m.add(&history, key)
+	return history.Table
+}
+
+// child returns the table of the field of the parent table, holding the embedded objects or
+// the elements of the arrays of the given kind, and the name of its foreign key column.
+func (m *schemaManifest) child(parent table, field string, kind string) (table, string) {
+	path := append(append([]string(nil), parent.path...), field)
+	key := pathKey(parent.collection, path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	child, ok := m.byPath[key]
+	if !ok {
+		parentName := strings.SplitN(parent.collection, SEPERATOR, 2)[1]
+		if len(parent.path) > 0 {
+			parentName = fmt.Sprintf("%s_%s", parentName, strings.Join(parent.path, "_"))
+		}
+
+		child = ManifestTable{
+			Collection: parent.collection,
+			Path:       path,
+			Kind:       kind,
+			PrimaryKey: []string{"_id"},
+			Parent:     parent.namespace,
+			ForeignKey: identifier(parentName+"__id", key),
+			References: "_id",
+		}
+		if kind == TABLE_ARRAY {
+			child.PrimaryKey = []string{child.ForeignKey, ORDINAL_COLUMN}
+		}
+		m.add(&child, key)
+	}
+
+	return child.table(), child.ForeignKey
+}
+
+// add names the new table of the key after its path, suffixed with a hash of the key when
+// the name is ambiguous or held by another table, and records it.
+func (m *schemaManifest) add(manifestTable *ManifestTable, key string) {
+	if manifestTable.Kind == TABLE_COLLECTION {
+		manifestTable.Table = manifestTable.Collection
+	} else {
+		db := strings.SplitN(manifestTable.Collection, SEPERATOR, 2)[0]
+		manifestTable.Table = db + SEPERATOR + identifier(manifestTable.fullName(), key)
+		if manifestTable.ambiguous() {
+			manifestTable.Table = manifestTable.hashedName(key)
+		}
+	}
+	if _, taken := m.byName[manifestTable.Table]; taken {
+		manifestTable.Table = manifestTable.hashedName(key)
+		m.logger.Warn("table name held by another path", "collection", manifestTable.Collection,
+			"path", strings.Join(manifestTable.Path, "."), "table", manifestTable.Table)
+	}
+
+	m.byPath[key] = *manifestTable
+	m.byName[manifestTable.Table] = key
+	m.write()
+}
+
+// ambiguous reports whether another path of the collection could be joined to the name of
+// the child table.
+func (t ManifestTable) ambiguous() bool {
+	if t.Kind != TABLE_OBJECT && t.Kind != TABLE_ARRAY {
+		return false
+	}
+	if len(t.Path) == 1 && t.Path[0] == "history" {
+		return true
+	}
+	for _, field := range t.Path {
+		if strings.Contains(field, "__") {
+			return true
+		}
+		if len(t.Path) > 1 && (strings.HasPrefix(field, "_") || strings.HasSuffix(field, "_")) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the child table of the field of the parent table and the name of its foreign
+// key column, if the field ever had one.
+func (m *schemaManifest) find(parent table, field string) (table, string, bool) {
+	path := append(append([]string(nil), parent.path...), field)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	child, ok := m.byPath[pathKey(parent.collection, path)]
+	if !ok {
+		return table{}, "", false
+	}
+	return child.table(), child.ForeignKey, true
+}
+
+// children returns the child tables of the table, sorted by name.
+func (m *schemaManifest) children(parent string) []ManifestTable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	children := []ManifestTable{}
+	for _, manifestTable := range m.byPath {
+		if manifestTable.Parent == parent {
+			children = append(children, manifestTable)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Table < children[j].Table
+	})
+	return children
+}
+
+func (t ManifestTable) table() table {
+	return table{namespace: t.Table, collection: t.Collection, path: t.Path, parent: t.Parent, primaryKey: t.PrimaryKey}
+}
+
+// fullName returns the name of a table joined from its path, before it is truncated or suffixed.
+func (t ManifestTable) fullName() string {
+	collection := strings.SplitN(t.Collection, SEPERATOR, 2)[1]
+	switch t.Kind {
+	case TABLE_COLLECTION:
+		return collection
+	case TABLE_HISTORY:
+		return collection + "_history"
+	}
+	return fmt.Sprintf("%s_%s", collection, strings.Join(t.Path, "__"))
+}
+
+// hashedName returns the name of the table of the key suffixed with a hash of the key.
+func (t ManifestTable) hashedName(key string) string {
+	db := strings.SplitN(t.Collection, SEPERATOR, 2)[0]
+	return db + SEPERATOR + hashedIdentifier(t.fullName(), key)
+}
+
+// write replaces the manifest file with the known tables, sorted by name.
+func (m *schemaManifest) write() {
+	if m.path == "" {
+		return
+	}
+
+	manifest := struct {
+		ForeignKeys bool            `json:"foreign_keys"`
+		Tables      []ManifestTable `json:"tables"`
+	}{ForeignKeys: m.foreignKeys, Tables: make([]ManifestTable, 0, len(m.byPath))}
+	for _, manifestTable := range m.byPath {
+		manifest.Tables = append(manifest.Tables, manifestTable)
+	}
+	sort.Slice(manifest.Tables, func(i, j int) bool {
+		return manifest.Tables[i].Table < manifest.Tables[j].Table
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(m.path, append(data, '\n'))
+	}
+	if err != nil {
+		m.logger.Error("failed to write schema manifest", "path", m.path, "error", err)
+	}
+}
+
+// writeFileAtomic replaces the file with data through a temporary file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// key returns the key of the table in the manifest.
+func (t ManifestTable) key() string {
+	if t.Kind == TABLE_HISTORY {
+		return historyKey(t.Collection)
+	}
+	return pathKey(t.Collection, t.Path)
+}
+
+func historyKey(collection string) string {
+	return collection + "\x01history"
+}
+
+func pathKey(collection string, path []string) string {
+	return collection + "\x00" + strings.Join(path, "\x00")
+}
+
+// identifier returns the name, truncated and suffixed with a hash of key when it exceeds
+// the identifier limit.
+func identifier(name, key string) string {
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+	return hashedIdentifier(name, key)
+}
+
+// hashedIdentifier returns the name truncated to fit a hash of key as suffix.
+func hashedIdentifier(name, key string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	suffix := fmt.Sprintf("_%08x", hash.Sum32())
+
+	cut := maxIdentifierLength - len(suffix)
+	if cut >= len(name) {
+		return name + suffix
+	}
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + suffix
+}
@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+)
+
+// manifestPath is a table registered in the manifest: the table of a collection when path is
+// empty and history is not set, its history table, or the child table of a path.
+type manifestPath struct {
+	collection string
+	path       []string
+	history    bool
+}
+
+func (p manifestPath) register(m *schemaManifest) string {
+	if p.history {
+		return m.history(p.collection)
+	}
+	if len(p.path) == 0 {
+		return m.collection(p.collection)
+	}
+
+	parent := table{namespace: p.collection, collection: p.collection}
+	for _, field := range p.path {
+		parent, _ = m.child(parent, field, TABLE_OBJECT)
+	}
+	return parent.namespace
+}
+
+func (p manifestPath) key() string {
+	if p.history {
+		return historyKey(p.collection)
+	}
+	return pathKey(p.collection, p.path)
+}
+
+func TestSchemaManifestNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []manifestPath
+		// want are the tables of the paths, whichever order they are registered in
+		want []string
+	}{
+		{
+			name: "Nested paths",
+			paths: []manifestPath{
+				{collection: "test.student", path: []string{"address"}},
+				{collection: "test.student", path: []string{"address", "geo"}},
+				{collection: "test.student", path: []string{"line_items"}},
+			},
+			want: []string{"test.student_address", "test.student_address__geo", "test.student_line_items"},
+		},
+		{
+			name: "Child tables of the same name",
+			paths: []manifestPath{
+				{collection: "test.emp", path: []string{"a", "b"}},
+				{collection: "test.emp", path: []string{"a__b"}},
+			},
+			want: []string{"test.emp_a__b", "test.emp_a__b_619449f2"},
+		},
+		{
+			name: "Fields ending with an underscore",
+			paths: []manifestPath{
+				{collection: "test.emp", path: []string{"a_", "b"}},
+				{collection: "test.emp", path: []string{"a", "_b"}},
+			},
+			want: []string{"test.emp_a___b_6c450691", "test.emp_a___b_b1244327"},
+		},
+		{
+			name: "History table named as a child table",
+			paths: []manifestPath{
+				{collection: "test.emp", path: []string{"history"}},
+				{collection: "test.emp", history: true},
+			},
+			want: []string{"test.emp_history_fa1bf1b5", "test.emp_history"},
+		},
+		{
+			name: "Child tables of a collection named after a longer collection",
+			paths: []manifestPath{
+				{collection: "test.emp", path: []string{"a", "b"}},
+				{collection: "test.emp_a", path: []string{"b"}},
+			},
+			want: []string{"test.emp_a__b", "test.emp_a_b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, order := range [][]int{ascending(len(test.paths)), descending(len(test.paths))} {
+				m := newSchemaManifest("", false, logging.NewNopLogger())
+				for _, i := range order {
+					test.paths[i].register(m)
+				}
+
+				got := make([]string, 0, len(test.paths))
+				for _, path := range test.paths {
+					got = append(got, m.byPath[path.key()].Table)
+				}
+				if !reflect.DeepEqual(got, test.want) {
+					t.Errorf("Tables registered in order %v do not match the expected result.\nWant: %v\nGot: %v", order, test.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaManifestCollisions(t *testing.T) {
+	// the child table of test.emp and the collection test.emp_a are joined to the same name,
+	// which stays with the table named first
+	tests := []struct {
+		name  string
+		paths []manifestPath
+		want  []string
+	}{
+		{
+			name: "Collection named after a child table",
+			paths: []manifestPath{
+				{collection: "test.emp", path: []string{"a"}},
+				{collection: "test.emp_a"},
+			},
+			want: []string{"test.emp_a", "test.emp_a_2a9c339b"},
+		},
+		{
+			name: "Child table named after a collection",
+			paths: []manifestPath{
+				{collection: "test.emp_a"},
+				{collection: "test.emp", path: []string{"a"}},
+			},
+			want: []string{"test.emp_a", "test.emp_a_e43a011e"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSchemaManifest("", false, logging.NewNopLogger())
+			got := make([]string, 0, len(test.paths))
+			for _, path := range test.paths {
+				got = append(got, path.register(m))
+			}
+			// the names are kept once given
+			for i, path := range test.paths {
+				if table := path.register(m); table != got[i] {
+					t.Errorf("Table of %v is renamed.\nWant: %s\nGot: %s", path, got[i], table)
+				}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Tables do not match the expected result.\nWant: %v\nGot: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func ascending(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+func descending(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = n - 1 - i
+	}
+	return order
+}
@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// DELETED_COLUMN flags the rows of the documents deleted in soft delete mode.
+	DELETED_COLUMN string = "_deleted"
+	// DELETED_AT_COLUMN holds the wall time of the deletion of the document.
+	DELETED_AT_COLUMN string = "_deleted_at"
+)
+
+// softDeleteColumns returns the definitions of the tombstone columns of a collection table.
+func softDeleteColumns() []string {
+	return []string{
+		fmt.Sprintf("%s BOOLEAN DEFAULT false", DELETED_COLUMN),
+		fmt.Sprintf("%s TIMESTAMPTZ", DELETED_AT_COLUMN),
+	}
+}
+
+// generateSoftDeleteSQL flags the row of the deleted document instead of deleting it.
+func generateSoftDeleteSQL(entry OplogEntry, mapping entryMapping) (string, error) {
+	if len(entry.Object) == 0 {
+		return "", fmt.Errorf("invalid oplog")
+	}
+
+	setCols := append([]string{
+		fmt.Sprintf("%s = true", DELETED_COLUMN),
+		fmt.Sprintf("%s = %s", DELETED_AT_COLUMN, getColumnValue(wallTime(entry))),
+	}, mapping.auditAssignments()...)
+	return fmt.Sprintf(
+		"UPDATE %s SET %s %s;",
+		entry.Namespace,
+		strings.Join(setCols, ", "),
+		generateWhereClause(entry.Object),
+	), nil
+}
+
+// wallTime returns the wall time of the entry, or its ts when the oplog has no wall time.
+func wallTime(entry OplogEntry) time.Time {
+	if entry.Wall.IsZero() {
+		return time.Unix(int64(entry.Timestamp.T), 0).UTC()
+	}
+	return entry.Wall
+}
+
+// undeleteInsertSQL turns the INSERT of a document into an upsert, which overwrites and
+// undeletes the row left by a previous soft delete of the same _id. The known columns of the
+// table which are not set by the INSERT are nulled, so that no field of the deleted document
+// is left in the row.
+func undeleteInsertSQL(insertSQL string, namespace string, cache Cache, columnNames []string) string {
+	set := make(map[string]bool, len(columnNames))
+	for _, columnName := range columnNames {
+		set[columnName] = true
+	}
+
+	setCols := []string{}
+	for _, columnName := range cache.Columns(namespace) {
+		if columnName == "_id" || set[columnName] {
+			continue
+		}
+		setCols = append(setCols, fmt.Sprintf("%s = NULL", columnName))
+	}
+	setCols = append(
+		setCols,
+		fmt.Sprintf("%s = false", DELETED_COLUMN),
+		fmt.Sprintf("%s = NULL", DELETED_AT_COLUMN),
+	)
+	return upsertInsertSQL(insertSQL, columnNames, setCols...)
+}
+
+// purgeDeletedChildrenSQL deletes the child rows kept for the soft deleted document with the
+// given _id, before the document is inserted again. The condition on the tombstone does not
+// depend on the child rows, so the child tables are not scanned when the row is not deleted.
+func (p *OplogParser) purgeDeletedChildrenSQL(tbl table, cache Cache, id interface{}) []string {
+	deleted := fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM %s WHERE _id = %s AND %s)",
+		tbl.namespace,
+		getColumnValue(id),
+		DELETED_COLUMN,
+	)
+	return p.deleteChildrenSQL(tbl.namespace, cache, id, deleted)
+}
+
+// scalarColumns returns the sorted names of the columns of the data, skipping the nested
+// objects and arrays stored in sub tables.
+func scalarColumns(data map[string]interface{}) []string {
+	columnNames := []string{}
+	for _, columnName := range sortColumns(data) {
+		switch valueKind(data[columnName]) {
+		case reflect.Slice, reflect.Map:
+			continue
+		}
+		columnNames = append(columnNames, columnName)
+	}
+	return columnNames
+}
@@ -1,26 +1,70 @@
 package domain
 
+// SQLCommand is a generated SQL query along with the position of the oplog it was generated from
+// and the stream it was processed in, the namespace of the collection of the oplog. The commands
+// of a stream are in oplog order, but the streams of a database interleave freely.
+// A command without a query is a checkpoint: every query of the oplog at Timestamp has been
+// published.
+type SQLCommand struct {
+	Query     string
+	Timestamp Timestamp
+	Stream    string
+	// Row is the row inserted by the query, when it inserts one of literal values
+	Row *Row
+}
+
+// Row is a row inserted by a query, with its values as the text PostgreSQL reads them from, nil
+// standing for NULL, so that the row can be exported without parsing the query.
+type Row struct {
+	Table   string
+	Columns []string
+	Values  []*string
+}
+
+func (c SQLCommand) IsCheckpoint() bool {
+	return c.Query == ""
+}
+
 type SQLStatement struct {
 	dbName  string
-	sqlChan chan string
+	stream  string
+	sqlChan chan SQLCommand
 }
 
-func NewSQLStatement(dbName string) SQLStatement {
+func NewSQLStatement(dbName string, size int, m Metrics) SQLStatement {
+	sqlChan := make(chan SQLCommand, size)
+	m.StatementBuffer(dbName, func() int { return len(sqlChan) })
 	return SQLStatement{
 		dbName:  dbName,
-		sqlChan: make(chan string, 100),
+		sqlChan: sqlChan,
 	}
 }
 func (s SQLStatement) GetDBName() string {
 	return s.dbName
 }
 
-func (s SQLStatement) GetChannel() <-chan string {
+func (s SQLStatement) GetChannel() <-chan SQLCommand {
 	return s.sqlChan
 }
 
-func (s *SQLStatement) Publish(msg string) {
-	s.sqlChan <- msg
+// WithStream returns the SQLStatement publishing the commands of the given stream.
+func (s SQLStatement) WithStream(stream string) SQLStatement {
+	s.stream = stream
+	return s
+}
+
+func (s *SQLStatement) Publish(msg string, ts Timestamp) {
+	s.sqlChan <- SQLCommand{Query: msg, Timestamp: ts, Stream: s.stream}
+}
+
+// PublishRow publishes a query inserting the row.
+func (s *SQLStatement) PublishRow(msg string, row *Row, ts Timestamp) {
+	s.sqlChan <- SQLCommand{Query: msg, Timestamp: ts, Stream: s.stream, Row: row}
+}
+
+// PublishCheckpoint marks that all the queries of the oplog at ts have been published.
+func (s *SQLStatement) PublishCheckpoint(ts Timestamp) {
+	s.sqlChan <- SQLCommand{Timestamp: ts, Stream: s.stream}
 }
 
 func (s *SQLStatement) Close() {
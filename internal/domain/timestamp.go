@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Timestamp is the position of an entry in the oplog.
+type Timestamp struct {
+	T uint32 `json:"T"`
+	I uint32 `json:"I"`
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.T == 0 && t.I == 0
+}
+
+// After reports whether t is later than other in the oplog.
+func (t Timestamp) After(other Timestamp) bool {
+	return t.T > other.T || (t.T == other.T && t.I > other.I)
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d", t.T, t.I)
+}
+
+// UnmarshalJSON decodes either the {"T": t, "I": i} form of the Go driver or the
+// {"$timestamp": {"t": t, "i": i}} form of Extended JSON.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var ts struct {
+		T        uint32 `json:"T"`
+		I        uint32 `json:"I"`
+		Extended *struct {
+			T uint32 `json:"t"`
+			I uint32 `json:"i"`
+		} `json:"$timestamp"`
+	}
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return err
+	}
+
+	if ts.Extended != nil {
+		*t = Timestamp{T: ts.Extended.T, I: ts.Extended.I}
+	} else {
+		*t = Timestamp{T: ts.T, I: ts.I}
+	}
+	return nil
+}
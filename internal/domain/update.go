@@ -0,0 +1,291 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generateUpdateSQL translates the diff of an update into an UPDATE of the row and the
+// statements applying the changes of its embedded objects and arrays to their sub tables.
+func (p *OplogParser) generateUpdateSQL(
+	entry OplogEntry,
+	mapping entryMapping,
+	cache Cache,
+) ([]string, error) {
+	diffMap, ok := entry.Object["diff"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid diff oplog")
+	}
+
+	// a diff setting a field named as a column flattened from another path is rejected before
+	// any of its statements is generated
+	root := table{namespace: entry.Namespace, collection: entry.Namespace}
+	if err := p.checkDiff(root, nil, mapping.Depth(), diffMap, mapping, make(map[string]map[string][]string)); err != nil {
+		return nil, err
+	}
+	setCols, subStatements := p.diffSQL(root, entry.Object2["_id"], nil, mapping.Depth(), diffMap, mapping, cache)
+
+	if len(setCols) == 0 && len(subStatements) == 0 {
+		return nil, fmt.Errorf("invalid operation in diff oplog")
+	}
+
+	// the audit columns of the row are set even when the diff only changes its sub tables
+	sqlStatements := []string{}
+	if setCols = append(setCols, mapping.auditAssignments()...); len(setCols) > 0 {
+		sqlStatements = append(sqlStatements, fmt.Sprintf(
+			"UPDATE %s SET %s %s;",
+			entry.Namespace,
+			strings.Join(setCols, ", "),
+			generateWhereClause(entry.Object2),
+		))
+	}
+	return append(sqlStatements, subStatements...), nil
+}
+
+// diffSQL returns the sorted column assignments applying the diff of the embedded object at the
+// path to the row of tbl identified by id, whose embedded objects are flattened up to depth levels
+// below the path, and the statements applying the changes of its sub tables.
+func (p *OplogParser) diffSQL(
+	tbl table,
+	id interface{},
+	path []string,
+	depth int,
+	diffMap map[string]interface{},
+	mapping entryMapping,
+	cache Cache,
+) ([]string, []string) {
+	prefix := columnPrefix(path)
+	setCols := []string{}
+	sqlStatements := []string{}
+
+	// unset fields, removing the rows of their sub tables, and of the fields flattened from
+	// them when they are embedded objects
+	if unsetMap, ok := diffMap["d"].(map[string]interface{}); ok {
+		for _, field := range sortColumns(unsetMap) {
+			if depth > 0 && len(cache.Columns(tbl.namespace)) > 0 {
+				flatCols, flatStatements := p.flattenedSQL(tbl, id, fieldPath(path, field), nil, cache)
+				setCols = append(setCols, flatCols...)
+				sqlStatements = append(sqlStatements, flatStatements...)
+				continue
+			}
+
+			subTable, foreignKey, ok := p.manifest.find(tbl, prefix+field)
+			if !ok {
+				// a column unknown to the cache is only assumed when no column of the
+				// table is known, as after a restart
+				if _, known := cache.ColumnType(tbl.namespace, prefix+field); known || len(cache.Columns(tbl.namespace)) == 0 {
+					setCols = append(setCols, fmt.Sprintf("%s%s = NULL", prefix, field))
+				}
+			} else {
+				where := fmt.Sprintf("%s = %s", foreignKey, getColumnValue(id))
+				sqlStatements = append(sqlStatements, p.deleteRowsSQL(subTable.namespace, cache, where)...)
+			}
+		}
+	}
+
+	// set fields, replacing the rows of their sub tables
+	for _, key := range []string{"u", "i"} {
+		setMap, ok := diffMap[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		flatMap := p.manifest.flatten(tbl, path, setMap, depth)
+
+		// a field replaces every field flattened from the previous embedded object, which a
+		// scalar or an array replaces as well
+		if depth > 0 || len(path) > 0 {
+			set := make(map[string]bool, len(flatMap))
+			for columnName := range flatMap {
+				set[prefix+columnName] = true
+			}
+			for _, field := range sortColumns(setMap) {
+				flatCols, flatStatements := p.flattenedSQL(tbl, id, fieldPath(path, field), set, cache)
+				setCols = append(setCols, flatCols...)
+				sqlStatements = append(sqlStatements, flatStatements...)
+			}
+		}
+
+		for _, columnName := range sortColumns(flatMap) {
+			value := flatMap[columnName]
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+			default:
+				setCols = append(setCols, fmt.Sprintf("%s%s = %s", prefix, columnName, getColumnValue(value)))
+				continue
+			}
+
+			subTable, foreignKey := p.manifest.child(tbl, prefix+columnName, tableKind(valueKind(value)))
+			where := fmt.Sprintf("%s = %s", foreignKey, getColumnValue(id))
+			sqlStatements = append(sqlStatements, p.deleteRowsSQL(subTable.namespace, cache, where)...)
+			sqlStatements = append(sqlStatements, p.generateSQLForNestedObject(
+				subTable,
+				cache,
+				mapping,
+				&Column{Name: foreignKey, Value: id},
+				value,
+			)...)
+		}
+	}
+
+	// sub-diffs of embedded objects and arrays
+	for _, key := range sortColumns(diffMap) {
+		subDiff, ok := diffMap[key].(map[string]interface{})
+		if !ok || !strings.HasPrefix(key, "s") {
+			continue
+		}
+		field := prefix + key[1:]
+
+		if isArray, _ := subDiff["a"].(bool); isArray {
+			subTable, foreignKey := p.manifest.child(tbl, field, TABLE_ARRAY)
+			sqlStatements = append(sqlStatements, p.arrayDiffSQL(subTable, foreignKey, id, subDiff, mapping, cache)...)
+			continue
+		}
+
+		if depth > 0 && p.manifest.flattened(tbl, field) {
+			subCols, subStatements := p.diffSQL(tbl, id, fieldPath(path, key[1:]), depth-1, subDiff, mapping, cache)
+			setCols = append(setCols, subCols...)
+			sqlStatements = append(sqlStatements, subStatements...)
+			continue
+		}
+
+		subTable, foreignKey := p.manifest.child(tbl, field, TABLE_OBJECT)
+		if !cache.Get(subTable.namespace) {
+			continue
+		}
+		where := fmt.Sprintf("%s = %s", foreignKey, getColumnValue(id))
+		subID := sqlExpression(fmt.Sprintf("(SELECT _id FROM %s WHERE %s)", subTable.namespace, where))
+		subCols, subStatements := p.diffSQL(subTable, subID, nil, mapping.Depth(), subDiff, mapping, cache)
+		if len(subCols) > 0 {
+			subCols = append(subCols, mapping.auditAssignments()...)
+			sqlStatements = append(sqlStatements, fmt.Sprintf(
+				"UPDATE %s SET %s WHERE %s;", subTable.namespace, strings.Join(subCols, ", "), where))
+		}
+		sqlStatements = append(sqlStatements, subStatements...)
+	}
+
+	sort.Strings(setCols)
+	return setCols, sqlStatements
+}
+
+// fieldPath returns the path of the field of the embedded object at the path.
+func fieldPath(path []string, field string) []string {
+	return append(append([]string(nil), path...), field)
+}
+
+// flattenedSQL returns the assignments nulling the known columns of the row of tbl identified by
+// id which hold the field at the path or are flattened from it, and the statements deleting the
+// rows of the sub tables of these fields, except the columns and fields in set. The columns are
+// matched on the path recorded when they were flattened.
+func (p *OplogParser) flattenedSQL(
+	tbl table,
+	id interface{},
+	field []string,
+	set map[string]bool,
+	cache Cache,
+) ([]string, []string) {
+	underField := func(name string) bool {
+		return !set[name] && underPath(p.manifest.fieldPath(tbl, name), field)
+	}
+
+	setCols := []string{}
+	for _, columnName := range cache.Columns(tbl.namespace) {
+		if underField(columnName) {
+			setCols = append(setCols, fmt.Sprintf("%s = NULL", columnName))
+		}
+	}
+
+	sqlStatements := []string{}
+	for _, child := range p.manifest.children(tbl.namespace) {
+		if !underField(child.Path[len(child.Path)-1]) {
+			continue
+		}
+		where := fmt.Sprintf("%s = %s", child.ForeignKey, getColumnValue(id))
+		sqlStatements = append(sqlStatements, p.deleteRowsSQL(child.Table, cache, where)...)
+	}
+	return setCols, sqlStatements
+}
+
+// deleteRowsSQL deletes the rows of the table matching where along with the rows of its
+// descendant tables belonging to them, deepest first, once the table exists.
+func (p *OplogParser) deleteRowsSQL(namespace string, cache Cache, where string) []string {
+	if !cache.Get(namespace) {
+		return []string{}
+	}
+	sqlStatements := p.deleteDescendantsSQL(
+		namespace,
+		cache,
+		fmt.Sprintf("IN (SELECT _id FROM %s WHERE %s)", namespace, where),
+		"",
+	)
+	return append(sqlStatements, fmt.Sprintf("DELETE FROM %s WHERE %s;", namespace, where))
+}
+
+// arrayDiffSQL applies the diff of an array to the rows of its sub table belonging to the parent
+// identified by id: the array is truncated to its new length "l" first, then the elements
+// replaced ("u<index>") or diffed ("s<index>") in index order, replaced elements past the end
+// being appended.
+func (p *OplogParser) arrayDiffSQL(
+	tbl table,
+	foreignKey string,
+	id interface{},
+	diffMap map[string]interface{},
+	mapping entryMapping,
+	cache Cache,
+) []string {
+	sqlStatements := []string{}
+	where := fmt.Sprintf("%s = %s", foreignKey, getColumnValue(id))
+
+	if length, ok := diffMap["l"]; ok {
+		truncated := fmt.Sprintf("%s AND %s >= %s", where, ORDINAL_COLUMN, getColumnValue(length))
+		sqlStatements = append(sqlStatements, p.deleteRowsSQL(tbl.namespace, cache, truncated)...)
+	}
+
+	indexes := make([]int, 0, len(diffMap))
+	for key := range diffMap {
+		if len(key) < 2 || (key[0] != 'u' && key[0] != 's') {
+			continue
+		}
+		if index, err := strconv.Atoi(key[1:]); err == nil && index >= 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	seen := make(map[int]bool)
+	for _, index := range indexes {
+		if seen[index] {
+			continue
+		}
+		seen[index] = true
+		elementWhere := fmt.Sprintf("%s AND %s = %d", where, ORDINAL_COLUMN, index)
+
+		if element, ok := diffMap["u"+strconv.Itoa(index)]; ok {
+			sqlStatements = append(sqlStatements, p.deleteRowsSQL(tbl.namespace, cache, elementWhere)...)
+			sqlStatements = append(sqlStatements, p.generateElementSQL(
+				tbl,
+				cache,
+				mapping,
+				&Column{Name: foreignKey, Value: id},
+				index,
+				element,
+			)...)
+		}
+
+		subDiff, ok := diffMap["s"+strconv.Itoa(index)].(map[string]interface{})
+		if !ok || !cache.Get(tbl.namespace) {
+			continue
+		}
+		elementID := sqlExpression(fmt.Sprintf("(SELECT _id FROM %s WHERE %s)", tbl.namespace, elementWhere))
+		subCols, subStatements := p.diffSQL(tbl, elementID, nil, mapping.Depth(), subDiff, mapping, cache)
+		if len(subCols) > 0 {
+			subCols = append(subCols, mapping.auditAssignments()...)
+			sqlStatements = append(sqlStatements, fmt.Sprintf(
+				"UPDATE %s SET %s WHERE %s;", tbl.namespace, strings.Join(subCols, ", "), elementWhere))
+		}
+		sqlStatements = append(sqlStatements, subStatements...)
+	}
+
+	return sqlStatements
+}
@@ -0,0 +1,174 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+)
+
+// StuckThreshold is how long the pipeline may go without a commit while oplogs are being
+// read before it is reported as stuck.
+var StuckThreshold = 5 * time.Minute
+
+// ReaderStatus is the progress of the oplog reader.
+type ReaderStatus struct {
+	Connected bool             `json:"connected"`
+	LastRead  time.Time        `json:"last_read"`
+	LastTS    domain.Timestamp `json:"last_ts"`
+}
+
+// WriterStatus is the progress of the writer of a database.
+type WriterStatus struct {
+	Connected         bool             `json:"connected"`
+	LastCommit        time.Time        `json:"last_commit"`
+	LastTS            domain.Timestamp `json:"last_ts"`
+	StatementsApplied int64            `json:"statements_applied"`
+	// SchemaVersion is the number of DDL statements applied to the database.
+	SchemaVersion int64 `json:"schema_version"`
+}
+
+// Status is the progress of the whole pipeline.
+type Status struct {
+	Started time.Time               `json:"started"`
+	Stuck   bool                    `json:"stuck"`
+	Reader  ReaderStatus            `json:"reader"`
+	Writers map[string]WriterStatus `json:"writers"`
+}
+
+var (
+	mu      sync.RWMutex
+	started = time.Now()
+	reader  ReaderStatus
+	writers = make(map[string]WriterStatus)
+)
+
+// SetReaderConnected records whether the reader holds an open oplog cursor or file.
+func SetReaderConnected(connected bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	reader.Connected = connected
+}
+
+// OplogRead records that the oplog at ts has been read.
+func OplogRead(ts domain.Timestamp) {
+	mu.Lock()
+	defer mu.Unlock()
+	reader.LastRead = time.Now()
+	reader.LastTS = ts
+}
+
+// SetWriterConnected records whether the writer of the database can reach it.
+func SetWriterConnected(name string, connected bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	writer := writers[name]
+	writer.Connected = connected
+	writers[name] = writer
+}
+
+// Committed records a batch of statements, ddl of them DDL, committed by the writer up to the oplog at ts.
+func Committed(name string, ts domain.Timestamp, statements, ddl int) {
+	mu.Lock()
+	defer mu.Unlock()
+	writer := writers[name]
+	writer.Connected = true
+	writer.LastCommit = time.Now()
+	if !ts.IsZero() {
+		writer.LastTS = ts
+	}
+	writer.StatementsApplied += int64(statements)
+	writer.SchemaVersion += int64(ddl)
+	writers[name] = writer
+}
+
+// Recorder reports the progress of the writers as the status of this package.
+type Recorder struct{}
+
+func (Recorder) WriterConnected(db string, connected bool) {
+	SetWriterConnected(db, connected)
+}
+
+func (Recorder) Committed(db string, ts domain.Timestamp, statements, ddl int) {
+	Committed(db, ts, statements, ddl)
+}
+
+// GetStatus returns a snapshot of the pipeline progress.
+func GetStatus() Status {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	status := Status{
+		Started: started,
+		Reader:  reader,
+		Writers: make(map[string]WriterStatus, len(writers)),
+	}
+
+	lastCommit := started
+	for name, writer := range writers {
+		status.Writers[name] = writer
+		if writer.LastCommit.After(lastCommit) {
+			lastCommit = writer.LastCommit
+		}
+	}
+	status.Stuck = reader.LastRead.After(lastCommit) && time.Since(lastCommit) > StuckThreshold
+
+	return status
+}
+
+// Ready reports whether the reader and every writer are connected.
+func (s Status) Ready() bool {
+	if !s.Reader.Connected {
+		return false
+	}
+	for _, writer := range s.Writers {
+		if !writer.Connected {
+			return false
+		}
+	}
+	return true
+}
+
+// Register adds the /healthz, /readyz and /status endpoints to the mux.
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if GetStatus().Stuck {
+			http.Error(w, "pipeline is stuck", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := GetStatus()
+		if !status.Ready() {
+			http.Error(w, "not ready: "+notReady(status), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetStatus())
+	})
+}
+
+func notReady(status Status) string {
+	if !status.Reader.Connected {
+		return "reader disconnected"
+	}
+
+	names := make([]string, 0)
+	for name, writer := range status.Writers {
+		if !writer.Connected {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return "writers disconnected " + strings.Join(names, ", ")
+}
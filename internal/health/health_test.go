@@ -0,0 +1,131 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+)
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup records the progress of the pipeline
+		setup       func()
+		wantHealthz int
+		wantReadyz  int
+		wantBody    string
+	}{
+		{
+			name: "Connected pipeline",
+			setup: func() {
+				SetReaderConnected(true)
+				SetWriterConnected("test", true)
+				OplogRead(domain.Timestamp{T: 1})
+				Committed("test", domain.Timestamp{T: 1}, 3, 1)
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusOK,
+			wantBody:    "ok",
+		},
+		{
+			name: "Disconnected reader",
+			setup: func() {
+				SetWriterConnected("test", true)
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusServiceUnavailable,
+			wantBody:    "not ready: reader disconnected\n",
+		},
+		{
+			name: "Disconnected writers",
+			setup: func() {
+				SetReaderConnected(true)
+				SetWriterConnected("b", false)
+				SetWriterConnected("a", true)
+				Committed("c", domain.Timestamp{}, 1, 0)
+				SetWriterConnected("c", false)
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusServiceUnavailable,
+			wantBody:    "not ready: writers disconnected b, c\n",
+		},
+		{
+			name: "Stuck pipeline",
+			setup: func() {
+				SetReaderConnected(true)
+				started = time.Now().Add(-2 * StuckThreshold)
+				OplogRead(domain.Timestamp{T: 1})
+			},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusOK,
+			wantBody:    "ok",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reset()
+			defer reset()
+			test.setup()
+
+			mux := http.NewServeMux()
+			Register(mux)
+
+			if code, _ := get(mux, "/healthz"); code != test.wantHealthz {
+				t.Errorf("/healthz status does not match the expected result.\nWant: %d\nGot: %d", test.wantHealthz, code)
+			}
+			code, body := get(mux, "/readyz")
+			if code != test.wantReadyz || body != test.wantBody {
+				t.Errorf("/readyz does not match the expected result.\nWant: %d %q\nGot: %d %q", test.wantReadyz, test.wantBody, code, body)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	reset()
+	defer reset()
+
+	SetReaderConnected(true)
+	OplogRead(domain.Timestamp{T: 2, I: 1})
+	Committed("test", domain.Timestamp{T: 1, I: 1}, 3, 1)
+	Committed("test", domain.Timestamp{}, 2, 0)
+
+	mux := http.NewServeMux()
+	Register(mux)
+	code, body := get(mux, "/status")
+	if code != http.StatusOK {
+		t.Fatalf("/status failed with %d: %s", code, body)
+	}
+
+	var got Status
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	writer := got.Writers["test"]
+	want := WriterStatus{Connected: true, LastTS: domain.Timestamp{T: 1, I: 1}, StatementsApplied: 5, SchemaVersion: 1}
+	writer.LastCommit = time.Time{}
+	if got.Stuck || !got.Reader.Connected || got.Reader.LastTS != (domain.Timestamp{T: 2, I: 1}) || writer != want {
+		t.Errorf("Status does not match the expected result.\nWant: %+v\nGot: %s", want, body)
+	}
+}
+
+func reset() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	started = time.Now()
+	reader = ReaderStatus{}
+	writers = make(map[string]WriterStatus)
+}
+
+func get(handler http.Handler, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code, recorder.Body.String()
+}
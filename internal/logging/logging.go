@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+const (
+	FORMAT_JSON string = "json"
+	FORMAT_TEXT string = "text"
+)
+
+// NewLogger creates a new structured logger writing to stderr as configured.
+func NewLogger(cfg config.LogConfig) *slog.Logger {
+	return newLogger(os.Stderr, cfg)
+}
+
+// NewNopLogger creates a new logger which discards every record.
+func NewNopLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func newLogger(w io.Writer, cfg config.LogConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLevel(cfg.Level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(cfg.Format, FORMAT_JSON) {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	if cfg.DebugSampleRate > 1 {
+		handler = &samplingHandler{
+			Handler: handler,
+			rate:    uint64(cfg.DebugSampleRate),
+			counter: new(atomic.Uint64),
+		}
+	}
+	return slog.New(handler)
+}
+
+func parseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// samplingHandler passes one out of every rate debug records, such as the per-statement
+// records, to the wrapped handler. Records of other levels are never dropped.
+type samplingHandler struct {
+	slog.Handler
+	rate    uint64
+	counter *atomic.Uint64
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level <= slog.LevelDebug && (h.counter.Add(1)-1)%h.rate != 0 {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), rate: h.rate, counter: h.counter}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), rate: h.rate, counter: h.counter}
+}
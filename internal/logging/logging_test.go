@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.LogConfig
+		// log writes the records through the logger
+		log  func(logger *slog.Logger)
+		want []string
+	}{
+		{
+			name: "Records below the level are dropped",
+			cfg:  config.LogConfig{Level: "warn", Format: FORMAT_TEXT},
+			log: func(logger *slog.Logger) {
+				logger.Debug("debug")
+				logger.Info("info")
+				logger.Warn("warn")
+				logger.Error("error")
+			},
+			want: []string{"level=WARN msg=warn", "level=ERROR msg=error"},
+		},
+		{
+			name: "Unknown level is info",
+			cfg:  config.LogConfig{Level: "verbose", Format: FORMAT_TEXT},
+			log: func(logger *slog.Logger) {
+				logger.Debug("debug")
+				logger.Info("info")
+			},
+			want: []string{"level=INFO msg=info"},
+		},
+		{
+			name: "JSON records with context fields",
+			cfg:  config.LogConfig{Level: "info", Format: "JSON"},
+			log: func(logger *slog.Logger) {
+				logger.With("db", "test").Info("oplog processed", "collection", "student", "op", "i")
+			},
+			want: []string{`"level":"INFO","msg":"oplog processed","db":"test","collection":"student","op":"i"}`},
+		},
+		{
+			name: "Debug records are sampled",
+			cfg:  config.LogConfig{Level: "debug", Format: FORMAT_TEXT, DebugSampleRate: 2},
+			log: func(logger *slog.Logger) {
+				statements := logger.With("db", "test")
+				for _, msg := range []string{"s1", "s2", "s3"} {
+					statements.Debug(msg)
+				}
+				logger.Debug("s4")
+				logger.Info("info")
+			},
+			want: []string{"level=DEBUG msg=s1 db=test", "level=DEBUG msg=s3 db=test", "level=INFO msg=info"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			test.log(newLogger(&buf, test.cfg))
+
+			got := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				if line == "" {
+					continue
+				}
+				// strip the time of the record
+				i := strings.Index(line, "level")
+				if i > 0 && line[i-1] == '"' {
+					i--
+				}
+				got = append(got, line[i:])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Logged records do not match the expected result.\nWant: %q\nGot: %q", test.want, got)
+			}
+		})
+	}
+}
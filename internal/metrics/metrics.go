@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace string = "oplog2sql"
+
+var (
+	// OplogsRead counts the oplog entries read per namespace and operation.
+	OplogsRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "oplogs_read_total",
+		Help:      "Number of oplog entries read.",
+	}, []string{"ns", "op"})
+
+	// StatementsGenerated counts the SQL statements generated per database.
+	StatementsGenerated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "statements_generated_total",
+		Help:      "Number of SQL statements generated.",
+	}, []string{"db"})
+
+	// StatementsApplied counts the SQL statements committed per database.
+	StatementsApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "statements_applied_total",
+		Help:      "Number of SQL statements committed to the target database.",
+	}, []string{"db"})
+
+	// ApplyErrors counts the errors while executing or committing SQL statements per database.
+	ApplyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "apply_errors_total",
+		Help:      "Number of errors while applying SQL statements, including retried ones.",
+	}, []string{"db"})
+
+	// BatchCommitDuration observes the latency of the batch commits per database.
+	BatchCommitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_commit_duration_seconds",
+		Help:      "Latency of batch commits.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"db"})
+
+	// OplogBufferDepth is the number of oplog entries waiting in the OplogPublisher.
+	OplogBufferDepth = newDepthGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "oplog_buffer_depth",
+		Help:      "Number of oplog entries buffered in the publisher.",
+	}, nil)
+
+	// SpoolBytes is the size of the segments of the disk-backed OplogPublisher.
+	SpoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spool_bytes",
+		Help:      "Size in bytes of the oplog entries spooled on disk.",
+	})
+
+	// StatementBufferDepth is the number of SQL commands waiting in the SQLStatement per database.
+	StatementBufferDepth = newDepthGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "statement_buffer_depth",
+		Help:      "Number of SQL commands buffered for the writer.",
+	}, []string{"db"})
+
+	// ReadLag is the age of the last oplog entry read.
+	ReadLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "read_lag_seconds",
+		Help:      "Seconds between the oplog ts of the last entry read and the clock.",
+	})
+
+	// ReplicationLag is the age of the last oplog entry committed per database.
+	ReplicationLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replication_lag_seconds",
+		Help:      "Seconds between the oplog ts of the last entry committed and the clock.",
+	}, []string{"db"})
+)
+
+// Lag returns the seconds elapsed since the oplog ts given in seconds since the epoch.
+func Lag(tsSeconds uint32) float64 {
+	return time.Since(time.Unix(int64(tsSeconds), 0)).Seconds()
+}
+
+// DepthGauge is a gauge reading the depth of buffers whenever it is collected, so that it
+// reports the current depth whether the producer or the consumer of a buffer moved last.
+type DepthGauge struct {
+	desc *prometheus.Desc
+
+	mu     sync.Mutex
+	depths map[string]func() int
+}
+
+func newDepthGauge(opts prometheus.GaugeOpts, labels []string) *DepthGauge {
+	gauge := &DepthGauge{
+		desc:   prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, labels, nil),
+		depths: make(map[string]func() int),
+	}
+	prometheus.MustRegister(gauge)
+	return gauge
+}
+
+// Watch reads the depth of the buffer of the label, if any, when the gauge is collected.
+func (g *DepthGauge) Watch(label string, depth func() int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.depths[label] = depth
+}
+
+func (g *DepthGauge) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *DepthGauge) Collect(ch chan<- prometheus.Metric) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for label, depth := range g.depths {
+		labelValues := []string{}
+		if label != "" {
+			labelValues = append(labelValues, label)
+		}
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, float64(depth()), labelValues...)
+	}
+}
+
+// Recorder exports the measurements of the domain as the metrics of this package.
+type Recorder struct{}
+
+func (Recorder) StatementsGenerated(db string, count int) {
+	StatementsGenerated.WithLabelValues(db).Add(float64(count))
+}
+
+func (Recorder) SpoolBytes(bytes int64) {
+	SpoolBytes.Set(float64(bytes))
+}
+
+func (Recorder) OplogBuffer(depth func() int) {
+	OplogBufferDepth.Watch("", depth)
+}
+
+func (Recorder) StatementBuffer(db string, depth func() int) {
+	StatementBufferDepth.Watch(db, depth)
+}
+
+func (Recorder) ApplyError(db string) {
+	ApplyErrors.WithLabelValues(db).Inc()
+}
+
+func (Recorder) BatchCommitted(db string, statements int, duration time.Duration, ts domain.Timestamp) {
+	BatchCommitDuration.WithLabelValues(db).Observe(duration.Seconds())
+	StatementsApplied.WithLabelValues(db).Add(float64(statements))
+	if !ts.IsZero() {
+		ReplicationLag.WithLabelValues(db).Set(Lag(ts.T))
+	}
+}
+
+// Register adds the /metrics endpoint to the mux.
+func Register(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
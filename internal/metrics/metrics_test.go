@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDepthGaugeReadsCurrentDepth(t *testing.T) {
+	gauge := newDepthGauge(prometheus.GaugeOpts{Namespace: namespace, Name: "test_buffer_depth"}, []string{"db"})
+	defer prometheus.Unregister(gauge)
+
+	buffer := make(chan int, 10)
+	gauge.Watch("test", func() int { return len(buffer) })
+
+	tests := []struct {
+		name      string
+		operation func()
+		want      float64
+	}{
+		{
+			name:      "Produced",
+			operation: func() { buffer <- 1; buffer <- 2; buffer <- 3 },
+			want:      3,
+		},
+		{
+			name:      "Consumed",
+			operation: func() { <-buffer; <-buffer },
+			want:      1,
+		},
+		{
+			name:      "Drained",
+			operation: func() { <-buffer },
+			want:      0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.operation()
+			if got := testutil.ToFloat64(gauge); got != test.want {
+				t.Errorf("Depth does not match the expected result.\nWant: %v\nGot: %v", test.want, got)
+			}
+		})
+	}
+}
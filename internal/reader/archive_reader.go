@@ -0,0 +1,172 @@
+package reader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// archiveMagicNumber starts every mongodump archive.
+const archiveMagicNumber uint32 = 0x8199e26d
+
+// archiveNamespaceHeader starts every block of an archive. The documents of the namespace
+// follow it up to the terminator, and the last block of a namespace has EOF set and no document.
+type archiveNamespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// isOplog reports whether the block holds oplog entries rather than documents of a collection.
+func (h archiveNamespaceHeader) isOplog() bool {
+	return (h.Database == "" && h.Collection == "oplog") ||
+		(h.Database == "local" && strings.HasPrefix(h.Collection, "oplog."))
+}
+
+// ArchiveReader implements the OplogReader interface for reading a mongodump --archive stream,
+// gzipped or not. The documents of the dumped collections are published as insert Oplog
+// entries and the entries of the oplog dumped with --oplog are published as upserts, which
+// matches what mongorestore --oplogReplay restores.
+type ArchiveReader struct {
+	FilePath string
+
+	filter *domain.NamespaceFilter
+	logger *slog.Logger
+}
+
+// NewArchiveReader creates a new instance of ArchiveReader, which skips the namespaces not selected by the filter.
+func NewArchiveReader(filePath string, filter *domain.NamespaceFilter, logger *slog.Logger) OplogReader {
+	return &ArchiveReader{
+		FilePath: filePath,
+		filter:   filter,
+		logger:   logger.With("file", filePath),
+	}
+}
+
+// ReadOplogs reads the blocks of the archive and publish their entries in the publisher.
+func (ar *ArchiveReader) ReadOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
+	defer publisher.Stop()
+
+	archiveFile, err := openFile(ctx, ar.FilePath, 0)
+	if err != nil {
+		ar.logger.Error("file could not be opened", "error", err)
+		return err
+	}
+	defer archiveFile.Close()
+	health.SetReaderConnected(true)
+
+	if err := readArchivePrelude(archiveFile); err != nil {
+		ar.logger.Error("invalid archive", "error", err)
+		return err
+	}
+
+	for {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			// The context is done, stop reading Oplogs
+			return nil
+		default:
+			// Context is still active, continue reading Oplogs
+		}
+
+		raw, err := readBSON(archiveFile)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			ar.logger.Error("invalid archive block", "error", err)
+			return err
+		}
+
+		var header archiveNamespaceHeader
+		if err := bson.Unmarshal(raw, &header); err != nil {
+			ar.logger.Error("invalid archive block header", "error", err)
+			return err
+		}
+
+		if err := ar.readBlock(ctx, archiveFile, header, publisher); err != nil {
+			ar.logger.Error("invalid archive block", "db", header.Database, "collection", header.Collection, "error", err)
+			return err
+		}
+	}
+}
+
+// readArchivePrelude checks the magic number and skips the header and the collection metadata.
+func readArchivePrelude(r io.Reader) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(magic[:]) != archiveMagicNumber {
+		return fmt.Errorf("not a mongodump archive")
+	}
+
+	for {
+		_, err := readBSON(r)
+		if errors.Is(err, errTerminator) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ar *ArchiveReader) readBlock(
+	ctx context.Context,
+	r io.Reader,
+	header archiveNamespaceHeader,
+	publisher domain.OplogPublisher,
+) error {
+	namespace := header.Database + domain.SEPERATOR + header.Collection
+	// system collections such as system.views have no SQL equivalent
+	skip := !header.isOplog() &&
+		(strings.HasPrefix(header.Collection, "system.") || !ar.filter.Match(namespace))
+
+	for {
+		raw, err := readBSON(r)
+		if errors.Is(err, errTerminator) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if skip || ctx.Err() != nil {
+			continue
+		}
+
+		entry := domain.OplogEntry{Operation: "i", Namespace: namespace}
+		if header.isOplog() {
+			entry, err = decodeOplogEntry(raw)
+			// the oplog covers the time the collections were dumped, whose documents may
+			// already hold its changes
+			entry.Upsert = true
+		} else {
+			entry.Object, err = decodeBSONDocument(raw)
+		}
+		if err != nil {
+			return err
+		}
+		// mongodump records no-op and command entries, which have no SQL equivalent
+		if !isDataOperation(entry.Operation) || !ar.filter.Match(entry.Namespace) {
+			continue
+		}
+
+		metrics.OplogsRead.WithLabelValues(entry.Namespace, entry.Operation).Inc()
+		health.OplogRead(entry.Timestamp)
+		if err := publisher.PublishOplog(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package reader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// maxBSONSize bounds the size of a document, which is at most 16MiB plus the oplog overhead.
+	maxBSONSize = 48 * 1024 * 1024
+	minBSONSize = 5
+)
+
+// errTerminator is returned by readBSON when it reads the terminator of an archive block.
+var errTerminator = errors.New("archive block terminator")
+
+// bsonRegistry decodes embedded documents and arrays into the plain map and slice types
+// handled by the parser, while the other BSON types keep their primitive types.
+var bsonRegistry = func() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(map[string]interface{}{}))
+	registry.RegisterTypeMapEntry(bsontype.Array, reflect.TypeOf([]interface{}{}))
+	return registry
+}()
+
+// bsonOplogEntry is the BSON form of an oplog entry.
+type bsonOplogEntry struct {
+	Operation string                 `bson:"op"`
+	Namespace string                 `bson:"ns"`
+	Object    map[string]interface{} `bson:"o"`
+	Object2   map[string]interface{} `bson:"o2"`
+	Timestamp primitive.Timestamp    `bson:"ts"`
+	Wall      time.Time              `bson:"wall"`
+	TxnNumber *int64                 `bson:"txnNumber"`
+}
+
+// decodeOplogEntry decodes an oplog entry, keeping the BSON types of the document fields.
+func decodeOplogEntry(raw bson.Raw) (domain.OplogEntry, error) {
+	var entry bsonOplogEntry
+	if err := bson.UnmarshalWithRegistry(bsonRegistry, raw, &entry); err != nil {
+		return domain.OplogEntry{}, err
+	}
+	return domain.OplogEntry{
+		Operation: entry.Operation,
+		Namespace: entry.Namespace,
+		Object:    entry.Object,
+		Object2:   entry.Object2,
+		Timestamp: domain.Timestamp{T: entry.Timestamp.T, I: entry.Timestamp.I},
+		Wall:      entry.Wall,
+		TxnNumber: entry.TxnNumber,
+	}, nil
+}
+
+// decodeBSONDocument decodes a document, keeping the BSON types of its fields.
+func decodeBSONDocument(raw bson.Raw) (map[string]interface{}, error) {
+	var document map[string]interface{}
+	err := bson.UnmarshalWithRegistry(bsonRegistry, raw, &document)
+	return document, err
+}
+
+// readBSON reads the next document of a stream of concatenated BSON documents. It returns
+// io.EOF at the end of the stream, and errTerminator when it reads an archive block terminator.
+func readBSON(r io.Reader) (bson.Raw, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated document size: %w", err)
+		}
+		return nil, err
+	}
+
+	size := int32(binary.LittleEndian.Uint32(header[:]))
+	if size == -1 {
+		return nil, errTerminator
+	}
+	if size < minBSONSize || size > maxBSONSize {
+		return nil, fmt.Errorf("invalid document size %d", size)
+	}
+
+	doc := make([]byte, size)
+	copy(doc, header[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		return nil, fmt.Errorf("truncated document: %w", err)
+	}
+
+	raw := bson.Raw(doc)
+	if err := raw.Validate(); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
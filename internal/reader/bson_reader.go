@@ -0,0 +1,102 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+)
+
+// BSONReader implements the OplogReader interface for reading Oplog entries from concatenated
+// BSON documents, such as the oplog.bson written by mongodump --oplog, gzipped or not. The
+// entries are published as upserts, as they replay changes which the dump may already hold.
+type BSONReader struct {
+	FilePath string
+
+	bounds domain.Bounds
+	filter *domain.NamespaceFilter
+	logger *slog.Logger
+}
+
+// NewBSONReader creates a new instance of BSONReader, which skips the oplogs not selected by the
+// filter and stops at the first oplog past the bounds.
+func NewBSONReader(
+	filePath string,
+	bounds domain.Bounds,
+	filter *domain.NamespaceFilter,
+	logger *slog.Logger,
+) OplogReader {
+	return &BSONReader{
+		FilePath: filePath,
+		bounds:   bounds,
+		filter:   filter,
+		logger:   logger.With("file", filePath),
+	}
+}
+
+// ReadOplogs reads Oplog entries from the file and publish them in the publisher.
+func (br *BSONReader) ReadOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
+	defer publisher.Stop()
+
+	oplogFile, err := openFile(ctx, br.FilePath, 0)
+	if err != nil {
+		br.logger.Error("file could not be opened", "error", err)
+		return err
+	}
+	defer oplogFile.Close()
+	health.SetReaderConnected(true)
+
+	for i := 1; ; i++ {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			// The context is done, stop reading Oplogs
+			return nil
+		default:
+			// Context is still active, continue reading Oplogs
+		}
+
+		raw, err := readBSON(oplogFile)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err == nil {
+			var entry domain.OplogEntry
+			if entry, err = decodeOplogEntry(raw); err == nil {
+				if br.bounds.Past(entry) {
+					br.logger.Info("upper bound reached", "ts", entry.Timestamp.String())
+					return nil
+				}
+				err = br.publish(entry, publisher)
+			}
+		}
+		if err != nil {
+			br.logger.Error("invalid bson document", "entry", i, "error", err)
+			return err
+		}
+	}
+}
+
+// isDataOperation reports whether the operation is an insert, update or delete.
+func isDataOperation(operation string) bool {
+	return operation == "i" || operation == "u" || operation == "d"
+}
+
+func (br *BSONReader) publish(entry domain.OplogEntry, publisher domain.OplogPublisher) error {
+	// mongodump records no-op and command entries, which have no SQL equivalent
+	if !isDataOperation(entry.Operation) || br.bounds.Before(entry) || !br.filter.Match(entry.Namespace) {
+		return nil
+	}
+
+	// the oplog of mongodump covers the time the collections were dumped, whose documents may
+	// already hold its changes
+	entry.Upsert = true
+
+	metrics.OplogsRead.WithLabelValues(entry.Namespace, entry.Operation).Inc()
+	health.OplogRead(entry.Timestamp)
+	return publisher.PublishOplog(entry)
+}
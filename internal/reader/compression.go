@@ -0,0 +1,120 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// STDIN is the file path reading from the standard input.
+const STDIN = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressedFile is a file whose content is transparently decompressed.
+type compressedFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *compressedFile) Close() error {
+	var err error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if closeErr := f.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Open opens the file at filePath, or the standard input when it is STDIN, decompressing it
+// when it starts with the gzip or zstd magic number.
+func Open(filePath string) (io.ReadCloser, error) {
+	return openFile(context.Background(), filePath, 0)
+}
+
+// openFile opens the file like Open. When pollInterval is positive, the reader waits for the
+// file to grow at its end, polling every pollInterval, until the context is done.
+func openFile(ctx context.Context, filePath string, pollInterval time.Duration) (io.ReadCloser, error) {
+	if filePath == STDIN {
+		return decompress(io.NopCloser(os.Stdin))
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if pollInterval <= 0 {
+		return decompress(file)
+	}
+	return decompress(&followFile{File: file, ctx: ctx, pollInterval: pollInterval})
+}
+
+func decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(rc)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &compressedFile{Reader: gzipReader, closers: []io.Closer{rc, gzipReader}}, nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &compressedFile{Reader: zstdReader, closers: []io.Closer{rc, zstdReader.IOReadCloser()}}, nil
+
+	default:
+		return &compressedFile{Reader: buffered, closers: []io.Closer{rc}}, nil
+	}
+}
+
+// followFile reads a file as it grows, like tail -f. It returns io.EOF once the context is done.
+type followFile struct {
+	*os.File
+	ctx          context.Context
+	pollInterval time.Duration
+}
+
+func (f *followFile) Read(p []byte) (int, error) {
+	for {
+		n, err := f.File.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		// start over when the file has been truncated
+		if offset, err := f.Seek(0, io.SeekCurrent); err == nil {
+			if info, err := f.Stat(); err == nil && info.Size() < offset {
+				f.Seek(0, io.SeekStart)
+				continue
+			}
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(f.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// extendedJSONKeys are the keys of the Extended JSON v2 wrappers of BSON values, in
+// canonical and relaxed mode, along with the legacy $regex and $binary/$type forms.
+var extendedJSONKeys = map[string]bool{
+	"$oid":               true,
+	"$symbol":            true,
+	"$numberInt":         true,
+	"$numberLong":        true,
+	"$numberDouble":      true,
+	"$numberDecimal":     true,
+	"$binary":            true,
+	"$uuid":              true,
+	"$code":              true,
+	"$timestamp":         true,
+	"$regularExpression": true,
+	"$regex":             true,
+	"$dbPointer":         true,
+	"$date":              true,
+	"$minKey":            true,
+	"$maxKey":            true,
+	"$undefined":         true,
+}
+
+// decodeExtendedJSON replaces the Extended JSON wrappers in the documents of the entry, such
+// as {"$oid": "..."} or {"$date": "..."}, with the BSON values they stand for. Plain JSON
+// numbers are kept as float64.
+func decodeExtendedJSON(entry *domain.OplogEntry) error {
+	if err := decodeExtendedJSONDocument(entry.Object); err != nil {
+		return err
+	}
+	return decodeExtendedJSONDocument(entry.Object2)
+}
+
+// decodeWallTime decodes the wall clock time of an entry, which is missing from old oplogs.
+func decodeWallTime(value interface{}) (time.Time, error) {
+	decoded, err := decodeExtendedJSONValue(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("field wall: %w", err)
+	}
+
+	switch wall := decoded.(type) {
+	case nil:
+		return time.Time{}, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, wall)
+	case primitive.DateTime:
+		return wall.Time(), nil
+	default:
+		return time.Time{}, fmt.Errorf("field wall: invalid date %v", wall)
+	}
+}
+
+// decodeTxnNumber decodes the transaction number of an entry, which is missing outside of
+// retryable writes and transactions.
+func decodeTxnNumber(value interface{}) (*int64, error) {
+	decoded, err := decodeExtendedJSONValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("field txnNumber: %w", err)
+	}
+
+	var txnNumber int64
+	switch number := decoded.(type) {
+	case nil:
+		return nil, nil
+	case int32:
+		txnNumber = int64(number)
+	case int64:
+		txnNumber = number
+	case float64:
+		if number != math.Trunc(number) {
+			return nil, fmt.Errorf("field txnNumber: invalid number %v", number)
+		}
+		txnNumber = int64(number)
+	default:
+		return nil, fmt.Errorf("field txnNumber: invalid number %v", number)
+	}
+	return &txnNumber, nil
+}
+
+func decodeExtendedJSONDocument(document map[string]interface{}) error {
+	for key, value := range document {
+		decoded, err := decodeExtendedJSONValue(value)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", key, err)
+		}
+		document[key] = decoded
+	}
+	return nil
+}
+
+func decodeExtendedJSONValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if isExtendedJSONWrapper(v) {
+			return unmarshalExtendedJSON(v)
+		}
+		return v, decodeExtendedJSONDocument(v)
+
+	case []interface{}:
+		for i, item := range v {
+			decoded, err := decodeExtendedJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = decoded
+		}
+	}
+	return value, nil
+}
+
+func isExtendedJSONWrapper(document map[string]interface{}) bool {
+	for key := range document {
+		if extendedJSONKeys[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalExtendedJSON decodes a wrapper with the Extended JSON parser of the driver.
+func unmarshalExtendedJSON(wrapper map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(map[string]interface{}{"v": wrapper})
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Value interface{} `bson:"v"`
+	}
+	if err := bson.UnmarshalExtJSONWithRegistry(bsonRegistry, data, false, &document); err != nil {
+		return nil, err
+	}
+	return document.Value, nil
+}
@@ -0,0 +1,171 @@
+package reader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUnmarshalEntry(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("635b79e231d82a8ab1de863b")
+	decimal, _ := primitive.ParseDecimal128("1.25")
+	wall := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	txnNumber := int64(7)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    domain.OplogEntry
+		wantErr bool
+	}{
+		{
+			name: "Canonical Extended JSON",
+			data: `{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+					"_id": {"$oid": "635b79e231d82a8ab1de863b"},
+					"age": {"$numberInt": "21"},
+					"views": {"$numberLong": "9007199254740993"},
+					"score": {"$numberDouble": "1.5"},
+					"price": {"$numberDecimal": "1.25"},
+					"born": {"$date": {"$numberLong": "1704164645006"}},
+					"tags": [{"$numberInt": "1"}, "a"],
+					"address": {"geo": {"lat": {"$numberDouble": "-1.5"}}}
+				},
+				"ts": {"$timestamp": {"t": 1, "i": 2}},
+				"wall": {"$date": {"$numberLong": "1704164645006"}},
+				"txnNumber": {"$numberLong": "7"}
+			}`,
+			want: domain.OplogEntry{
+				Operation: "i",
+				Namespace: "test.student",
+				Object: map[string]interface{}{
+					"_id":     id,
+					"age":     int32(21),
+					"views":   int64(9007199254740993),
+					"score":   1.5,
+					"price":   decimal,
+					"born":    primitive.NewDateTimeFromTime(wall),
+					"tags":    []interface{}{int32(1), "a"},
+					"address": map[string]interface{}{"geo": map[string]interface{}{"lat": -1.5}},
+				},
+				Timestamp: domain.Timestamp{T: 1, I: 2},
+				Wall:      wall,
+				TxnNumber: &txnNumber,
+			},
+		},
+		{
+			name: "Relaxed Extended JSON",
+			data: `{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"u": {"born": {"$date": "2024-01-02T03:04:05.006Z"}, "age": 22}}},
+				"o2": {"_id": {"$oid": "635b79e231d82a8ab1de863b"}},
+				"ts": {"T": 1, "I": 2},
+				"wall": "2024-01-02T03:04:05.006Z",
+				"txnNumber": 7
+			}`,
+			want: domain.OplogEntry{
+				Operation: "u",
+				Namespace: "test.student",
+				Object: map[string]interface{}{
+					"$v":   2.0,
+					"diff": map[string]interface{}{"u": map[string]interface{}{"born": primitive.NewDateTimeFromTime(wall), "age": 22.0}},
+				},
+				Object2:   map[string]interface{}{"_id": id},
+				Timestamp: domain.Timestamp{T: 1, I: 2},
+				Wall:      wall,
+				TxnNumber: &txnNumber,
+			},
+		},
+		{
+			name:    "Invalid wrapper",
+			data:    `{"op": "i", "ns": "test.student", "o": {"_id": {"$oid": "not an id"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid wall time",
+			data:    `{"op": "i", "ns": "test.student", "o": {"_id": "s1"}, "wall": 12}`,
+			wantErr: true,
+		},
+		{
+			name:    "Fractional transaction number",
+			data:    `{"op": "i", "ns": "test.student", "o": {"_id": "s1"}, "txnNumber": 1.5}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got domain.OplogEntry
+			err := unmarshalEntry([]byte(test.data), &got)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("unmarshalEntry succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unmarshalEntry failed: %v", err)
+			}
+			// dates are decoded in the local time zone
+			got.Wall = got.Wall.UTC()
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Decoded oplog does not match the expected result.\nWant: %#v\nGot: %#v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeOplogEntry(t *testing.T) {
+	id := primitive.NewObjectID()
+	wall := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	txnNumber := int64(7)
+
+	raw, err := bson.Marshal(bson.D{
+		{Key: "op", Value: "i"},
+		{Key: "ns", Value: "test.student"},
+		{Key: "o", Value: bson.D{
+			{Key: "_id", Value: id},
+			{Key: "age", Value: int32(21)},
+			{Key: "tags", Value: bson.A{"a", bson.D{{Key: "n", Value: int64(1)}}}},
+			{Key: "address", Value: bson.D{{Key: "city", Value: "Springfield"}}},
+			{Key: "data", Value: primitive.Binary{Subtype: 4, Data: []byte{1, 2}}},
+		}},
+		{Key: "ts", Value: primitive.Timestamp{T: 1, I: 2}},
+		{Key: "wall", Value: primitive.NewDateTimeFromTime(wall)},
+		{Key: "txnNumber", Value: txnNumber},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodeOplogEntry(raw)
+	if err != nil {
+		t.Fatalf("decodeOplogEntry failed: %v", err)
+	}
+	want := domain.OplogEntry{
+		Operation: "i",
+		Namespace: "test.student",
+		Object: map[string]interface{}{
+			"_id":     id,
+			"age":     int32(21),
+			"tags":    []interface{}{"a", map[string]interface{}{"n": int64(1)}},
+			"address": map[string]interface{}{"city": "Springfield"},
+			"data":    primitive.Binary{Subtype: 4, Data: []byte{1, 2}},
+		},
+		Timestamp: domain.Timestamp{T: 1, I: 2},
+		Wall:      wall,
+		TxnNumber: &txnNumber,
+	}
+	// dates are decoded in the local time zone
+	got.Wall = got.Wall.UTC()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decoded oplog does not match the expected result.\nWant: %#v\nGot: %#v", want, got)
+	}
+}
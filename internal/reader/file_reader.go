@@ -2,44 +2,66 @@ package reader
 
 import (
 	"context"
-	"encoding/json"
-	"log"
-	"os"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
 
+	"github.com/one2nc/mongo-oplog-to-sql/config"
 	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
 )
 
-// FileReader implements the OplogReader interface for reading Oplog entries from a file.
+// FileReader implements the OplogReader interface for reading Oplog entries from a JSON array
+// or newline-delimited JSON file, or from the standard input when FilePath is STDIN.
+// Gzip and zstd compressed input is decompressed transparently.
 type FileReader struct {
 	FilePath string
+
+	inputConfig config.InputConfig
+	bounds      domain.Bounds
+	filter      *domain.NamespaceFilter
+	logger      *slog.Logger
 }
 
-// NewFileReader creates a new instance of FileReader.
-func NewFileReader(filePath string) OplogReader {
+// NewFileReader creates a new instance of FileReader, which skips the oplogs not selected by the
+// filter and stops at the first oplog past the bounds.
+func NewFileReader(
+	filePath string,
+	inputConfig config.InputConfig,
+	bounds domain.Bounds,
+	filter *domain.NamespaceFilter,
+	logger *slog.Logger,
+) OplogReader {
 	return &FileReader{
-		FilePath: filePath,
+		FilePath:    filePath,
+		inputConfig: inputConfig,
+		bounds:      bounds,
+		filter:      filter,
+		logger:      logger.With("file", filePath),
 	}
 }
 
-// ReadOplogs reads Oplog entries from the file and publish them in the publisher.
+// ReadOplogs reads Oplog entries from the file and publish them in the publisher. In follow
+// mode, it waits for new entries at the end of the file until the context is done.
 func (fr *FileReader) ReadOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
 	defer publisher.Stop()
 
-	oplogFile, err := os.Open(fr.FilePath)
+	var pollInterval time.Duration
+	if fr.inputConfig.Follow {
+		pollInterval = fr.inputConfig.PollInterval
+	}
+	oplogFile, err := openFile(ctx, fr.FilePath, pollInterval)
 	if err != nil {
-		log.Fatalf("file could not be opened : %s", err)
+		fr.logger.Error("file could not be opened", "error", err)
 		return err
 	}
 	defer oplogFile.Close()
+	health.SetReaderConnected(true)
 
-	decoder := json.NewDecoder(oplogFile)
-	if _, err := decoder.Token(); err != nil {
-		log.Fatal("invalid file")
-		return err
-	}
-
-	i := 1
-	for decoder.More() {
+	decoder := NewJSONDecoder(oplogFile)
+	for {
 		// Check if the context is done
 		select {
 		case <-ctx.Done():
@@ -49,17 +71,36 @@ func (fr *FileReader) ReadOplogs(ctx context.Context, publisher domain.OplogPubl
 			// Context is still active, continue reading Oplogs
 		}
 
-		var entry domain.OplogEntry
-		if err := decoder.Decode(&entry); err != nil {
-			log.Fatalf("invalid json field on line %d:%s", i, err)
-			return err
+		entry, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) && fr.inputConfig.SkipBadLines {
+			fr.logger.Warn("skipping invalid oplog entry", "offset", decodeErr.Offset, "error", decodeErr.Err)
+			continue
 		}
-		err := publisher.PublishOplog(entry)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fr.logger.Error("invalid oplog file", "offset", decoder.Offset(), "error", err)
 			return err
 		}
-		i++
-	}
 
-	return nil
+		if fr.bounds.Past(entry) {
+			fr.logger.Info("upper bound reached", "ts", entry.Timestamp.String())
+			return nil
+		}
+		if fr.bounds.Before(entry) || !fr.filter.Match(entry.Namespace) {
+			continue
+		}
+		metrics.OplogsRead.WithLabelValues(entry.Namespace, entry.Operation).Inc()
+		health.OplogRead(entry.Timestamp)
+
+		if err := publisher.PublishOplog(entry); err != nil {
+			return err
+		}
+	}
 }
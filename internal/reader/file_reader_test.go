@@ -0,0 +1,251 @@
+package reader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+)
+
+const (
+	testEntry1 = `{"op": "i", "ns": "test.student", "o": {"_id": "s1"}, "ts": {"T": 1, "I": 1}}`
+	testEntry2 = `{"op": "i", "ns": "test.student", "o": {"_id": "s2"}, "ts": {"T": 2, "I": 1}}`
+)
+
+func TestFileReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// compression is gzip, zstd or empty for plain input
+		compression  string
+		stdin        bool
+		skipBadLines bool
+		want         []uint32
+		wantErr      bool
+	}{
+		{
+			name:  "JSON array",
+			input: "[\n  " + testEntry1 + ",\n  " + testEntry2 + "\n]\n",
+			want:  []uint32{1, 2},
+		},
+		{
+			name:  "JSON lines",
+			input: testEntry1 + "\n\n" + testEntry2,
+			want:  []uint32{1, 2},
+		},
+		{
+			name:        "Gzip compressed JSON lines",
+			input:       testEntry1 + "\n" + testEntry2 + "\n",
+			compression: "gzip",
+			want:        []uint32{1, 2},
+		},
+		{
+			name:        "Zstd compressed JSON array",
+			input:       "[" + testEntry1 + "," + testEntry2 + "]",
+			compression: "zstd",
+			want:        []uint32{1, 2},
+		},
+		{
+			name:  "Standard input",
+			input: testEntry1 + "\n" + testEntry2 + "\n",
+			stdin: true,
+			want:  []uint32{1, 2},
+		},
+		{
+			name:    "Invalid line",
+			input:   testEntry1 + "\n{\"op\": \n" + testEntry2 + "\n",
+			want:    []uint32{1},
+			wantErr: true,
+		},
+		{
+			name:         "Invalid line skipped",
+			input:        testEntry1 + "\n{\"op\": \n" + testEntry2 + "\n",
+			skipBadLines: true,
+			want:         []uint32{1, 2},
+		},
+		{
+			name:         "Malformed JSON array",
+			input:        "[" + testEntry1 + ", {\"op\": ]",
+			skipBadLines: true,
+			want:         []uint32{1},
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeInput(t, test.input, test.compression)
+			if test.stdin {
+				stdin, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stdin.Close()
+				defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+				os.Stdin = stdin
+				path = STDIN
+			}
+
+			inputConfig := config.InputConfig{SkipBadLines: test.skipBadLines}
+			got, err := readTimestamps(context.Background(), path, inputConfig, domain.Bounds{})
+			if (err != nil) != test.wantErr {
+				t.Errorf("ReadOplogs error does not match the expected result.\nWant error: %v\nGot: %v", test.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Read oplogs do not match the expected result.\nWant: %v\nGot: %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestJSONDecoderOffset(t *testing.T) {
+	input := testEntry1 + "\n\n{\"op\": \n" + testEntry2 + "\n"
+	decoder := NewJSONDecoder(bytes.NewBufferString(input))
+
+	if _, err := decoder.Decode(); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	_, err := decoder.Decode()
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Decode error does not match the expected result.\nWant: *DecodeError\nGot: %v", err)
+	}
+	if want := int64(len(testEntry1) + 2); decodeErr.Offset != want {
+		t.Errorf("Offset does not match the expected result.\nWant: %d\nGot: %d", want, decodeErr.Offset)
+	}
+	if entry, err := decoder.Decode(); err != nil || entry.Timestamp.T != 2 {
+		t.Errorf("Entry after the invalid line does not match the expected result.\nWant: ts 2\nGot: %v, %v", entry.Timestamp, err)
+	}
+}
+
+func TestFileReaderFollow(t *testing.T) {
+	path := writeInput(t, testEntry1+"\n", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := NewFileReader(path, config.InputConfig{Follow: true, PollInterval: time.Millisecond}, domain.Bounds{}, nil, logging.NewNopLogger())
+	publisher := domain.NewInMemoryOplogPublisher(10, domain.NopMetrics{})
+	errChan := make(chan error, 1)
+	go func() { errChan <- reader.ReadOplogs(ctx, publisher) }()
+
+	oplogChan, _ := publisher.GetOplogs()
+	receive := func() uint32 {
+		select {
+		case entry := <-oplogChan:
+			return entry.Timestamp.T
+		case <-time.After(5 * time.Second):
+			t.Fatal("no oplog read")
+			return 0
+		}
+	}
+	if got := receive(); got != 1 {
+		t.Fatalf("First oplog does not match the expected result.\nWant: 1\nGot: %d", got)
+	}
+
+	// the entry appended to the file is read while following it
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(testEntry2 + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if got := receive(); got != 2 {
+		t.Fatalf("Appended oplog does not match the expected result.\nWant: 2\nGot: %d", got)
+	}
+
+	cancel()
+	if err := <-errChan; err != nil {
+		t.Errorf("ReadOplogs failed: %v", err)
+	}
+}
+
+// readTimestamps reads the file with a FileReader and returns the ts of the published oplogs.
+func readTimestamps(ctx context.Context, path string, inputConfig config.InputConfig, bounds domain.Bounds) ([]uint32, error) {
+	publisher := domain.NewInMemoryOplogPublisher(100, domain.NopMetrics{})
+	err := NewFileReader(path, inputConfig, bounds, nil, logging.NewNopLogger()).ReadOplogs(ctx, publisher)
+
+	got := []uint32{}
+	oplogChan, _ := publisher.GetOplogs()
+	for entry := range oplogChan {
+		got = append(got, entry.Timestamp.T)
+	}
+	return got, err
+}
+
+// writeInput writes the input to a file, compressed with gzip or zstd when given.
+func writeInput(t *testing.T, input string, compression string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	switch compression {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(input))
+		w.Close()
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(input))
+		w.Close()
+	default:
+		buf.WriteString(input)
+	}
+
+	path := filepath.Join(t.TempDir(), "oplog.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileReaderBounds(t *testing.T) {
+	input := ""
+	for _, ts := range []string{"1", "2", "3", "4"} {
+		input += `{"op": "i", "ns": "test.student", "o": {"_id": "s` + ts + `"}, "ts": {"T": ` + ts + `, "I": 1},` +
+			` "wall": "2024-01-02T03:04:0` + ts + `Z"}` + "\n"
+	}
+	path := writeInput(t, input, "")
+
+	tests := []struct {
+		name   string
+		bounds domain.Bounds
+		want   []uint32
+	}{
+		{
+			name:   "From and to ts",
+			bounds: domain.Bounds{From: domain.Timestamp{T: 2}, To: domain.Timestamp{T: 3, I: domain.MaxIncrement}},
+			want:   []uint32{2, 3},
+		},
+		{
+			name:   "Until wall time",
+			bounds: domain.Bounds{Until: time.Date(2024, 1, 2, 3, 4, 2, 0, time.UTC)},
+			want:   []uint32{1, 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readTimestamps(context.Background(), path, config.InputConfig{}, test.bounds)
+			if err != nil {
+				t.Fatalf("ReadOplogs failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Read oplogs do not match the expected result.\nWant: %v\nGot: %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+)
+
+// DecodeError is an oplog entry which cannot be decoded. The decoder can go on with the next entry.
+type DecodeError struct {
+	// Offset is the byte offset of the entry in the decompressed input.
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid oplog entry at byte offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// JSONDecoder decodes oplog entries from either a JSON array or newline-delimited JSON
+// (NDJSON/JSONL), which is detected from the first character of the input.
+type JSONDecoder struct {
+	reader *bufio.Reader
+
+	// array decodes a JSON array, and is nil for newline-delimited JSON
+	array   *json.Decoder
+	started bool
+
+	// offset counts the bytes read, up to the start of the array for a JSON array
+	offset int64
+}
+
+// NewJSONDecoder creates a new instance of JSONDecoder reading from r.
+func NewJSONDecoder(r io.Reader) *JSONDecoder {
+	return &JSONDecoder{reader: bufio.NewReader(r)}
+}
+
+// Decode returns the next oplog entry, or io.EOF at the end of the input. An entry which
+// cannot be decoded is returned as a *DecodeError, after which decoding can go on, while
+// any other error, such as malformed JSON in an array, ends the input.
+func (d *JSONDecoder) Decode() (domain.OplogEntry, error) {
+	if !d.started {
+		if err := d.start(); err != nil {
+			return domain.OplogEntry{}, err
+		}
+	}
+	if d.array != nil {
+		return d.decodeArrayEntry()
+	}
+	return d.decodeLine()
+}
+
+// Offset returns the byte offset of the input decoded so far.
+func (d *JSONDecoder) Offset() int64 {
+	if d.array != nil {
+		return d.offset + d.array.InputOffset()
+	}
+	return d.offset
+}
+
+func (d *JSONDecoder) start() error {
+	d.started = true
+	for {
+		c, err := d.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			d.offset++
+			continue
+		}
+		if err := d.reader.UnreadByte(); err != nil {
+			return err
+		}
+
+		if c == '[' {
+			d.array = json.NewDecoder(d.reader)
+			// skip the opening bracket
+			if _, err := d.array.Token(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (d *JSONDecoder) decodeArrayEntry() (domain.OplogEntry, error) {
+	var entry domain.OplogEntry
+	if !d.array.More() {
+		return entry, io.EOF
+	}
+
+	offset := d.Offset()
+	var raw json.RawMessage
+	if err := d.array.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return entry, fmt.Errorf("malformed JSON array after byte offset %d: %w", offset, err)
+	}
+
+	if err := unmarshalEntry(raw, &entry); err != nil {
+		return entry, &DecodeError{Offset: offset, Err: err}
+	}
+	return entry, nil
+}
+
+func (d *JSONDecoder) decodeLine() (domain.OplogEntry, error) {
+	var entry domain.OplogEntry
+	for {
+		offset := d.offset
+		line, err := d.reader.ReadBytes('\n')
+		d.offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return entry, err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return entry, err
+		}
+
+		if err := unmarshalEntry(line, &entry); err != nil {
+			return entry, &DecodeError{Offset: offset, Err: err}
+		}
+		return entry, nil
+	}
+}
+
+// unmarshalEntry decodes an entry in plain JSON or in relaxed or canonical Extended JSON.
+func unmarshalEntry(data []byte, entry *domain.OplogEntry) error {
+	// wall is either an RFC 3339 string or an Extended JSON date, and txnNumber a number or
+	// an Extended JSON long
+	var wire struct {
+		*domain.OplogEntry
+		Wall      interface{} `json:"wall"`
+		TxnNumber interface{} `json:"txnNumber"`
+	}
+	wire.OplogEntry = entry
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	wall, err := decodeWallTime(wire.Wall)
+	if err != nil {
+		return err
+	}
+	entry.Wall = wall
+	if entry.TxnNumber, err = decodeTxnNumber(wire.TxnNumber); err != nil {
+		return err
+	}
+	return decodeExtendedJSON(entry)
+}
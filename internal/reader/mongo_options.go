@@ -0,0 +1,78 @@
+package reader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoClientOptions returns the options of the URI overridden by the TLS and authentication
+// settings of the configuration, when they are set.
+func mongoClientOptions(mongoConfig config.MongoConfig) (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(mongoConfig.URI).SetDirect(mongoConfig.Direct)
+	if mongoConfig.AppName != "" {
+		clientOptions.SetAppName(mongoConfig.AppName)
+	}
+
+	if mongoConfig.TLS.Enabled {
+		tlsConfig, err := mongoTLSConfig(mongoConfig.TLS)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if auth := mongoConfig.Auth; auth.IsSet() {
+		credential := options.Credential{
+			AuthMechanism: auth.Mechanism,
+			AuthSource:    auth.Source,
+			Username:      auth.Username,
+			Password:      auth.Password,
+		}
+		// X.509 authenticates with the subject of the client certificate against $external
+		credential.PasswordSet = auth.Password != ""
+		if auth.Mechanism == "MONGODB-X509" && auth.Source == "" {
+			credential.AuthSource = "$external"
+		}
+		clientOptions.SetAuth(credential)
+	}
+
+	return clientOptions, clientOptions.Validate()
+}
+
+func mongoTLSConfig(tlsSettings config.MongoTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: tlsSettings.InsecureSkipVerify,
+	}
+
+	if tlsSettings.CAFile != "" {
+		caPEM, err := os.ReadFile(tlsSettings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificate found in the CA file")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if tlsSettings.CertFile != "" {
+		keyFile := tlsSettings.KeyFile
+		if keyFile == "" {
+			keyFile = tlsSettings.CertFile
+		}
+		certificate, err := tls.LoadX509KeyPair(tlsSettings.CertFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
@@ -2,10 +2,16 @@ package reader
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/one2nc/mongo-oplog-to-sql/config"
 	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,24 +23,77 @@ const (
 	MONGO_COLLECTION string = "oplog.rs"
 )
 
+var (
+	// errCursorClosed is returned when the server closes the tailable cursor.
+	errCursorClosed = errors.New("oplog cursor closed")
+	// errBoundReached is returned once every oplog within the bounds has been read.
+	errBoundReached = errors.New("oplog upper bound reached")
+)
+
 // MongoReader implements the OplogReader interface for reading Oplog entries from a running MongoDB instance.
 type MongoReader struct {
-	ConnectionString string
+	Config config.MongoConfig
+
+	retryPolicy retry.Policy
+	lastTS      domain.Timestamp
+	bounds      domain.Bounds
+	filter      *domain.NamespaceFilter
+	logger      *slog.Logger
 }
 
-// NewMongoReader creates a new instance of FileReader.
-func NewMongoReader(connectionStr string) OplogReader {
+// NewMongoReader creates a new instance of FileReader, which reads the oplogs after startAfter
+// or the whole oplog when startAfter is zero. The bounds and the filter are pushed down into the
+// cursor, and the reader stops once the oplog has moved past the upper bound.
+func NewMongoReader(
+	mongoConfig config.MongoConfig,
+	retryPolicy retry.Policy,
+	startAfter domain.Timestamp,
+	bounds domain.Bounds,
+	filter *domain.NamespaceFilter,
+	logger *slog.Logger,
+) OplogReader {
 	return &MongoReader{
-		ConnectionString: connectionStr,
+		Config:      mongoConfig,
+		retryPolicy: retryPolicy,
+		lastTS:      startAfter,
+		bounds:      bounds,
+		filter:      filter,
+		logger:      logger,
 	}
 }
 
 // ReadOplogs reads Oplog entries from the file and publish them in the publisher.
+// When the connection drops, the tailable cursor is reopened from the last seen ts, and the
+// attempts start over once the reopened cursor has read an oplog.
 func (mr *MongoReader) ReadOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
 	defer publisher.Stop()
 
+	attemptTS := mr.lastTS
+	progressed := func() bool {
+		moved := mr.lastTS != attemptTS
+		attemptTS = mr.lastTS
+		return moved
+	}
+	err := mr.retryPolicy.DoResumable(ctx, isTransientMongoError, func() error {
+		err := mr.tailOplogs(ctx, publisher)
+		if isTransientMongoError(err) {
+			mr.logger.Warn("oplog cursor lost, reconnecting", "ts", mr.lastTS.String(), "error", err)
+		}
+		return err
+	}, progressed)
+	if errors.Is(err, errBoundReached) {
+		mr.logger.Info("upper bound reached", "ts", mr.lastTS.String())
+		return nil
+	}
+	if errors.Is(err, errCursorClosed) || ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (mr *MongoReader) tailOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
 	// Create a MongoDB client
-	client, err := mr.getMongoClient()
+	client, err := newMongoClient(mr.Config, mr.logger)
 	if err != nil {
 		return err
 	}
@@ -43,12 +102,15 @@ func (mr *MongoReader) ReadOplogs(ctx context.Context, publisher domain.OplogPub
 	oplogCollection := client.Database(MONGO_DB_NAME).Collection(MONGO_COLLECTION)
 
 	findOptions := options.Find().SetCursorType(options.TailableAwait)
-	cursor, err := oplogCollection.Find(context.Background(), userFilter(), findOptions)
+	cursor, err := oplogCollection.Find(ctx, userFilter(mr.lastTS, mr.bounds, mr.filter), findOptions)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer cursor.Close(ctx)
 
+	health.SetReaderConnected(true)
+	defer health.SetReaderConnected(false)
+
 	for {
 		// Check if the context is done
 		select {
@@ -59,58 +121,159 @@ func (mr *MongoReader) ReadOplogs(ctx context.Context, publisher domain.OplogPub
 			// Context is still active, continue reading Oplogs
 		}
 
-		if cursor.TryNext(context.TODO()) {
-			var data bson.M
-			if err := cursor.Decode(&data); err != nil {
-				panic(err)
-			}
-
-			jsonData, err := json.Marshal(data)
+		if cursor.TryNext(ctx) {
+			entry, err := decodeOplogEntry(cursor.Current)
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("oplog after %s could not be decoded: %w", mr.lastTS, err)
 			}
 
-			var entry domain.OplogEntry
-			err = json.Unmarshal(jsonData, &entry)
-			if err != nil {
-				panic(err)
-			}
+			metrics.OplogsRead.WithLabelValues(entry.Namespace, entry.Operation).Inc()
+			metrics.ReadLag.Set(metrics.Lag(entry.Timestamp.T))
+			health.OplogRead(entry.Timestamp)
+			mr.logger.Debug(
+				"oplog read",
+				"db", entry.DatabaseName(),
+				"collection", entry.TableName(),
+				"ts", entry.Timestamp.String(),
+				"op", entry.Operation,
+			)
 
 			err = publisher.PublishOplog(entry)
 			if err != nil {
 				return err
 			}
+			mr.lastTS = entry.Timestamp
+		} else if mr.bounds.HasUpper() {
+			// the cursor is drained, every oplog within the bounds has been read
+			// once the oplog has moved to or past the upper bound
+			ts, wall, err := latestPosition(ctx, client)
+			if err != nil {
+				return err
+			}
+			if mr.bounds.ReachedEnd(ts, wall) {
+				return errBoundReached
+			}
 		}
 
 		if err := cursor.Err(); err != nil {
-			panic(err)
+			return err
 		}
 
 		if cursor.ID() == 0 {
-			break
+			return errCursorClosed
 		}
 	}
-	return nil
 }
 
-func (mr *MongoReader) getMongoClient() (*mongo.Client, error) {
+func newMongoClient(mongoConfig config.MongoConfig, logger *slog.Logger) (*mongo.Client, error) {
+	clientOptions, err := mongoClientOptions(mongoConfig)
+	if err != nil {
+		logger.Error("invalid MongoDB connection settings", "error", err)
+		return nil, err
+	}
+
 	// Connect to the MongoDB server
-	clientOptions := options.Client().ApplyURI(mr.ConnectionString).SetDirect(true)
 	client, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
-		fmt.Println("Error connecting to MongoDB:", err)
+		logger.Error("error connecting to MongoDB", "error", err)
 		return nil, err
 	}
 	return client, nil
 }
 
-func userFilter() primitive.M {
+// LatestTimestamp returns the ts of the most recent entry in the oplog.
+func LatestTimestamp(ctx context.Context, mongoConfig config.MongoConfig, logger *slog.Logger) (domain.Timestamp, error) {
+	client, err := newMongoClient(mongoConfig, logger)
+	if err != nil {
+		return domain.Timestamp{}, err
+	}
+	defer client.Disconnect(ctx)
+
+	ts, _, err := latestPosition(ctx, client)
+	return ts, err
+}
+
+// latestPosition returns the ts and wall clock time of the most recent entry in the oplog.
+func latestPosition(ctx context.Context, client *mongo.Client) (domain.Timestamp, time.Time, error) {
+	var latest struct {
+		Timestamp primitive.Timestamp `bson:"ts"`
+		Wall      time.Time           `bson:"wall"`
+	}
+	findOptions := options.FindOne().SetSort(bson.M{"$natural": -1}).SetProjection(bson.M{"ts": 1, "wall": 1})
+	err := client.Database(MONGO_DB_NAME).Collection(MONGO_COLLECTION).FindOne(ctx, bson.M{}, findOptions).Decode(&latest)
+	if err != nil {
+		return domain.Timestamp{}, time.Time{}, err
+	}
+	return domain.Timestamp{T: latest.Timestamp.T, I: latest.Timestamp.I}, latest.Wall, nil
+}
+
+func userFilter(after domain.Timestamp, bounds domain.Bounds, nsFilter *domain.NamespaceFilter) primitive.M {
+	conditions := []bson.M{
+		{"ns": bson.M{"$not": bson.M{"$regex": "^(admin|config)\\."}}},
+		{"ns": bson.M{"$not": bson.M{"$eq": ""}}},
+	}
+	if include := nsFilter.Include(); len(include) > 0 {
+		conditions = append(conditions, bson.M{"ns": bson.M{"$in": regexes(include)}})
+	}
+	if exclude := nsFilter.Exclude(); len(exclude) > 0 {
+		conditions = append(conditions, bson.M{"ns": bson.M{"$nin": regexes(exclude)}})
+	}
+
 	filter := bson.M{
-		"op": bson.M{"$nin": []string{"n", "c"}},
-		"$and": []bson.M{
-			{"ns": bson.M{"$not": bson.M{"$regex": "^(admin|config)\\."}}},
-			{"ns": bson.M{"$not": bson.M{"$eq": ""}}},
-		},
+		"op":   bson.M{"$nin": []string{"n", "c"}},
+		"$and": conditions,
+	}
+
+	ts := bson.M{}
+	if !after.IsZero() {
+		ts["$gt"] = primitive.Timestamp{T: after.T, I: after.I}
+	}
+	if !bounds.From.IsZero() {
+		ts["$gte"] = primitive.Timestamp{T: bounds.From.T, I: bounds.From.I}
+	}
+	if !bounds.To.IsZero() {
+		ts["$lte"] = primitive.Timestamp{T: bounds.To.T, I: bounds.To.I}
+	}
+	if len(ts) > 0 {
+		filter["ts"] = ts
+	}
+	if !bounds.Until.IsZero() {
+		filter["wall"] = bson.M{"$lte": bounds.Until}
 	}
 	return filter
 }
+
+func regexes(exprs []string) []primitive.Regex {
+	values := make([]primitive.Regex, 0, len(exprs))
+	for _, expr := range exprs {
+		values = append(values, primitive.Regex{Pattern: expr})
+	}
+	return values
+}
+
+// isTransientMongoError reports whether err is a network or failover error after which
+// reopening the cursor is expected to succeed.
+func isTransientMongoError(err error) bool {
+	if err == nil || errors.Is(err, errCursorClosed) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("ResumableChangeStreamError") {
+			return true
+		}
+		// InterruptedAtShutdown, HostUnreachable, HostNotFound, NetworkTimeout, ShutdownInProgress,
+		// PrimarySteppedDown, SocketException, NotWritablePrimary, InterruptedDueToReplStateChange,
+		// NotPrimaryNoSecondaryOk, NotPrimaryOrSecondary
+		for _, code := range []int{11600, 6, 7, 89, 91, 189, 9001, 10107, 11602, 13435, 13436} {
+			if serverErr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package reader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUserFilterBounds(t *testing.T) {
+	until := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		after    domain.Timestamp
+		bounds   domain.Bounds
+		wantTS   interface{}
+		wantWall interface{}
+	}{
+		{
+			name: "Open window",
+		},
+		{
+			name:   "Resumed window",
+			after:  domain.Timestamp{T: 5, I: 1},
+			bounds: domain.Bounds{From: domain.Timestamp{T: 1}, To: domain.Timestamp{T: 9, I: domain.MaxIncrement}},
+			wantTS: bson.M{
+				"$gt":  primitive.Timestamp{T: 5, I: 1},
+				"$gte": primitive.Timestamp{T: 1},
+				"$lte": primitive.Timestamp{T: 9, I: domain.MaxIncrement},
+			},
+		},
+		{
+			name:     "Wall time bound",
+			bounds:   domain.Bounds{Until: until},
+			wantWall: bson.M{"$lte": until},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := userFilter(test.after, test.bounds, nil)
+			if !reflect.DeepEqual(filter["ts"], test.wantTS) {
+				t.Errorf("ts filter does not match the expected result.\nWant: %v\nGot: %v", test.wantTS, filter["ts"])
+			}
+			if !reflect.DeepEqual(filter["wall"], test.wantWall) {
+				t.Errorf("wall filter does not match the expected result.\nWant: %v\nGot: %v", test.wantWall, filter["wall"])
+			}
+		})
+	}
+}
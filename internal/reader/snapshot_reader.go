@@ -0,0 +1,106 @@
+package reader
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/health"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// systemDatabases are never part of a snapshot.
+var systemDatabases = []string{"admin", "config", "local"}
+
+// SnapshotReader implements the OplogReader interface by publishing every document of the
+// user databases of a running MongoDB instance as an insert Oplog entry.
+type SnapshotReader struct {
+	Config config.MongoConfig
+
+	filter *domain.NamespaceFilter
+	logger *slog.Logger
+}
+
+// NewSnapshotReader creates a new instance of SnapshotReader, which skips the collections not selected by the filter.
+func NewSnapshotReader(mongoConfig config.MongoConfig, filter *domain.NamespaceFilter, logger *slog.Logger) OplogReader {
+	return &SnapshotReader{
+		Config: mongoConfig,
+		filter: filter,
+		logger: logger,
+	}
+}
+
+// ReadOplogs reads the documents of every collection and publish them as inserts in the publisher.
+func (sr *SnapshotReader) ReadOplogs(ctx context.Context, publisher domain.OplogPublisher) error {
+	defer publisher.Stop()
+
+	client, err := newMongoClient(sr.Config, sr.logger)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	dbNames, err := client.ListDatabaseNames(ctx, bson.M{"name": bson.M{"$nin": systemDatabases}})
+	if err != nil {
+		sr.logger.Error("databases could not be listed", "error", err)
+		return err
+	}
+
+	health.SetReaderConnected(true)
+	defer health.SetReaderConnected(false)
+
+	for _, dbName := range dbNames {
+		database := client.Database(dbName)
+		collectionNames, err := database.ListCollectionNames(ctx, bson.M{"type": "collection"})
+		if err != nil {
+			sr.logger.Error("collections could not be listed", "db", dbName, "error", err)
+			return err
+		}
+
+		for _, collectionName := range collectionNames {
+			namespace := dbName + domain.SEPERATOR + collectionName
+			if !sr.filter.Match(namespace) {
+				continue
+			}
+			sr.logger.Info("reading snapshot", "ns", namespace)
+
+			if err := sr.readCollection(ctx, database.Collection(collectionName), namespace, publisher); err != nil {
+				sr.logger.Error("collection could not be read", "ns", namespace, "error", err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (sr *SnapshotReader) readCollection(
+	ctx context.Context,
+	collection *mongo.Collection,
+	namespace string,
+	publisher domain.OplogPublisher,
+) error {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		document, err := decodeBSONDocument(cursor.Current)
+		if err != nil {
+			return err
+		}
+
+		entry := domain.OplogEntry{Operation: "i", Namespace: namespace, Object: document}
+		metrics.OplogsRead.WithLabelValues(entry.Namespace, entry.Operation).Inc()
+
+		if err := publisher.PublishOplog(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+)
+
+// Policy retries an operation with exponential backoff.
+type Policy struct {
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+}
+
+// NewPolicy creates a new instance of Policy from the retry configuration.
+func NewPolicy(cfg config.RetryConfig) Policy {
+	return Policy{
+		MaxAttempts:     cfg.MaxAttempts,
+		InitialInterval: cfg.InitialInterval,
+		MaxInterval:     cfg.MaxInterval,
+		Multiplier:      cfg.Multiplier,
+	}
+}
+
+// Do calls op until it succeeds, returns an error for which isTransient is false,
+// the attempts are exhausted or the context is done. The last error is returned.
+func (p Policy) Do(ctx context.Context, isTransient func(error) bool, op func() error) error {
+	return p.DoResumable(ctx, isTransient, op, func() bool { return false })
+}
+
+// DoResumable is Do for a long-running op, such as tailing a cursor, which fails now and then
+// after having made progress. When progressed reports that the failed call made progress, the
+// attempts and the backoff start over, so that only consecutive failures exhaust the attempts.
+func (p Policy) DoResumable(ctx context.Context, isTransient func(error) bool, op func() error, progressed func() bool) error {
+	interval := p.InitialInterval
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+		if progressed() {
+			attempt = 1
+			interval = p.InitialInterval
+		}
+		if attempt >= p.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+
+		interval = p.next(interval)
+	}
+}
+
+func (p Policy) next(interval time.Duration) time.Duration {
+	next := time.Duration(float64(interval) * p.Multiplier)
+	if p.MaxInterval > 0 && next > p.MaxInterval {
+		return p.MaxInterval
+	}
+	return next
+}
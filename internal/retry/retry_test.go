@@ -0,0 +1,146 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errFatal     = errors.New("fatal")
+)
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "Success",
+			errs:         []error{nil},
+			wantErr:      nil,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Transient errors then success",
+			errs:         []error{errTransient, errTransient, nil},
+			wantErr:      nil,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Fatal error is not retried",
+			errs:         []error{errTransient, errFatal, nil},
+			wantErr:      errFatal,
+			wantAttempts: 2,
+		},
+		{
+			name:         "Attempts exhausted",
+			errs:         []error{errTransient, errTransient, errTransient, errTransient, nil},
+			wantErr:      errTransient,
+			wantAttempts: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := Policy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 2}
+
+			attempts := 0
+			err := policy.Do(context.Background(), isTransient, func() error {
+				err := test.errs[attempts]
+				attempts++
+				return err
+			})
+
+			if !errors.Is(err, test.wantErr) || attempts != test.wantAttempts {
+				t.Errorf(
+					"Retry does not match the expected result.\nWant: %v after %d attempts\nGot: %v after %d attempts",
+					test.wantErr, test.wantAttempts, err, attempts,
+				)
+			}
+		})
+	}
+}
+
+func TestDoResumable(t *testing.T) {
+	tests := []struct {
+		name string
+		// progress tells whether each failed call made progress
+		progress     []bool
+		wantAttempts int
+	}{
+		{
+			name:         "No progress exhausts the attempts",
+			progress:     []bool{false, false, false, false, false, false},
+			wantAttempts: 3,
+		},
+		{
+			name:         "Progress starts the attempts over",
+			progress:     []bool{false, false, true, false, true, false, false, false},
+			wantAttempts: 7,
+		},
+		{
+			name:         "Progress on every call never exhausts the attempts",
+			progress:     []bool{true, true, true, true, true, true, true, true, true, true},
+			wantAttempts: 10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := Policy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 2}
+
+			attempts := 0
+			err := policy.DoResumable(context.Background(), isTransient, func() error {
+				attempts++
+				if attempts == len(test.progress) {
+					return nil
+				}
+				return errTransient
+			}, func() bool {
+				return test.progress[attempts-1]
+			})
+
+			if attempts != test.wantAttempts {
+				t.Errorf("Attempts do not match the expected result.\nWant: %d\nGot: %d (%v)", test.wantAttempts, attempts, err)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	policy := Policy{MaxAttempts: 100, InitialInterval: time.Hour, Multiplier: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := policy.Do(ctx, isTransient, func() error {
+		attempts++
+		return errTransient
+	})
+
+	if !errors.Is(err, errTransient) || attempts != 1 {
+		t.Errorf("Retry does not stop on a done context.\nWant: %v after 1 attempt\nGot: %v after %d attempts", errTransient, err, attempts)
+	}
+}
+
+func TestNext(t *testing.T) {
+	policy := Policy{InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	interval := policy.InitialInterval
+	for i, w := range want {
+		interval = policy.next(interval)
+		if interval != w {
+			t.Errorf("Interval %d does not match the expected result.\nWant: %s\nGot: %s", i, w, interval)
+		}
+	}
+}
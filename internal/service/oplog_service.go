@@ -3,11 +3,29 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
 	"sync"
 
+	"github.com/one2nc/mongo-oplog-to-sql/config"
 	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
 )
 
+// Ordering models supported by the pipeline.
+//
+// OrderingPerDocument processes every database and collection concurrently. All operations
+// on a document are applied in oplog order, and a schema is created before any of its
+// tables, but statements of different collections may commit in a different order than the oplog.
+//
+// OrderingStrict processes the oplog serially and writes every statement through a single
+// writer, so statements commit in global oplog (ts) order at the cost of throughput.
+const (
+	OrderingPerDocument string = "document"
+	OrderingStrict      string = "strict"
+)
+
+// SerialDatabaseName is the name of the single SQLStatement returned by ProcessOplogs.
+const SerialDatabaseName string = "1"
+
 type OplogService interface {
 	ProcessOplog(oplog string) []string
 	ProcessOplogs(
@@ -26,13 +44,28 @@ type oplogService struct {
 	databaseOplogChanMap map[string]chan domain.OplogEntry
 
 	uuidGenerator domain.UUIDGenerator
+
+	config config.PipelineConfig
+
+	metrics domain.Metrics
+
+	logger *slog.Logger
 }
 
-func NewOplogService(ctx context.Context, uuidGenerator domain.UUIDGenerator) OplogService {
+func NewOplogService(
+	ctx context.Context,
+	uuidGenerator domain.UUIDGenerator,
+	cfg config.PipelineConfig,
+	m domain.Metrics,
+	logger *slog.Logger,
+) OplogService {
 	return &oplogService{
 		ctx:                  ctx,
 		databaseOplogChanMap: make(map[string]chan domain.OplogEntry),
 		uuidGenerator:        uuidGenerator,
+		config:               cfg,
+		metrics:              m,
+		logger:               logger,
 	}
 }
 
@@ -48,7 +81,7 @@ func (s *oplogService) ProcessOplog(oplogString string) []string {
 		oplogEntries = append(oplogEntries, oplogEntry)
 	}
 
-	oplopParser := domain.NewOplogParser(s.uuidGenerator)
+	oplopParser := domain.NewOplogParser(s.uuidGenerator, s.config, s.metrics, s.logger)
 	cache := domain.NewCache()
 
 	sqlStatements := make([]string, 0)
@@ -63,15 +96,18 @@ func (s *oplogService) ProcessOplog(oplogString string) []string {
 	return sqlStatements
 }
 
+// ProcessOplogs converts the oplogs serially into a single SQLStatement, which preserves
+// the global oplog order (OrderingStrict).
 func (s *oplogService) ProcessOplogs(
 	oplogChan <-chan domain.OplogEntry,
 	cancel context.CancelFunc,
 ) chan domain.SQLStatement {
-	oplopParser := domain.NewOplogParser(s.uuidGenerator)
+	oplopParser := domain.NewOplogParser(s.uuidGenerator, s.config, s.metrics, s.logger)
 
 	sqlChan := make(chan domain.SQLStatement, 1000)
-	sqlStmt := domain.NewSQLStatement("1")
+	sqlStmt := domain.NewSQLStatement(SerialDatabaseName, s.config.Buffers.Statements, s.metrics)
 	sqlChan <- sqlStmt
+	close(sqlChan)
 
 	go func() {
 		cache := domain.NewCache()
@@ -84,14 +120,22 @@ func (s *oplogService) ProcessOplogs(
 					break forLoop
 				}
 
-				sqls, err := oplopParser.ProcessOplog(oplog, cache)
+				// invalid oplogs are skipped
+				sqlCmds, err := oplopParser.ProcessOplogCommands(oplog, cache)
 				if err != nil {
-					break
+					s.logger.Warn(
+						"skipping oplog",
+						"ns", oplog.Namespace,
+						"ts", oplog.Timestamp.String(),
+						"op", oplog.Operation,
+						"error", err,
+					)
 				}
 
-				for _, sql := range sqls {
-					sqlStmt.Publish(sql)
+				for _, sqlCmd := range sqlCmds {
+					sqlStmt.PublishRow(sqlCmd.Query, sqlCmd.Row, oplog.Timestamp)
 				}
+				sqlStmt.PublishCheckpoint(oplog.Timestamp)
 			case <-s.ctx.Done():
 				// The context is done, stop reading Oplogs
 				break forLoop
@@ -107,11 +151,13 @@ func (s *oplogService) ProcessOplogs(
 	return sqlChan
 }
 
+// ProcessOplogsConcurrent converts the oplogs into one SQLStatement per database, which
+// preserves the oplog order per document only (OrderingPerDocument).
 func (s *oplogService) ProcessOplogsConcurrent(
 	oplogChan <-chan domain.OplogEntry,
 	cancel context.CancelFunc,
 ) chan domain.SQLStatement {
-	oplopParser := domain.NewOplogParser(s.uuidGenerator)
+	oplopParser := domain.NewOplogParser(s.uuidGenerator, s.config, s.metrics, s.logger)
 
 	sqlChan := make(chan domain.SQLStatement, 1000)
 	sqlCloseChan := make(chan domain.SQLStatement, 1000)
@@ -139,8 +185,8 @@ func (s *oplogService) ProcessOplogsConcurrent(
 				name := oplog.DatabaseName()
 				databaseChan, ok := s.databaseOplogChanMap[name]
 				if !ok {
-					databaseChan = make(chan domain.OplogEntry, 1000)
-					sqlStmt := domain.NewSQLStatement(name)
+					databaseChan = make(chan domain.OplogEntry, s.config.Buffers.Databases)
+					sqlStmt := domain.NewSQLStatement(name, s.config.Buffers.Statements, s.metrics)
 
 					sqlChan <- sqlStmt
 					sqlCloseChan <- sqlStmt
@@ -3,12 +3,22 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/one2nc/mongo-oplog-to-sql/config"
 	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const STUBBED_ID = "stubbed-id"
@@ -49,8 +59,8 @@ func TestGenerateSQL(t *testing.T) {
 				}
 			  }`,
 			want: []string{
-				"CREATE SCHEMA test;",
-				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', '2000-01-30', false, 'Selena Miller', 51);",
 			},
 		},
@@ -205,8 +215,8 @@ func TestGenerateSQL(t *testing.T) {
 				}
 			  ]`,
 			want: []string{
-				"CREATE SCHEMA test;",
-				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', '2000-01-30', false, 'Selena Miller', 51);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('14798c213f273a7ca2cf5174', '2001-03-23', true, 'George Smith', 21);",
 			},
@@ -238,10 +248,10 @@ func TestGenerateSQL(t *testing.T) {
 				}
 			  ]`,
 			want: []string{
-				"CREATE SCHEMA test;",
-				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', '2000-01-30', false, 'Selena Miller', 51);",
-				"CREATE TABLE test.employee (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), salary FLOAT);",
+				"CREATE TABLE IF NOT EXISTS test.employee (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), salary FLOAT);",
 				"INSERT INTO test.employee (_id, date_of_birth, is_graduated, name, salary) VALUES ('14798c213f273a7ca2cf5174', '2001-03-23', true, 'George Smith', 10000);",
 			},
 		},
@@ -273,10 +283,10 @@ func TestGenerateSQL(t *testing.T) {
 				}
 			  ]`,
 			want: []string{
-				"CREATE SCHEMA test;",
-				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', '2000-01-30', false, 'Selena Miller', 51);",
-				"ALTER TABLE test.student ADD COLUMN phone VARCHAR(255);",
+				"ALTER TABLE test.student ADD COLUMN IF NOT EXISTS phone VARCHAR(255);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, phone, roll_no) VALUES ('14798c213f273a7ca2cf5174', '2001-03-23', true, 'George Smith', '+91-81254966457', 21);",
 			},
 		},
@@ -308,22 +318,146 @@ func TestGenerateSQL(t *testing.T) {
 				}
 			  }`,
 			want: []string{
-				"CREATE SCHEMA test;",
-				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', '2000-01-30', false, 'Selena Miller', 51);",
-				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), line1 VARCHAR(255), zip VARCHAR(255));",
-				"INSERT INTO test.student_address (_id, line1, student__id, zip) VALUES ('stubbed-id', '481 Harborsburgh', '635b79e231d82a8ab1de863b', '89799');",
-				"INSERT INTO test.student_address (_id, line1, student__id, zip) VALUES ('stubbed-id', '329 Flatside', '635b79e231d82a8ab1de863b', '80872');",
-				"CREATE TABLE test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), personal VARCHAR(255), work VARCHAR(255));",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) UNIQUE, student__id VARCHAR(255), _idx INTEGER, line1 VARCHAR(255), zip VARCHAR(255), PRIMARY KEY (student__id, _idx));",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 0, '481 Harborsburgh', '635b79e231d82a8ab1de863b', '89799');",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 1, '329 Flatside', '635b79e231d82a8ab1de863b', '80872');",
+				"CREATE TABLE IF NOT EXISTS test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), personal VARCHAR(255), work VARCHAR(255));",
 				"INSERT INTO test.student_phone (_id, personal, student__id, work) VALUES ('stubbed-id', '7678456640', '635b79e231d82a8ab1de863b', '8130097989');",
 			},
 		},
+		{
+			name: "Update Operation - array element update, append and truncation",
+			oplog: `[
+				{
+				  "op": "i",
+				  "ns": "test.student",
+				  "o": {
+					"_id": "635b79e231d82a8ab1de863b",
+					"address": [
+					  {"line1": "481 Harborsburgh", "zip": "89799"},
+					  {"line1": "329 Flatside", "zip": "80872"},
+					  {"line1": "12 Lakeview", "zip": "80100"}
+					]
+				  }
+				},
+				{
+				  "op": "u",
+				  "ns": "test.student",
+				  "o": {
+					"$v": 2,
+					"diff": {
+					  "saddress": {
+						"a": true,
+						"l": 2,
+						"s0": {"u": {"zip": "89800"}},
+						"u2": {"line1": "7 Hillside", "zip": "80200"}
+					  }
+					}
+				  },
+				  "o2": {
+					"_id": "635b79e231d82a8ab1de863b"
+				  }
+				}
+			  ]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"INSERT INTO test.student (_id) VALUES ('635b79e231d82a8ab1de863b');",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) UNIQUE, student__id VARCHAR(255), _idx INTEGER, line1 VARCHAR(255), zip VARCHAR(255), PRIMARY KEY (student__id, _idx));",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 0, '481 Harborsburgh', '635b79e231d82a8ab1de863b', '89799');",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 1, '329 Flatside', '635b79e231d82a8ab1de863b', '80872');",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 2, '12 Lakeview', '635b79e231d82a8ab1de863b', '80100');",
+				"DELETE FROM test.student_address WHERE student__id = '635b79e231d82a8ab1de863b' AND _idx >= 2;",
+				"UPDATE test.student_address SET zip = '89800' WHERE student__id = '635b79e231d82a8ab1de863b' AND _idx = 0;",
+				"DELETE FROM test.student_address WHERE student__id = '635b79e231d82a8ab1de863b' AND _idx = 2;",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 2, '7 Hillside', '635b79e231d82a8ab1de863b', '80200');",
+			},
+		},
+		{
+			name: "Update Operation - replacement and truncation of nested arrays and objects",
+			oplog: `[
+				{
+				  "op": "i",
+				  "ns": "test.student",
+				  "o": {
+					"_id": "s1",
+					"address": [
+					  {"line1": "481 Harborsburgh", "tags": ["home"]},
+					  {"line1": "329 Flatside", "tags": ["work"]},
+					  {"line1": "12 Lakeview", "tags": ["old"]}
+					],
+					"phone": {"work": "8130097989", "ext": {"code": 1}}
+				  }
+				},
+				{
+				  "op": "u",
+				  "ns": "test.student",
+				  "o": {
+					"$v": 2,
+					"diff": {
+					  "u": {"phone": {"work": "7678456640"}},
+					  "saddress": {"a": true, "l": 2, "u1": {"line1": "7 Hillside"}}
+					}
+				  },
+				  "o2": {"_id": "s1"}
+				}
+			  ]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"INSERT INTO test.student (_id) VALUES ('s1');",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) UNIQUE, student__id VARCHAR(255), _idx INTEGER, line1 VARCHAR(255), PRIMARY KEY (student__id, _idx));",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id) VALUES ('stubbed-id', 0, '481 Harborsburgh', 's1');",
+				"CREATE TABLE IF NOT EXISTS test.student_address__tags (_id VARCHAR(255) UNIQUE, student_address__id VARCHAR(255), _idx INTEGER, value VARCHAR(255), PRIMARY KEY (student_address__id, _idx));",
+				"INSERT INTO test.student_address__tags (_id, _idx, student_address__id, value) VALUES ('stubbed-id', 0, 'stubbed-id', 'home');",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id) VALUES ('stubbed-id', 1, '329 Flatside', 's1');",
+				"INSERT INTO test.student_address__tags (_id, _idx, student_address__id, value) VALUES ('stubbed-id', 0, 'stubbed-id', 'work');",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id) VALUES ('stubbed-id', 2, '12 Lakeview', 's1');",
+				"INSERT INTO test.student_address__tags (_id, _idx, student_address__id, value) VALUES ('stubbed-id', 0, 'stubbed-id', 'old');",
+				"CREATE TABLE IF NOT EXISTS test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), work VARCHAR(255));",
+				"INSERT INTO test.student_phone (_id, student__id, work) VALUES ('stubbed-id', 's1', '8130097989');",
+				"CREATE TABLE IF NOT EXISTS test.student_phone__ext (_id VARCHAR(255) PRIMARY KEY, student_phone__id VARCHAR(255), code FLOAT);",
+				"INSERT INTO test.student_phone__ext (_id, code, student_phone__id) VALUES ('stubbed-id', 1, 'stubbed-id');",
+				"DELETE FROM test.student_phone__ext WHERE student_phone__id IN (SELECT _id FROM test.student_phone WHERE student__id = 's1');",
+				"DELETE FROM test.student_phone WHERE student__id = 's1';",
+				"INSERT INTO test.student_phone (_id, student__id, work) VALUES ('stubbed-id', 's1', '7678456640');",
+				"DELETE FROM test.student_address__tags WHERE student_address__id IN (SELECT _id FROM test.student_address WHERE student__id = 's1' AND _idx >= 2);",
+				"DELETE FROM test.student_address WHERE student__id = 's1' AND _idx >= 2;",
+				"DELETE FROM test.student_address__tags WHERE student_address__id IN (SELECT _id FROM test.student_address WHERE student__id = 's1' AND _idx = 1);",
+				"DELETE FROM test.student_address WHERE student__id = 's1' AND _idx = 1;",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id) VALUES ('stubbed-id', 1, '7 Hillside', 's1');",
+			},
+		},
+		{
+			name: "Collection named as a child table",
+			oplog: `[{
+				"op": "i",
+				"ns": "test.emp",
+				"o": {"_id": "e1", "a": {"x": 1}}
+			}, {
+				"op": "i",
+				"ns": "test.emp_a",
+				"o": {"_id": "a1", "y": 2}
+			}]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.emp (_id VARCHAR(255) PRIMARY KEY);",
+				"INSERT INTO test.emp (_id) VALUES ('e1');",
+				"CREATE TABLE IF NOT EXISTS test.emp_a (_id VARCHAR(255) PRIMARY KEY, emp__id VARCHAR(255), x FLOAT);",
+				"INSERT INTO test.emp_a (_id, emp__id, x) VALUES ('stubbed-id', 'e1', 1);",
+				"CREATE TABLE IF NOT EXISTS test.emp_a_2a9c339b (_id VARCHAR(255) PRIMARY KEY, y FLOAT);",
+				"INSERT INTO test.emp_a_2a9c339b (_id, y) VALUES ('a1', 2);",
+			},
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			uuidGenerator := &StubUUIDGenerator{}
-			oplogService := NewOplogService(context.Background(), uuidGenerator)
+			oplogService := NewOplogService(context.Background(), uuidGenerator, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
 			got := oplogService.ProcessOplog(test.oplog)
 
 			if !reflect.DeepEqual(got, test.want) {
@@ -337,6 +471,599 @@ func TestGenerateSQL(t *testing.T) {
 	}
 }
 
+func TestProcessOplogsUpsert(t *testing.T) {
+	tests := []struct {
+		name   string
+		oplogs string
+		// upserts flags the oplogs which may already be applied
+		upserts []bool
+		config  func(cfg *config.PipelineConfig)
+		want    []string
+	}{
+		{
+			name: "Upsert of a document with nested sub tables",
+			oplogs: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {
+				  "_id": "635b79e231d82a8ab1de863b",
+				  "name": "Selena Miller",
+				  "address": [{"line1": "481 Harborsburgh", "geo": {"lat": 1}}]
+				}
+			}]`,
+			upserts: []bool{true},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) UNIQUE, student__id VARCHAR(255), _idx INTEGER, line1 VARCHAR(255), PRIMARY KEY (student__id, _idx));",
+				"CREATE TABLE IF NOT EXISTS test.student_address__geo (_id VARCHAR(255) PRIMARY KEY, student_address__id VARCHAR(255), lat FLOAT);",
+				"DELETE FROM test.student_address__geo WHERE student_address__id IN (SELECT _id FROM test.student_address WHERE student__id = '635b79e231d82a8ab1de863b');",
+				"DELETE FROM test.student_address WHERE student__id = '635b79e231d82a8ab1de863b';",
+				"INSERT INTO test.student (_id, name) VALUES ('635b79e231d82a8ab1de863b', 'Selena Miller') ON CONFLICT (_id) DO UPDATE SET name = EXCLUDED.name;",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id) VALUES ('stubbed-id', 0, '481 Harborsburgh', '635b79e231d82a8ab1de863b');",
+				"INSERT INTO test.student_address__geo (_id, lat, student_address__id) VALUES ('stubbed-id', 1, 'stubbed-id');",
+			},
+		},
+		{
+			name: "Inserts after the overlap are not upserts",
+			oplogs: `[
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1"}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "s2"}}
+			]`,
+			upserts: []bool{true, false},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY);",
+				"INSERT INTO test.student (_id) VALUES ('s1') ON CONFLICT (_id) DO NOTHING;",
+				"INSERT INTO test.student (_id) VALUES ('s2');",
+			},
+		},
+		{
+			name: "Upsert of a soft deleted document",
+			oplogs: `[
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1", "phone": {"work": "8130097989"}}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1", "phone": {"work": "7678456640"}}}
+			]`,
+			upserts: []bool{false, true},
+			config: func(cfg *config.PipelineConfig) {
+				cfg.Schema.SoftDelete = true
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, _deleted BOOLEAN DEFAULT false, _deleted_at TIMESTAMPTZ);",
+				"CREATE TABLE IF NOT EXISTS test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), work VARCHAR(255));",
+				"DELETE FROM test.student_phone WHERE student__id = 's1' AND EXISTS (SELECT 1 FROM test.student WHERE _id = 's1' AND _deleted);",
+				"INSERT INTO test.student (_id) VALUES ('s1') ON CONFLICT (_id) DO UPDATE SET _deleted = false, _deleted_at = NULL;",
+				"INSERT INTO test.student_phone (_id, student__id, work) VALUES ('stubbed-id', 's1', '8130097989');",
+				"DELETE FROM test.student_phone WHERE student__id = 's1';",
+				"INSERT INTO test.student (_id) VALUES ('s1') ON CONFLICT (_id) DO UPDATE SET _deleted = false, _deleted_at = NULL;",
+				"INSERT INTO test.student_phone (_id, student__id, work) VALUES ('stubbed-id', 's1', '7678456640');",
+			},
+		},
+		{
+			name: "Reinsert of a soft deleted document without some of its fields",
+			oplogs: `[
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1", "name": "Selena Miller", "age": 21}},
+				{"op": "d", "ns": "test.student", "o": {"_id": "s1"}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1", "age": 22}}
+			]`,
+			upserts: []bool{false, false, false},
+			config: func(cfg *config.PipelineConfig) {
+				cfg.Schema.SoftDelete = true
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, age FLOAT, name VARCHAR(255), _deleted BOOLEAN DEFAULT false, _deleted_at TIMESTAMPTZ);",
+				"INSERT INTO test.student (_id, age, name) VALUES ('s1', 21, 'Selena Miller') ON CONFLICT (_id) DO UPDATE SET age = EXCLUDED.age, name = EXCLUDED.name, _deleted = false, _deleted_at = NULL;",
+				"UPDATE test.student SET _deleted = true, _deleted_at = '1970-01-01T00:00:00Z' WHERE _id = 's1';",
+				"INSERT INTO test.student (_id, age) VALUES ('s1', 22) ON CONFLICT (_id) DO UPDATE SET age = EXCLUDED.age, name = NULL, _deleted = false, _deleted_at = NULL;",
+			},
+		},
+		{
+			name:    "Upsert of a jsonb document",
+			oplogs:  `[{"op": "i", "ns": "test.events", "o": {"_id": "e1", "kind": "click"}}]`,
+			upserts: []bool{true},
+			config: func(cfg *config.PipelineConfig) {
+				cfg.Mapping.Collections = map[string]config.CollectionMapping{"test.events": {Mode: config.MODE_JSONB}}
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.events (_id VARCHAR(255) PRIMARY KEY, doc JSONB);",
+				`INSERT INTO test.events (_id, doc) VALUES ('e1', '{"_id":"e1","kind":"click"}'::jsonb) ON CONFLICT (_id) DO UPDATE SET doc = EXCLUDED.doc;`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var oplogEntries []domain.OplogEntry
+			if err := json.Unmarshal([]byte(test.oplogs), &oplogEntries); err != nil {
+				t.Fatal(err)
+			}
+			oplogChan := make(chan domain.OplogEntry, len(oplogEntries))
+			for i, oplog := range oplogEntries {
+				oplog.Upsert = test.upserts[i]
+				oplogChan <- oplog
+			}
+			close(oplogChan)
+
+			cfg := config.DefaultPipeline()
+			if test.config != nil {
+				test.config(&cfg)
+			}
+			oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, cfg, domain.NopMetrics{}, logging.NewNopLogger())
+			got := collectGeneratedSQL(oplogService.ProcessOplogs(oplogChan, func() {}))
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf(
+					"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+					strings.Join(test.want, "\n"),
+					strings.Join(got, "\n"),
+				)
+			}
+		})
+	}
+}
+
+func TestProcessOplogsFlattened(t *testing.T) {
+	insert := `{
+		"op": "i",
+		"ns": "test.student",
+		"o": {
+		  "_id": "s1",
+		  "name": "Selena Miller",
+		  "address": {"city": "Springfield", "zip": "89799", "geo": {"lat": 1}, "tags": ["home"]},
+		  "address_book": "A1"
+		}
+	}`
+	tests := []struct {
+		name   string
+		update string
+		want   []string
+	}{
+		{
+			name: "Unset of a flattened object",
+			update: `{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"d": {"address": false}}},
+				"o2": {"_id": "s1"}
+			}`,
+			want: []string{
+				"UPDATE test.student SET address_city = NULL, address_zip = NULL WHERE _id = 's1';",
+				"DELETE FROM test.student_address_geo WHERE student__id = 's1';",
+				"DELETE FROM test.student_address_tags WHERE student__id = 's1';",
+			},
+		},
+		{
+			name: "Replacement of a flattened object",
+			update: `{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"u": {"address": {"city": "Shelbyville", "geo": {"lat": 2}}}}},
+				"o2": {"_id": "s1"}
+			}`,
+			want: []string{
+				"UPDATE test.student SET address_city = 'Shelbyville', address_zip = NULL WHERE _id = 's1';",
+				"DELETE FROM test.student_address_tags WHERE student__id = 's1';",
+				"DELETE FROM test.student_address_geo WHERE student__id = 's1';",
+				"INSERT INTO test.student_address_geo (_id, lat, student__id) VALUES ('stubbed-id', 2, 's1');",
+			},
+		},
+		{
+			name: "Replacement of a flattened object by a scalar",
+			update: `{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"u": {"address": "unknown"}}},
+				"o2": {"_id": "s1"}
+			}`,
+			want: []string{
+				"UPDATE test.student SET address = 'unknown', address_city = NULL, address_zip = NULL WHERE _id = 's1';",
+				"DELETE FROM test.student_address_geo WHERE student__id = 's1';",
+				"DELETE FROM test.student_address_tags WHERE student__id = 's1';",
+			},
+		},
+		{
+			name: "Update of a field of a flattened object",
+			update: `{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"saddress": {"u": {"zip": "89800"}, "d": {"geo": false}}}},
+				"o2": {"_id": "s1"}
+			}`,
+			want: []string{
+				"UPDATE test.student SET address_zip = '89800' WHERE _id = 's1';",
+				"DELETE FROM test.student_address_geo WHERE student__id = 's1';",
+			},
+		},
+		{
+			name: "Unset of a scalar field",
+			update: `{
+				"op": "u",
+				"ns": "test.student",
+				"o": {"$v": 2, "diff": {"d": {"name": false, "nickname": false}}},
+				"o2": {"_id": "s1"}
+			}`,
+			want: []string{
+				"UPDATE test.student SET name = NULL WHERE _id = 's1';",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.DefaultPipeline()
+			cfg.Schema.FlattenDepth = 1
+			oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, cfg, domain.NopMetrics{}, logging.NewNopLogger())
+			got := oplogService.ProcessOplog(fmt.Sprintf("[%s, %s]", insert, test.update))
+
+			// the statements of the insert are the same for every update
+			if len(got) < 7 {
+				t.Fatalf("Generated SQL is missing the insert: %s", strings.Join(got, "\n"))
+			}
+			got = got[7:]
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf(
+					"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+					strings.Join(test.want, "\n"),
+					strings.Join(got, "\n"),
+				)
+			}
+		})
+	}
+}
+
+func TestProcessOplogsFlattenedCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		oplogs string
+		want   []string
+	}{
+		{
+			name: "Object flattened as a field of the document",
+			oplogs: `[
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1", "address_city": "Springfield", "address": {"city": "Shelbyville"}}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "s2", "address": {"city": "Ogdenville"}}}
+			]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, address_city VARCHAR(255));",
+				"INSERT INTO test.student (_id, address_city) VALUES ('s1', 'Springfield');",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), city VARCHAR(255));",
+				"INSERT INTO test.student_address (_id, city, student__id) VALUES ('stubbed-id', 'Shelbyville', 's1');",
+				"INSERT INTO test.student (_id) VALUES ('s2');",
+				"INSERT INTO test.student_address (_id, city, student__id) VALUES ('stubbed-id', 'Ogdenville', 's2');",
+			},
+		},
+		{
+			name: "Object flattened as a column of another path",
+			oplogs: `[
+				{"op": "i", "ns": "test.student", "o": {"_id": "s1", "address": {"city": "Springfield"}}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "s2", "address_city": "Shelbyville"}},
+				{"op": "u", "ns": "test.student", "o": {"$v": 2, "diff": {"u": {"address_city": "Ogdenville"}}}, "o2": {"_id": "s1"}},
+				{"op": "i", "ns": "test.student", "o": {"_id": "s3", "address": {"city": "Capital City"}}}
+			]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, address_city VARCHAR(255));",
+				"INSERT INTO test.student (_id, address_city) VALUES ('s1', 'Springfield');",
+				"INSERT INTO test.student (_id, address_city) VALUES ('s3', 'Capital City');",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var oplogEntries []domain.OplogEntry
+			if err := json.Unmarshal([]byte(test.oplogs), &oplogEntries); err != nil {
+				t.Fatal(err)
+			}
+			oplogChan := make(chan domain.OplogEntry, len(oplogEntries))
+			for _, oplog := range oplogEntries {
+				oplogChan <- oplog
+			}
+			close(oplogChan)
+
+			cfg := config.DefaultPipeline()
+			cfg.Schema.FlattenDepth = 1
+			oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, cfg, domain.NopMetrics{}, logging.NewNopLogger())
+			got := collectGeneratedSQL(oplogService.ProcessOplogs(oplogChan, func() {}))
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf(
+					"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+					strings.Join(test.want, "\n"),
+					strings.Join(got, "\n"),
+				)
+			}
+		})
+	}
+}
+
+func TestProcessOplogsForeignKeys(t *testing.T) {
+	cfg := config.DefaultPipeline()
+	cfg.Schema.ForeignKeys = true
+	cfg.Schema.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+	oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, cfg, domain.NopMetrics{}, logging.NewNopLogger())
+	got := oplogService.ProcessOplog(`[{
+		"op": "i",
+		"ns": "test.student",
+		"o": {"_id": "s1", "name": "Selena", "address": {"city": "Springfield", "geo": {"lat": 1}}, "phones": ["1"]}
+	}]`)
+
+	want := []string{
+		"CREATE SCHEMA IF NOT EXISTS test;",
+		"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));",
+		"INSERT INTO test.student (_id, name) VALUES ('s1', 'Selena');",
+		"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), city VARCHAR(255), FOREIGN KEY (student__id) REFERENCES test.student (_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);",
+		"INSERT INTO test.student_address (_id, city, student__id) VALUES ('stubbed-id', 'Springfield', 's1');",
+		"CREATE TABLE IF NOT EXISTS test.student_address__geo (_id VARCHAR(255) PRIMARY KEY, student_address__id VARCHAR(255), lat FLOAT, FOREIGN KEY (student_address__id) REFERENCES test.student_address (_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);",
+		"INSERT INTO test.student_address__geo (_id, lat, student_address__id) VALUES ('stubbed-id', 1, 'stubbed-id');",
+		"CREATE TABLE IF NOT EXISTS test.student_phones (_id VARCHAR(255) UNIQUE, student__id VARCHAR(255), _idx INTEGER, value VARCHAR(255), PRIMARY KEY (student__id, _idx), FOREIGN KEY (student__id) REFERENCES test.student (_id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);",
+		"INSERT INTO test.student_phones (_id, _idx, student__id, value) VALUES ('stubbed-id', 0, 's1', '1');",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf(
+			"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+			strings.Join(want, "\n"),
+			strings.Join(got, "\n"),
+		)
+	}
+
+	data, err := os.ReadFile(cfg.Schema.Manifest)
+	if err != nil {
+		t.Fatalf("Failed to read the schema manifest: %v", err)
+	}
+	var manifest struct {
+		ForeignKeys bool                   `json:"foreign_keys"`
+		Tables      []domain.ManifestTable `json:"tables"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Failed to decode the schema manifest: %v", err)
+	}
+	wantTables := []domain.ManifestTable{
+		{Table: "test.student", Collection: "test.student", Path: []string{}, Kind: "collection", PrimaryKey: []string{"_id"}},
+		{
+			Table: "test.student_address", Collection: "test.student", Path: []string{"address"}, Kind: "object",
+			PrimaryKey: []string{"_id"}, Parent: "test.student", ForeignKey: "student__id", References: "_id",
+		},
+		{
+			Table: "test.student_address__geo", Collection: "test.student", Path: []string{"address", "geo"}, Kind: "object",
+			PrimaryKey: []string{"_id"}, Parent: "test.student_address", ForeignKey: "student_address__id", References: "_id",
+		},
+		{
+			Table: "test.student_phones", Collection: "test.student", Path: []string{"phones"}, Kind: "array",
+			PrimaryKey: []string{"student__id", "_idx"}, Parent: "test.student", ForeignKey: "student__id", References: "_id",
+		},
+	}
+	if !manifest.ForeignKeys || !reflect.DeepEqual(manifest.Tables, wantTables) {
+		t.Errorf("Schema manifest does not match the expected result.\nWant: %+v\nGot: %+v", wantTables, manifest)
+	}
+}
+
+func TestProcessOplogsAuditColumns(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name   string
+		config func(cfg *config.PipelineConfig)
+		oplogs string
+		want   []string
+	}{
+		{
+			name:   "Audit columns of the tables of a collection",
+			config: func(cfg *config.PipelineConfig) { cfg.Schema.AuditColumns = true },
+			oplogs: `[
+				{"op": "i", "ns": "test.student", "ts": {"T": 1, "I": 1}, "o": {"_id": "s1", "name": "Selena", "address": {"city": "Springfield"}}},
+				{"op": "i", "ns": "test.student", "ts": {"T": 2, "I": 3}, "txnNumber": 7, "o": {"_id": "s2", "name": "Bob", "age": 21}},
+				{"op": "u", "ns": "test.student", "ts": {"T": 3, "I": 1}, "o": {"$v": 2, "diff": {"u": {"name": "Sel"}}}, "o2": {"_id": "s1"}}
+			]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, _oplog_op CHAR(1), _oplog_ts BIGINT, _replicated_at TIMESTAMPTZ, _source_ns VARCHAR(255), _txn_number BIGINT, name VARCHAR(255));",
+				"INSERT INTO test.student (_id, _oplog_op, _oplog_ts, _replicated_at, _source_ns, _txn_number, name) VALUES ('s1', 'i', 4294967297, now(), 'test.student', NULL, 'Selena');",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), _oplog_op CHAR(1), _oplog_ts BIGINT, _replicated_at TIMESTAMPTZ, _source_ns VARCHAR(255), _txn_number BIGINT, city VARCHAR(255));",
+				"INSERT INTO test.student_address (_id, _oplog_op, _oplog_ts, _replicated_at, _source_ns, _txn_number, city, student__id) VALUES ('stubbed-id', 'i', 4294967297, now(), 'test.student', NULL, 'Springfield', 's1');",
+				"ALTER TABLE test.student ADD COLUMN IF NOT EXISTS age FLOAT;",
+				"INSERT INTO test.student (_id, _oplog_op, _oplog_ts, _replicated_at, _source_ns, _txn_number, age, name) VALUES ('s2', 'i', 8589934595, now(), 'test.student', 7, 21, 'Bob');",
+				"UPDATE test.student SET name = 'Sel', _oplog_op = 'u', _oplog_ts = 12884901889, _replicated_at = now(), _source_ns = 'test.student', _txn_number = NULL WHERE _id = 's1';",
+			},
+		},
+		{
+			name: "Audit columns of a jsonb mode collection",
+			config: func(cfg *config.PipelineConfig) {
+				cfg.Mapping.Collections = map[string]config.CollectionMapping{
+					"test.events": {Mode: config.MODE_JSONB, AuditColumns: &enabled},
+				}
+			},
+			oplogs: `[
+				{"op": "i", "ns": "test.events", "ts": {"T": 1, "I": 1}, "o": {"_id": "e1", "kind": "view"}},
+				{"op": "u", "ns": "test.events", "ts": {"T": 1, "I": 2}, "o": {"$v": 2, "diff": {"u": {"kind": "click"}}}, "o2": {"_id": "e1"}},
+				{"op": "i", "ns": "test.other", "ts": {"T": 1, "I": 3}, "o": {"_id": "o1"}}
+			]`,
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.events (_id VARCHAR(255) PRIMARY KEY, doc JSONB, _oplog_op CHAR(1), _oplog_ts BIGINT, _replicated_at TIMESTAMPTZ, _source_ns VARCHAR(255), _txn_number BIGINT);",
+				"INSERT INTO test.events (_id, doc, _oplog_op, _oplog_ts, _replicated_at, _source_ns, _txn_number) VALUES ('e1', '{\"_id\":\"e1\",\"kind\":\"view\"}'::jsonb, 'i', 4294967297, now(), 'test.events', NULL);",
+				"UPDATE test.events SET doc = jsonb_set(doc, '{\"kind\"}', '\"click\"'::jsonb), _oplog_op = 'u', _oplog_ts = 4294967298, _replicated_at = now(), _source_ns = 'test.events', _txn_number = NULL WHERE _id = 'e1';",
+				"CREATE TABLE IF NOT EXISTS test.other (_id VARCHAR(255) PRIMARY KEY);",
+				"INSERT INTO test.other (_id) VALUES ('o1');",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.DefaultPipeline()
+			test.config(&cfg)
+			oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, cfg, domain.NopMetrics{}, logging.NewNopLogger())
+			got := oplogService.ProcessOplog(test.oplogs)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf(
+					"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+					strings.Join(test.want, "\n"),
+					strings.Join(got, "\n"),
+				)
+			}
+		})
+	}
+}
+
+func TestProcessOplogsTypedValues(t *testing.T) {
+	decimal, _ := primitive.ParseDecimal128("1.25")
+	tests := []struct {
+		name    string
+		objects []map[string]interface{}
+		want    []string
+	}{
+		{
+			name: "Numeric column is widened",
+			objects: []map[string]interface{}{
+				{"_id": "s1", "n": int32(1)},
+				{"_id": "s2", "n": int64(1)},
+				{"_id": "s3", "n": int32(2)},
+				{"_id": "s4", "n": 1.5},
+				{"_id": "s5", "n": decimal},
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, n INTEGER);",
+				"INSERT INTO test.student (_id, n) VALUES ('s1', 1);",
+				"ALTER TABLE test.student ALTER COLUMN n TYPE BIGINT;",
+				"INSERT INTO test.student (_id, n) VALUES ('s2', 1);",
+				"INSERT INTO test.student (_id, n) VALUES ('s3', 2);",
+				"ALTER TABLE test.student ALTER COLUMN n TYPE NUMERIC;",
+				"INSERT INTO test.student (_id, n) VALUES ('s4', 1.5);",
+				"INSERT INTO test.student (_id, n) VALUES ('s5', '1.25');",
+			},
+		},
+		{
+			name: "Integer column is widened to float",
+			objects: []map[string]interface{}{
+				{"_id": "s1", "n": int32(1)},
+				{"_id": "s2", "n": 1.5, "m": int32(3)},
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, n INTEGER);",
+				"INSERT INTO test.student (_id, n) VALUES ('s1', 1);",
+				"ALTER TABLE test.student ADD COLUMN IF NOT EXISTS m INTEGER, ALTER COLUMN n TYPE FLOAT;",
+				"INSERT INTO test.student (_id, m, n) VALUES ('s2', 3, 1.5);",
+			},
+		},
+		{
+			name: "Float special values",
+			objects: []map[string]interface{}{
+				{"_id": "s1", "n": math.NaN()},
+				{"_id": "s2", "n": math.Inf(1)},
+				{"_id": "s3", "n": float32(math.Inf(-1))},
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, n FLOAT);",
+				"INSERT INTO test.student (_id, n) VALUES ('s1', 'NaN'::double precision);",
+				"INSERT INTO test.student (_id, n) VALUES ('s2', 'Infinity'::double precision);",
+				"INSERT INTO test.student (_id, n) VALUES ('s3', '-Infinity'::double precision);",
+			},
+		},
+		{
+			name: "Null and other values",
+			objects: []map[string]interface{}{
+				{"_id": "s1", "note": nil, "pattern": primitive.Regex{Pattern: "it's"}},
+			},
+			want: []string{
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, note VARCHAR(255), pattern VARCHAR(255));",
+				`INSERT INTO test.student (_id, note, pattern) VALUES ('s1', NULL, '{"pattern": "it''s", "options": ""}');`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oplogChan := make(chan domain.OplogEntry, len(test.objects))
+			for _, object := range test.objects {
+				oplogChan <- domain.OplogEntry{Operation: "i", Namespace: "test.student", Object: object}
+			}
+			close(oplogChan)
+
+			oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
+			got := collectGeneratedSQL(oplogService.ProcessOplogs(oplogChan, func() {}))
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf(
+					"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+					strings.Join(test.want, "\n"),
+					strings.Join(got, "\n"),
+				)
+			}
+		})
+	}
+}
+
+func TestProcessOplogsHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		oplogs string
+		// want are the statements on the history table
+		want []string
+	}{
+		{
+			name: "Versions of a document with sub tables",
+			oplogs: `[{
+				"op": "i",
+				"ns": "test.student",
+				"o": {"_id": "s1", "phone": {"work": "8130097989"}, "tags": ["a"], "address": [{"geo": {"lat": 1}}]},
+				"ts": {"T": 1, "I": 1}
+			}, {
+				"op": "d",
+				"ns": "test.student",
+				"o": {"_id": "s1"},
+				"ts": {"T": 2, "I": 1}
+			}]`,
+			want: []string{
+				`CREATE TABLE IF NOT EXISTS test.student_history (_id VARCHAR(255), doc JSONB, operation CHAR(1), txn_number BIGINT, ts BIGINT, valid_from TIMESTAMPTZ, valid_to TIMESTAMPTZ, PRIMARY KEY (_id, ts));`,
+				`UPDATE test.student_history SET valid_to = '1970-01-01T00:00:01Z' WHERE _id = 's1' AND valid_to IS NULL AND ts < 4294967297;`,
+				`INSERT INTO test.student_history (_id, doc, operation, txn_number, ts, valid_from) SELECT _id, to_jsonb(t) || COALESCE((SELECT jsonb_build_object('address', jsonb_agg(to_jsonb(t1) - 'student__id' - '_idx' || COALESCE((SELECT jsonb_build_object('geo', to_jsonb(t11) - 'student_address__id') FROM test.student_address__geo AS t11 WHERE t11.student_address__id = t1._id), '{}') ORDER BY t1._idx)) FROM test.student_address AS t1 WHERE t1.student__id = t._id HAVING count(*) > 0), '{}') || COALESCE((SELECT jsonb_build_object('phone', to_jsonb(t2) - 'student__id') FROM test.student_phone AS t2 WHERE t2.student__id = t._id), '{}') || COALESCE((SELECT jsonb_build_object('tags', jsonb_agg(to_jsonb(t3.value) ORDER BY t3._idx)) FROM test.student_tags AS t3 WHERE t3.student__id = t._id HAVING count(*) > 0), '{}'), 'i', NULL, 4294967297, '1970-01-01T00:00:01Z' FROM test.student AS t WHERE _id = 's1' ON CONFLICT (_id, ts) DO NOTHING;`,
+				`UPDATE test.student_history SET valid_to = '1970-01-01T00:00:02Z' WHERE _id = 's1' AND valid_to IS NULL AND ts < 8589934593;`,
+				`INSERT INTO test.student_history (_id, doc, operation, txn_number, ts, valid_from) VALUES ('s1', NULL, 'd', NULL, 8589934593, '1970-01-01T00:00:02Z') ON CONFLICT (_id, ts) DO NOTHING;`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var oplogEntries []domain.OplogEntry
+			if err := json.Unmarshal([]byte(test.oplogs), &oplogEntries); err != nil {
+				t.Fatal(err)
+			}
+			oplogChan := make(chan domain.OplogEntry, len(oplogEntries))
+			for _, oplog := range oplogEntries {
+				oplogChan <- oplog
+			}
+			close(oplogChan)
+
+			cfg := config.DefaultPipeline()
+			cfg.Schema.History = true
+			oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, cfg, domain.NopMetrics{}, logging.NewNopLogger())
+			got := []string{}
+			for _, sql := range collectGeneratedSQL(oplogService.ProcessOplogs(oplogChan, func() {})) {
+				if strings.Contains(sql, "test.student_history") {
+					got = append(got, sql)
+				}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf(
+					"Generated SQL does not match the expected result.\nWant: %s\nGot: %s",
+					strings.Join(test.want, "\n"),
+					strings.Join(got, "\n"),
+				)
+			}
+		})
+	}
+}
+
 func TestProcessOplogsConcurrent(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -349,15 +1076,15 @@ func TestProcessOplogsConcurrent(t *testing.T) {
 			oplogChan:  createValidOplogChannel(),
 			cancelFunc: func() {},
 			want: []string{
-				"CREATE SCHEMA test;",
-				"CREATE TABLE test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
+				"CREATE SCHEMA IF NOT EXISTS test;",
+				"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), roll_no FLOAT);",
 				"INSERT INTO test.student (_id, date_of_birth, is_graduated, name, roll_no) VALUES ('635b79e231d82a8ab1de863b', '2000-01-30', false, 'Selena Miller', 51);",
-				"CREATE TABLE test.student_address (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), line1 VARCHAR(255), zip VARCHAR(255));",
-				"INSERT INTO test.student_address (_id, line1, student__id, zip) VALUES ('stubbed-id', '481 Harborsburgh', '635b79e231d82a8ab1de863b', '89799');",
-				"INSERT INTO test.student_address (_id, line1, student__id, zip) VALUES ('stubbed-id', '329 Flatside', '635b79e231d82a8ab1de863b', '80872');",
-				"CREATE TABLE test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), personal VARCHAR(255), work VARCHAR(255));",
+				"CREATE TABLE IF NOT EXISTS test.student_address (_id VARCHAR(255) UNIQUE, student__id VARCHAR(255), _idx INTEGER, line1 VARCHAR(255), zip VARCHAR(255), PRIMARY KEY (student__id, _idx));",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 0, '481 Harborsburgh', '635b79e231d82a8ab1de863b', '89799');",
+				"INSERT INTO test.student_address (_id, _idx, line1, student__id, zip) VALUES ('stubbed-id', 1, '329 Flatside', '635b79e231d82a8ab1de863b', '80872');",
+				"CREATE TABLE IF NOT EXISTS test.student_phone (_id VARCHAR(255) PRIMARY KEY, student__id VARCHAR(255), personal VARCHAR(255), work VARCHAR(255));",
 				"INSERT INTO test.student_phone (_id, personal, student__id, work) VALUES ('stubbed-id', '7678456640', '635b79e231d82a8ab1de863b', '8130097989');",
-				"CREATE TABLE test.employee (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), salary FLOAT);",
+				"CREATE TABLE IF NOT EXISTS test.employee (_id VARCHAR(255) PRIMARY KEY, date_of_birth VARCHAR(255), is_graduated BOOLEAN, name VARCHAR(255), salary FLOAT);",
 				"INSERT INTO test.employee (_id, date_of_birth, is_graduated, name, salary) VALUES ('14798c213f273a7ca2cf5174', '2001-03-23', true, 'George Smith', 10000);",
 			},
 		},
@@ -368,7 +1095,7 @@ func TestProcessOplogsConcurrent(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			uuidGenerator := &StubUUIDGenerator{}
-			oplogService := NewOplogService(ctx, uuidGenerator)
+			oplogService := NewOplogService(ctx, uuidGenerator, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
 
 			sqlStmtChan := oplogService.ProcessOplogsConcurrent(test.oplogChan, test.cancelFunc)
 
@@ -406,6 +1133,356 @@ func TestProcessOplogsConcurrent(t *testing.T) {
 	}
 }
 
+func TestProcessOplogsConcurrentCollision(t *testing.T) {
+	// the child table of test.emp and the collection test.emp_a are joined to the same name,
+	// and are handled by different collection goroutines
+	jsonOplog := `[
+		{"op": "i", "ns": "test.emp", "o": {"_id": "e1", "a": {"x": 1}}},
+		{"op": "i", "ns": "test.emp", "o": {"_id": "e2", "a": {"x": 2}}},
+		{"op": "i", "ns": "test.emp_a", "o": {"_id": "a1", "y": 3}}
+	]`
+	var oplogEntries []domain.OplogEntry
+	if err := json.Unmarshal([]byte(jsonOplog), &oplogEntries); err != nil {
+		t.Fatal(err)
+	}
+	oplogChan := make(chan domain.OplogEntry, len(oplogEntries))
+	for _, oplog := range oplogEntries {
+		oplogChan <- oplog
+	}
+	close(oplogChan)
+
+	oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
+	got := collectGeneratedSQL(oplogService.ProcessOplogsConcurrent(oplogChan, func() {}))
+
+	// whichever table is named first, the rows of the child table and of the collection are
+	// written to two tables, and no table is renamed
+	tables := map[string]string{}
+	for _, sql := range got {
+		if strings.HasPrefix(sql, "ALTER TABLE") {
+			t.Fatalf("Generated SQL renames a table.\nGot: %s", strings.Join(got, "\n"))
+		}
+		matches := insertRegex.FindStringSubmatch(sql)
+		if matches == nil {
+			continue
+		}
+		kind := "collection"
+		if strings.Contains(matches[2], "emp__id") {
+			kind = "child"
+		}
+		if matches[1] == "test.emp" {
+			continue
+		}
+		if table, ok := tables[kind]; ok && table != matches[1] {
+			t.Fatalf("Rows of the %s table are written to %s and %s.\nGot: %s", kind, table, matches[1], strings.Join(got, "\n"))
+		}
+		tables[kind] = matches[1]
+	}
+	if len(tables) != 2 || tables["child"] == tables["collection"] {
+		t.Errorf("Rows of the child table and of the collection are not written to two tables.\nGot: %s", strings.Join(got, "\n"))
+	}
+}
+
+func TestProcessOplogsConcurrentOrdering(t *testing.T) {
+	// interleaved inserts, updates and deletes on the same documents across databases and collections
+	jsonOplog := `[
+		{"op": "i", "ns": "test.student", "o": {"_id": "s1", "name": "Selena Miller", "roll_no": 51}},
+		{"op": "i", "ns": "test.employee", "o": {"_id": "e1", "name": "George Smith", "salary": 10000}},
+		{"op": "i", "ns": "shop.orders", "o": {"_id": "o1", "total": 20, "items": [{"sku": "a"}, {"sku": "b"}]}},
+		{"op": "u", "ns": "test.student", "o": {"$v": 2, "diff": {"u": {"roll_no": 52}}}, "o2": {"_id": "s1"}},
+		{"op": "d", "ns": "test.student", "o": {"_id": "s1"}},
+		{"op": "i", "ns": "test.student", "o": {"_id": "s1", "name": "Selena Miller", "roll_no": 53}},
+		{"op": "u", "ns": "test.employee", "o": {"$v": 2, "diff": {"u": {"salary": 12000}}}, "o2": {"_id": "e1"}},
+		{"op": "i", "ns": "shop.orders", "o": {"_id": "o2", "total": 35, "items": [{"sku": "c"}]}},
+		{"op": "d", "ns": "shop.orders", "o": {"_id": "o1"}},
+		{"op": "u", "ns": "test.student", "o": {"$v": 2, "diff": {"d": {"roll_no": false}}}, "o2": {"_id": "s1"}},
+		{"op": "i", "ns": "test.student", "o": {"_id": "s2", "name": "Tevin Heathcote", "phone": "7678456640"}},
+		{"op": "d", "ns": "test.employee", "o": {"_id": "e1"}},
+		{"op": "i", "ns": "test.employee", "o": {"_id": "e1", "name": "George Smith", "salary": 15000}},
+		{"op": "u", "ns": "shop.orders", "o": {"$v": 2, "diff": {"u": {"total": 40}}}, "o2": {"_id": "o2"}}
+	]`
+
+	uuidGenerator := &StubUUIDGenerator{}
+	serialSQLs := NewOplogService(context.Background(), uuidGenerator, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger()).ProcessOplog(jsonOplog)
+	want := newRowModel()
+	for _, sql := range serialSQLs {
+		if err := want.apply(sql); err != nil {
+			t.Fatalf("Serial apply failed: %v", err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		// the parser adds keys to the documents, so every run needs fresh entries
+		var oplogEntries []domain.OplogEntry
+		if err := json.Unmarshal([]byte(jsonOplog), &oplogEntries); err != nil {
+			t.Fatal(err)
+		}
+
+		oplogChan := make(chan domain.OplogEntry)
+		go func() {
+			for _, oplog := range oplogEntries {
+				oplogChan <- oplog
+			}
+			close(oplogChan)
+		}()
+
+		oplogService := NewOplogService(context.Background(), uuidGenerator, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
+		concurrentSQLs := collectGeneratedSQLByDatabase(oplogService.ProcessOplogsConcurrent(oplogChan, func() {}))
+
+		// the database streams are applied one after the other, each one in its own order
+		got := newRowModel()
+		for dbName, sqls := range concurrentSQLs {
+			for _, sql := range sqls {
+				if err := got.apply(sql); err != nil {
+					t.Fatalf("Apply of the %s stream failed: %v", dbName, err)
+				}
+			}
+		}
+
+		if !reflect.DeepEqual(got.state(), want.state()) {
+			t.Fatalf("Final state does not match the serial apply.\nWant: %v\nGot: %v", want.state(), got.state())
+		}
+	}
+}
+
+var (
+	createSchemaRegex = regexp.MustCompile(`^CREATE SCHEMA IF NOT EXISTS (\w+);$`)
+	createTableRegex  = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+)\.(\w+) \((.*)\);$`)
+	alterTableRegex   = regexp.MustCompile(`^ALTER TABLE (\S+) ADD COLUMN IF NOT EXISTS (\w+) .*;$`)
+	insertRegex       = regexp.MustCompile(`^INSERT INTO (\S+) \((.*?)\) VALUES \((.*)\);$`)
+	updateRegex       = regexp.MustCompile(`^UPDATE (\S+) SET (.*) WHERE (\w+) = (.*);$`)
+	deleteRegex       = regexp.MustCompile(`^DELETE FROM (\S+) WHERE (\w+) = (.*);$`)
+)
+
+// rowModel applies the generated statements to rows held in memory, failing on the statements
+// applied to a schema, table or column that is not created yet.
+type rowModel struct {
+	schemas map[string]bool
+	columns map[string]map[string]bool
+	rows    map[string][]map[string]string
+}
+
+func newRowModel() *rowModel {
+	return &rowModel{
+		schemas: make(map[string]bool),
+		columns: make(map[string]map[string]bool),
+		rows:    make(map[string][]map[string]string),
+	}
+}
+
+func (m *rowModel) apply(sql string) error {
+	if match := createSchemaRegex.FindStringSubmatch(sql); match != nil {
+		m.schemas[match[1]] = true
+		return nil
+	}
+	if match := createTableRegex.FindStringSubmatch(sql); match != nil {
+		if !m.schemas[match[1]] {
+			return fmt.Errorf("schema %s does not exist: %s", match[1], sql)
+		}
+		table := match[1] + "." + match[2]
+		if m.columns[table] == nil {
+			m.columns[table] = make(map[string]bool)
+		}
+		for _, definition := range splitSQLList(match[3]) {
+			column := strings.Fields(definition)[0]
+			if column != "PRIMARY" && column != "FOREIGN" {
+				m.columns[table][column] = true
+			}
+		}
+		return nil
+	}
+	if match := alterTableRegex.FindStringSubmatch(sql); match != nil {
+		if m.columns[match[1]] == nil {
+			return fmt.Errorf("table %s does not exist: %s", match[1], sql)
+		}
+		m.columns[match[1]][match[2]] = true
+		return nil
+	}
+	if match := insertRegex.FindStringSubmatch(sql); match != nil {
+		columns, values := strings.Split(match[2], ", "), splitSQLList(match[3])
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		if err := m.checkColumns(match[1], row, sql); err != nil {
+			return err
+		}
+		m.rows[match[1]] = append(m.rows[match[1]], row)
+		return nil
+	}
+	if match := updateRegex.FindStringSubmatch(sql); match != nil {
+		set := make(map[string]string)
+		for _, assignment := range splitSQLList(match[2]) {
+			column, value, _ := strings.Cut(assignment, " = ")
+			set[column] = value
+		}
+		if err := m.checkColumns(match[1], set, sql); err != nil {
+			return err
+		}
+		for _, row := range m.rows[match[1]] {
+			if row[match[3]] == match[4] {
+				for column, value := range set {
+					row[column] = value
+				}
+			}
+		}
+		return nil
+	}
+	if match := deleteRegex.FindStringSubmatch(sql); match != nil {
+		if m.columns[match[1]] == nil {
+			return fmt.Errorf("table %s does not exist: %s", match[1], sql)
+		}
+		rows := m.rows[match[1]][:0]
+		for _, row := range m.rows[match[1]] {
+			if row[match[2]] != match[3] {
+				rows = append(rows, row)
+			}
+		}
+		m.rows[match[1]] = rows
+		return nil
+	}
+	return fmt.Errorf("unsupported statement: %s", sql)
+}
+
+func (m *rowModel) checkColumns(table string, row map[string]string, sql string) error {
+	for column := range row {
+		if !m.columns[table][column] {
+			return fmt.Errorf("column %s of %s does not exist: %s", column, table, sql)
+		}
+	}
+	return nil
+}
+
+// state returns the sorted rows of every table, the NULL columns left out.
+func (m *rowModel) state() map[string][]string {
+	state := make(map[string][]string)
+	for table := range m.columns {
+		rows := []string{}
+		for _, row := range m.rows[table] {
+			columns := []string{}
+			for column, value := range row {
+				if value != "NULL" {
+					columns = append(columns, column+"="+value)
+				}
+			}
+			sort.Strings(columns)
+			rows = append(rows, strings.Join(columns, " "))
+		}
+		sort.Strings(rows)
+		state[table] = rows
+	}
+	return state
+}
+
+// splitSQLList splits a comma separated list outside of quotes and parentheses.
+func splitSQLList(list string) []string {
+	items := []string{}
+	depth, quoted, start := 0, false, 0
+	for i, r := range list {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(list[start:]))
+}
+
+func TestProcessOplogsRows(t *testing.T) {
+	text := func(s string) *string { return &s }
+	birth := primitive.NewDateTimeFromTime(time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	oplogChan := make(chan domain.OplogEntry, 3)
+	oplogChan <- domain.OplogEntry{Operation: "i", Namespace: "test.student", Object: map[string]interface{}{
+		"_id":    "s1",
+		"name":   "Selena 'Sel'",
+		"score":  math.NaN(),
+		"birth":  birth,
+		"active": true,
+		"phones": []interface{}{"123"},
+		"nick":   nil,
+	}}
+	// an overwriting insert and an update have no row
+	oplogChan <- domain.OplogEntry{Operation: "i", Namespace: "test.student", Object: map[string]interface{}{"_id": "s1", "name": "B"}, Upsert: true}
+	oplogChan <- domain.OplogEntry{Operation: "u", Namespace: "test.student", Object: map[string]interface{}{"$v": 2, "diff": map[string]interface{}{"u": map[string]interface{}{"name": "C"}}}, Object2: map[string]interface{}{"_id": "s1"}}
+	close(oplogChan)
+
+	oplogService := NewOplogService(context.Background(), &StubUUIDGenerator{}, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
+	got := []domain.Row{}
+	for sqlStmt := range oplogService.ProcessOplogs(oplogChan, func() {}) {
+		for sqlCmd := range sqlStmt.GetChannel() {
+			if sqlCmd.Row != nil {
+				got = append(got, *sqlCmd.Row)
+			}
+		}
+	}
+
+	want := []domain.Row{
+		{
+			Table:   "test.student",
+			Columns: []string{"_id", "active", "birth", "name", "nick", "score"},
+			Values:  []*string{text("s1"), text("true"), text("2000-01-02T03:04:05Z"), text("Selena 'Sel'"), nil, text("NaN")},
+		},
+		{
+			Table:   "test.student_phones",
+			Columns: []string{"_id", "_idx", "student__id", "value"},
+			Values:  []*string{text(STUBBED_ID), text("0"), text("s1"), text("123")},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rows do not match the expected result.\nWant: %s\nGot: %s", formatRows(want), formatRows(got))
+	}
+}
+
+// formatRows prints the rows with their values rather than the pointers to them.
+func formatRows(rows []domain.Row) string {
+	var sb strings.Builder
+	for _, row := range rows {
+		sb.WriteString(fmt.Sprintf("\n%s %v:", row.Table, row.Columns))
+		for _, value := range row.Values {
+			if value == nil {
+				sb.WriteString(" NULL")
+			} else {
+				sb.WriteString(fmt.Sprintf(" %q", *value))
+			}
+		}
+	}
+	return sb.String()
+}
+
+func collectGeneratedSQLByDatabase(sqlStmtChan chan domain.SQLStatement) map[string][]string {
+	got := make(map[string][]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for sqlStmt := range sqlStmtChan {
+		wg.Add(1)
+		go func(sqlStmt domain.SQLStatement) {
+			defer wg.Done()
+
+			sqls := []string{}
+			for sql := range sqlStmt.GetChannel() {
+				if !sql.IsCheckpoint() {
+					sqls = append(sqls, sql.Query)
+				}
+			}
+
+			mu.Lock()
+			got[sqlStmt.GetDBName()] = sqls
+			mu.Unlock()
+		}(sqlStmt)
+	}
+	wg.Wait()
+
+	return got
+}
+
 func createValidOplogChannel() chan domain.OplogEntry {
 	// Create and return a channel with valid OplogEntry data
 	oplogChan := make(chan domain.OplogEntry)
@@ -488,7 +1565,9 @@ outerForLoop:
 						if !ok {
 							break innerForLoop
 						}
-						got = append(got, sql)
+						if !sql.IsCheckpoint() {
+							got = append(got, sql.Query)
+						}
 					case <-time.After(2 * time.Second):
 						break innerForLoop
 					}
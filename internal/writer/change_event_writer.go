@@ -0,0 +1,137 @@
+package writer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+)
+
+// ChangeEventWriter writes the oplog entries as Debezium change events to JSON lines files,
+// either one file per namespace or a single file for all of them.
+type ChangeEventWriter struct {
+	FilePath string
+
+	serverName string
+	perTable   bool
+	// name identifies the writer in the coordinator, which is nil when the written position
+	// is not tracked.
+	name        string
+	coordinator *domain.CheckpointCoordinator
+	logger      *slog.Logger
+}
+
+// NewChangeEventWriter creates a new instance of ChangeEventWriter. The events of a namespace
+// are written to <db>.<collection>_<file name> next to filePath when perTable is set, and to
+// filePath otherwise. With a coordinator, the position of the events flushed to the files is
+// committed under name.
+func NewChangeEventWriter(
+	filePath string,
+	serverName string,
+	perTable bool,
+	name string,
+	coordinator *domain.CheckpointCoordinator,
+	logger *slog.Logger,
+) *ChangeEventWriter {
+	return &ChangeEventWriter{
+		FilePath:    filePath,
+		serverName:  serverName,
+		perTable:    perTable,
+		name:        name,
+		coordinator: coordinator,
+		logger:      logger.With("file", filePath),
+	}
+}
+
+// WriteEvents writes the change events of the oplog entries in oplog order until the channel
+// is closed or the context is done. Entries which cannot be converted are logged and skipped.
+func (w *ChangeEventWriter) WriteEvents(ctx context.Context, oplogChan <-chan domain.OplogEntry) error {
+	files := make(map[string]*os.File)
+	writers := make(map[string]*bufio.Writer)
+
+	// the ts of the last entry handled is committed once the files are flushed, whenever the
+	// channel is drained
+	var written domain.Timestamp
+	flush := func() bool {
+		flushed := true
+		for path, writer := range writers {
+			if err := writer.Flush(); err != nil {
+				w.logger.Error("file could not be flushed", "path", path, "error", err)
+				flushed = false
+			}
+		}
+		if flushed && w.coordinator != nil && !written.IsZero() {
+			w.coordinator.Commit(map[string]domain.Timestamp{domain.StreamKey(w.name, ""): written})
+		}
+		return flushed
+	}
+	defer func() {
+		flush()
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for {
+		var entry domain.OplogEntry
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok = <-oplogChan:
+			if !ok {
+				return nil
+			}
+		}
+
+		if err := w.writeEvent(entry, files, writers); err != nil {
+			return err
+		}
+		written = entry.Timestamp
+		if len(oplogChan) == 0 && !flush() {
+			return fmt.Errorf("events up to %s could not be flushed", entry.Timestamp)
+		}
+	}
+}
+
+// writeEvent writes the change event of the entry to its file, opening it on first use. An
+// entry which cannot be converted is logged and skipped.
+func (w *ChangeEventWriter) writeEvent(entry domain.OplogEntry, files map[string]*os.File, writers map[string]*bufio.Writer) error {
+	event, err := domain.NewChangeEvent(entry, w.serverName, time.Now())
+	if err != nil {
+		w.logger.Warn("skipping oplog entry", "ts", entry.Timestamp.String(), "ns", entry.Namespace, "error", err)
+		return nil
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		w.logger.Warn("skipping oplog entry", "ts", entry.Timestamp.String(), "ns", entry.Namespace, "error", err)
+		return nil
+	}
+
+	path := w.FilePath
+	if w.perTable {
+		path = filepath.Join(filepath.Dir(w.FilePath), fmt.Sprintf("%s_%s", entry.Namespace, filepath.Base(w.FilePath)))
+	}
+	writer, ok := writers[path]
+	if !ok {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("file %s could not be opened: %w", path, err)
+		}
+		files[path] = file
+		writer = bufio.NewWriter(file)
+		writers[path] = writer
+	}
+
+	if _, err := writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("event could not be written to %s: %w", path, err)
+	}
+	w.logger.Debug("change event written", "ts", entry.Timestamp.String(), "ns", entry.Namespace, "op", event.Op)
+	return nil
+}
@@ -0,0 +1,142 @@
+package writer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
+)
+
+const (
+	// GlobalCheckpoint is the name of the checkpoint from which the whole stream resumes.
+	GlobalCheckpoint string = "global"
+	// SnapshotCheckpoint is the name of the position of the oplog at the end of the last
+	// snapshot, up to which the changes replayed after the snapshot may already be applied.
+	SnapshotCheckpoint string = "snapshot"
+
+	writerCheckpointPrefix string = "writer:"
+
+	createCheckpointSchemaSQL string = "CREATE SCHEMA IF NOT EXISTS oplog2sql;"
+	createCheckpointTableSQL  string = `CREATE TABLE IF NOT EXISTS oplog2sql.checkpoint (
+		name VARCHAR(255) PRIMARY KEY,
+		ts_t BIGINT NOT NULL,
+		ts_i BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+	selectCheckpointSQL  string = "SELECT ts_t, ts_i FROM oplog2sql.checkpoint WHERE name = $1;"
+	selectCheckpointsSQL string = "SELECT name, ts_t, ts_i FROM oplog2sql.checkpoint WHERE starts_with(name, $1);"
+	// the checkpoint only moves forward, so that concurrent writers never overwrite a newer one
+	upsertCheckpointSQL string = `INSERT INTO oplog2sql.checkpoint (name, ts_t, ts_i, updated_at) VALUES ($1, $2, $3, now())
+		ON CONFLICT (name) DO UPDATE SET ts_t = EXCLUDED.ts_t, ts_i = EXCLUDED.ts_i, updated_at = EXCLUDED.updated_at
+		WHERE (oplog2sql.checkpoint.ts_t, oplog2sql.checkpoint.ts_i) < (EXCLUDED.ts_t, EXCLUDED.ts_i);`
+)
+
+// ReadCheckpoint returns the checkpoint of the given name stored in the postgres DB, or the
+// zero Timestamp when it has never been saved.
+func ReadCheckpoint(dbConfig config.DBConfig, retryPolicy retry.Policy, name string) (domain.Timestamp, error) {
+	postgresConn, err := openPostgres(dbConfig, retryPolicy)
+	if err != nil {
+		return domain.Timestamp{}, err
+	}
+	defer postgresConn.Close()
+
+	var checkpoint domain.Timestamp
+	err = retryPolicy.Do(context.Background(), isTransientPostgresError, func() error {
+		if err := createCheckpointTable(postgresConn); err != nil {
+			return err
+		}
+
+		var err error
+		checkpoint, err = readCheckpoint(postgresConn, name)
+		return err
+	})
+	if err != nil {
+		return domain.Timestamp{}, fmt.Errorf("%s checkpoint could not be read: %w", name, err)
+	}
+	return checkpoint, nil
+}
+
+func createCheckpointTable(postgresConn *sql.DB) error {
+	if _, err := postgresConn.Exec(createCheckpointSchemaSQL); err != nil {
+		return err
+	}
+	_, err := postgresConn.Exec(createCheckpointTableSQL)
+	return err
+}
+
+func readCheckpoint(postgresConn *sql.DB, name string) (domain.Timestamp, error) {
+	var checkpoint domain.Timestamp
+	err := postgresConn.QueryRow(selectCheckpointSQL, name).Scan(&checkpoint.T, &checkpoint.I)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Timestamp{}, nil
+	}
+	return checkpoint, err
+}
+
+// readCheckpoints returns the checkpoints whose name starts with prefix, by their name without it.
+func readCheckpoints(postgresConn *sql.DB, prefix string) (map[string]domain.Timestamp, error) {
+	rows, err := postgresConn.Query(selectCheckpointsSQL, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]domain.Timestamp)
+	for rows.Next() {
+		var name string
+		var checkpoint domain.Timestamp
+		if err := rows.Scan(&name, &checkpoint.T, &checkpoint.I); err != nil {
+			return nil, err
+		}
+		checkpoints[strings.TrimPrefix(name, prefix)] = checkpoint
+	}
+	return checkpoints, rows.Err()
+}
+
+// streamCheckpointPrefix is the prefix of the names of the checkpoints of the collection
+// streams of a writer.
+func streamCheckpointPrefix(name string) string {
+	return writerCheckpointPrefix + name + "/"
+}
+
+func saveCheckpoint(tx *sql.Tx, name string, ts domain.Timestamp) error {
+	_, err := tx.Exec(upsertCheckpointSQL, name, ts.T, ts.I)
+	return err
+}
+
+// SaveCheckpoints stores the checkpoints by name in the postgres DB in a single transaction,
+// each unless a later one is stored.
+func SaveCheckpoints(dbConfig config.DBConfig, retryPolicy retry.Policy, checkpoints map[string]domain.Timestamp) error {
+	postgresConn, err := openPostgres(dbConfig, retryPolicy)
+	if err != nil {
+		return err
+	}
+	defer postgresConn.Close()
+
+	err = retryPolicy.Do(context.Background(), isTransientPostgresError, func() error {
+		if err := createCheckpointTable(postgresConn); err != nil {
+			return err
+		}
+
+		tx, err := postgresConn.Begin()
+		if err != nil {
+			return err
+		}
+		for name, ts := range checkpoints {
+			if err := saveCheckpoint(tx, name, ts); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return fmt.Errorf("checkpoints could not be saved: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package writer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+)
+
+// Files of a CSV export, next to the <schema>.<table>.csv files.
+const (
+	CSV_SCHEMA_FILE    string = "schema.sql"
+	CSV_POST_LOAD_FILE string = "post_load.sql"
+	CSV_LOADER_FILE    string = "load.sql"
+)
+
+// CSVExport implements the SQLWriter interface for exporting the generated rows to one CSV file
+// per table, to bulk load them with \copy rather than run every INSERT. The DDL is written to
+// schema.sql. The rows the parser emits along with the INSERT statements go to the CSV files
+// until the first statement of a stream which is not a row, such as an UPDATE or a DELETE, from
+// which every statement of the stream is written to post_load.sql in order, so that loading the
+// files and then running post_load.sql gives the same tables as running the statements. Close
+// writes load.sql, the psql script loading the export in a transaction.
+type CSVExport struct {
+	Dir string
+
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	schema   *os.File
+	postLoad *os.File
+	tables   map[string]*csvTable
+}
+
+// csvTable is the CSV file of a table, whose header holds the columns of the rows of the table
+// in the order they were first written. The rows are written with the columns known at the
+// time, and the file is padded once the export is closed when columns were added.
+type csvTable struct {
+	path    string
+	columns []string
+	added   bool
+	file    *os.File
+	writer  *bufio.Writer
+}
+
+// NewCSVExport creates a new instance of CSVExport, replacing the files of a previous export
+// in dir.
+func NewCSVExport(dir string, logger *slog.Logger) (*CSVExport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	schema, err := os.Create(filepath.Join(dir, CSV_SCHEMA_FILE))
+	if err != nil {
+		return nil, err
+	}
+	postLoad, err := os.Create(filepath.Join(dir, CSV_POST_LOAD_FILE))
+	if err != nil {
+		schema.Close()
+		return nil, err
+	}
+	return &CSVExport{
+		Dir:      dir,
+		logger:   logger.With("dir", dir),
+		schema:   schema,
+		postLoad: postLoad,
+		tables:   make(map[string]*csvTable),
+	}, nil
+}
+
+func (e *CSVExport) WriteSQL(ctx context.Context, sqlChan <-chan domain.SQLCommand) error {
+	// rows go to the CSV files until the first statement they cannot hold
+	bulk := true
+
+	for sqlCmd := range sqlChan {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if sqlCmd.IsCheckpoint() {
+			continue
+		}
+
+		var err error
+		switch query := sqlCmd.Query; {
+		case strings.HasPrefix(query, "CREATE ") || strings.HasPrefix(query, "ALTER "):
+			err = e.writeDDL(query)
+		case bulk && sqlCmd.Row != nil:
+			err = e.writeRow(*sqlCmd.Row)
+		default:
+			bulk = false
+			err = e.writePostLoad(query)
+		}
+		if err != nil {
+			return fmt.Errorf("statement at %s could not be exported: %w", sqlCmd.Timestamp, err)
+		}
+		e.logger.Debug("statement exported", "ts", sqlCmd.Timestamp.String(), "sql", sqlCmd.Query)
+	}
+	return nil
+}
+
+// writeDDL writes the statement to schema.sql.
+func (e *CSVExport) writeDDL(query string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := fmt.Fprintln(e.schema, query)
+	return err
+}
+
+func (e *CSVExport) createTable(tableName string) (*csvTable, error) {
+	table := &csvTable{path: filepath.Join(e.Dir, tableName+".csv")}
+	file, err := os.Create(table.path)
+	if err != nil {
+		return nil, err
+	}
+	table.file = file
+	table.writer = bufio.NewWriter(file)
+	e.tables[tableName] = table
+	return table, nil
+}
+
+// writeRow writes the row to the CSV file of its table, adding its new columns to the header.
+// The ON CONFLICT clause of the inserts of soft delete mode is left out, as a row only conflicts
+// once its document is deleted, and deletes end the rows of the CSV files.
+func (e *CSVExport) writeRow(row domain.Row) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	table, ok := e.tables[row.Table]
+	if !ok {
+		var err error
+		if table, err = e.createTable(row.Table); err != nil {
+			return err
+		}
+		table.columns = append(table.columns, row.Columns...)
+		if err := writeCSVRecord(table.writer, table.columns, nil); err != nil {
+			return err
+		}
+	}
+
+	values := make(map[string]*string, len(row.Columns))
+	for i, columnName := range row.Columns {
+		if !slices.Contains(table.columns, columnName) {
+			// the header and the rows already written are padded when the export is closed
+			table.columns = append(table.columns, columnName)
+			table.added = true
+		}
+		values[columnName] = row.Values[i]
+	}
+
+	fields := make([]*string, len(table.columns))
+	for i, columnName := range table.columns {
+		fields[i] = values[columnName]
+	}
+	return writeCSVRecord(table.writer, nil, fields)
+}
+
+func (e *CSVExport) writePostLoad(query string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := fmt.Fprintln(e.postLoad, query)
+	return err
+}
+
+// Close flushes the CSV files, pads the files of the tables whose columns were added after
+// their first rows, and writes load.sql.
+func (e *CSVExport) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var errs []error
+	for _, table := range e.tables {
+		errs = append(errs, table.writer.Flush(), table.file.Close())
+		if table.added {
+			errs = append(errs, padCSV(table.path, table.columns))
+		}
+	}
+	errs = append(errs, e.schema.Close(), e.postLoad.Close(), e.writeLoader())
+	return errors.Join(errs...)
+}
+
+// writeLoader writes the psql script loading the export, to run from its directory.
+func (e *CSVExport) writeLoader() error {
+	tableNames := make([]string, 0, len(e.tables))
+	for tableName := range e.tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	var sb strings.Builder
+	sb.WriteString("-- Loads the CSV export, run from its directory: psql -f load.sql\n")
+	sb.WriteString("\\set ON_ERROR_STOP on\n")
+	sb.WriteString("BEGIN;\n")
+	sb.WriteString(fmt.Sprintf("\\i %s\n", CSV_SCHEMA_FILE))
+	for _, tableName := range tableNames {
+		table := e.tables[tableName]
+		sb.WriteString(fmt.Sprintf(
+			"\\copy %s (%s) FROM '%s' WITH (FORMAT csv, HEADER true)\n",
+			tableName,
+			strings.Join(table.columns, ", "),
+			filepath.Base(table.path),
+		))
+	}
+	sb.WriteString(fmt.Sprintf("\\i %s\n", CSV_POST_LOAD_FILE))
+	sb.WriteString("COMMIT;\n")
+
+	return os.WriteFile(filepath.Join(e.Dir, CSV_LOADER_FILE), []byte(sb.String()), 0644)
+}
+
+// writeCSVRecord writes a record of names, or of fields when names is nil. NULL fields are
+// written empty and the other fields quoted, which COPY reads as NULL and strings respectively.
+func writeCSVRecord(w io.Writer, names []string, fields []*string) error {
+	var sb strings.Builder
+	if names != nil {
+		sb.WriteString(strings.Join(names, ","))
+	} else {
+		for i, field := range fields {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			if field != nil {
+				sb.WriteString(`"` + strings.ReplaceAll(*field, `"`, `""`) + `"`)
+			}
+		}
+	}
+	sb.WriteByte('\n')
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// padCSV replaces the header of the CSV file and appends empty fields to its records up to the
+// columns of the header, through a temporary file. Newlines in quoted fields do not end a
+// record, and neither do commas separate its fields.
+func padCSV(path string, header []string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+
+	// skip the previous header, made of column names without quotes or newlines
+	if _, err := reader.ReadString('\n'); err != nil && err != io.EOF {
+		return err
+	}
+	if err := writeCSVRecord(writer, header, nil); err != nil {
+		return err
+	}
+
+	fields := 1
+	quoted := false
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			fields++
+		case c == '\n' && !quoted:
+			writer.WriteString(strings.Repeat(",", len(header)-fields))
+			fields = 1
+		}
+		writer.WriteByte(c)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,125 @@
+package writer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+)
+
+func TestCSVExport(t *testing.T) {
+	text := func(s string) *string { return &s }
+	row := func(columns []string, values ...*string) *domain.Row {
+		return &domain.Row{Table: "test.student", Columns: columns, Values: values}
+	}
+
+	tests := []struct {
+		name     string
+		commands []domain.SQLCommand
+		// want are the contents of the files of the export, by name
+		want map[string]string
+	}{
+		{
+			name: "Rows and added columns",
+			commands: []domain.SQLCommand{
+				{Query: "CREATE SCHEMA IF NOT EXISTS test;"},
+				{Query: "CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255), _deleted BOOLEAN DEFAULT false);"},
+				{
+					Query: "INSERT INTO test.student (_id, name) VALUES ('s1', 'Selena \"Sel\" Miller');",
+					Row:   row([]string{"_id", "name"}, text("s1"), text(`Selena "Sel" Miller`)),
+				},
+				{
+					Query: "INSERT INTO test.student (_id, name) VALUES ('s2', 'line1\nline2, ''quoted''');",
+					Row:   row([]string{"_id", "name"}, text("s2"), text("line1\nline2, 'quoted'")),
+				},
+				{Query: "ALTER TABLE test.student ADD COLUMN IF NOT EXISTS age FLOAT, ADD COLUMN IF NOT EXISTS active BOOLEAN;"},
+				{
+					Query: "INSERT INTO test.student (_id, active, age, name) VALUES ('s3', true, 21, NULL);",
+					Row:   row([]string{"_id", "active", "age", "name"}, text("s3"), text("true"), text("21"), nil),
+				},
+				{Query: "ALTER TABLE test.student ADD COLUMN IF NOT EXISTS doc JSONB;"},
+				{
+					Query: `INSERT INTO test.student (_id, doc) VALUES ('s4', '{"a": 1}'::jsonb);`,
+					Row:   row([]string{"_id", "doc"}, text("s4"), text(`{"a": 1}`)),
+				},
+			},
+			want: map[string]string{
+				"test.student.csv": "_id,name,active,age,doc\n" +
+					`"s1","Selena ""Sel"" Miller",,,` + "\n" +
+					`"s2","line1` + "\n" + `line2, 'quoted'",,,` + "\n" +
+					`"s3",,"true","21",` + "\n" +
+					`"s4",,,,"{""a"": 1}"` + "\n",
+				CSV_SCHEMA_FILE: "CREATE SCHEMA IF NOT EXISTS test;\n" +
+					"CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255), _deleted BOOLEAN DEFAULT false);\n" +
+					"ALTER TABLE test.student ADD COLUMN IF NOT EXISTS age FLOAT, ADD COLUMN IF NOT EXISTS active BOOLEAN;\n" +
+					"ALTER TABLE test.student ADD COLUMN IF NOT EXISTS doc JSONB;\n",
+				CSV_POST_LOAD_FILE: "",
+				CSV_LOADER_FILE: "-- Loads the CSV export, run from its directory: psql -f load.sql\n" +
+					"\\set ON_ERROR_STOP on\n" +
+					"BEGIN;\n" +
+					"\\i schema.sql\n" +
+					"\\copy test.student (_id, name, active, age, doc) FROM 'test.student.csv' WITH (FORMAT csv, HEADER true)\n" +
+					"\\i post_load.sql\n" +
+					"COMMIT;\n",
+			},
+		},
+		{
+			name: "Statements which are not rows",
+			commands: []domain.SQLCommand{
+				{Query: "CREATE TABLE IF NOT EXISTS test.student (_id VARCHAR(255) PRIMARY KEY, name VARCHAR(255));"},
+				{
+					Query: "INSERT INTO test.student (_id, name) VALUES ('s1', 'A');",
+					Row:   row([]string{"_id", "name"}, text("s1"), text("A")),
+				},
+				{Query: "INSERT INTO test.student (_id, name) VALUES ('s2', (SELECT name FROM test.student WHERE _id = 's1'));"},
+				{Query: "UPDATE test.student SET name = 'B' WHERE _id = 's2';"},
+				{
+					Query: "INSERT INTO test.student (_id, name) VALUES ('s3', 'C');",
+					Row:   row([]string{"_id", "name"}, text("s3"), text("C")),
+				},
+			},
+			want: map[string]string{
+				"test.student.csv": "_id,name\n" + `"s1","A"` + "\n",
+				CSV_POST_LOAD_FILE: "INSERT INTO test.student (_id, name) VALUES ('s2', (SELECT name FROM test.student WHERE _id = 's1'));\n" +
+					"UPDATE test.student SET name = 'B' WHERE _id = 's2';\n" +
+					"INSERT INTO test.student (_id, name) VALUES ('s3', 'C');\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlChan := make(chan domain.SQLCommand, len(test.commands))
+			for i, sqlCmd := range test.commands {
+				sqlCmd.Timestamp, sqlCmd.Stream = ts(uint32(i+1)), "test.student"
+				sqlChan <- sqlCmd
+			}
+			close(sqlChan)
+
+			dir := t.TempDir()
+			export, err := NewCSVExport(dir, logging.NewNopLogger())
+			if err != nil {
+				t.Fatalf("NewCSVExport failed: %v", err)
+			}
+			if err := export.WriteSQL(context.Background(), sqlChan); err != nil {
+				t.Fatalf("WriteSQL failed: %v", err)
+			}
+			if err := export.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			for name, want := range test.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s does not match the expected result.\nWant: %s\nGot: %s", name, want, got)
+				}
+			}
+		})
+	}
+}
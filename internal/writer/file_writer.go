@@ -4,35 +4,58 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
 )
 
 // FileWriter implements the SQLWriter interface for writing SQL commands to the file.
 type FileWriter struct {
 	FilePath string
+
+	// name identifies the writer in the coordinator, which is nil when the written position
+	// is not tracked.
+	name        string
+	coordinator *domain.CheckpointCoordinator
+	logger      *slog.Logger
 }
 
-// NewFileWriter creates a new instance of FileWriter.
-func NewFileWriter(filePath string) SQLWriter {
+// NewFileWriter creates a new instance of FileWriter. With a coordinator, the positions of the
+// streams flushed to the file are committed under name.
+func NewFileWriter(filePath string, name string, coordinator *domain.CheckpointCoordinator, logger *slog.Logger) SQLWriter {
 	return &FileWriter{
-		FilePath: filePath,
+		FilePath:    filePath,
+		name:        name,
+		coordinator: coordinator,
+		logger:      logger.With("file", filePath),
 	}
 }
 
-func (f *FileWriter) WriteSQL(ctx context.Context, sqlChan <-chan string) {
+func (f *FileWriter) WriteSQL(ctx context.Context, sqlChan <-chan domain.SQLCommand) (err error) {
 	outputFile, err := os.OpenFile(f.FilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("file could not be opened: %w", err)
 	}
 	defer outputFile.Close()
 
+	// the positions of the streams checkpointed are committed once flushed, whenever the
+	// channel is drained
+	streams := newStreamCheckpoint(nil)
 	writer := bufio.NewWriter(outputFile)
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("file could not be flushed: %w", err)
+		}
+		if positions := streams.positions(f.name); f.coordinator != nil && len(positions) > 0 {
+			f.coordinator.Commit(positions)
+		}
+		streams.committed()
+		return nil
+	}
 	defer func() {
-		err = writer.Flush()
-		if err != nil {
-			fmt.Println(err)
-			return
+		if flushErr := flush(); flushErr != nil && err == nil {
+			err = flushErr
 		}
 	}()
 
@@ -41,16 +64,25 @@ func (f *FileWriter) WriteSQL(ctx context.Context, sqlChan <-chan string) {
 		select {
 		case <-ctx.Done():
 			// The context is done, stop reading Oplogs
-			return
+			return nil
 		default:
 			// Context is still active, continue reading Oplogs
 		}
 
-		_, err := writer.WriteString(fmt.Sprintf("%s\n", sqlCmd))
-		println(sqlCmd)
-		if err != nil {
-			fmt.Println(err)
-			return
+		streams.observe(sqlCmd)
+		if sqlCmd.IsCheckpoint() {
+			if len(sqlChan) == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if _, err := writer.WriteString(fmt.Sprintf("%s\n", sqlCmd.Query)); err != nil {
+			return fmt.Errorf("statement could not be written: %w", err)
 		}
+		f.logger.Debug("statement written", "ts", sqlCmd.Timestamp.String(), "sql", sqlCmd.Query)
 	}
+	return nil
 }
@@ -0,0 +1,73 @@
+package writer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+)
+
+func TestFileWriterCommit(t *testing.T) {
+	tests := []struct {
+		name          string
+		commands      []domain.SQLCommand
+		want          string
+		wantWatermark domain.Timestamp
+	}{
+		{
+			name: "Watermark of the flushed checkpoints",
+			commands: []domain.SQLCommand{
+				{Query: "INSERT INTO test.a ...", Timestamp: ts(1), Stream: "test.a"},
+				{Timestamp: ts(1), Stream: "test.a"},
+				{Query: "INSERT INTO test.b ...", Timestamp: ts(2), Stream: "test.b"},
+				{Timestamp: ts(2), Stream: "test.b"},
+			},
+			want:          "INSERT INTO test.a ...\nINSERT INTO test.b ...\n",
+			wantWatermark: ts(2),
+		},
+		{
+			name: "Statements without checkpoint",
+			commands: []domain.SQLCommand{
+				{Query: "INSERT INTO test.a ...", Timestamp: ts(1), Stream: "test.a"},
+			},
+			want: "INSERT INTO test.a ...\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlChan := make(chan domain.SQLCommand, len(test.commands))
+			for _, sqlCmd := range test.commands {
+				sqlChan <- sqlCmd
+			}
+			close(sqlChan)
+
+			coordinator := domain.NewCheckpointCoordinator(domain.Timestamp{})
+			for _, sqlCmd := range test.commands {
+				if sqlCmd.IsCheckpoint() {
+					coordinator.Dispatch(domain.StreamKey("test", sqlCmd.Stream), sqlCmd.Timestamp)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "test.sql")
+			fileWriter := NewFileWriter(path, "test", coordinator, logging.NewNopLogger())
+			if err := fileWriter.WriteSQL(context.Background(), sqlChan); err != nil {
+				t.Fatalf("WriteSQL failed: %v", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("Written statements do not match the expected result.\nWant: %s\nGot: %s", test.want, got)
+			}
+			if watermark := coordinator.Watermark(nil); watermark != test.wantWatermark {
+				t.Errorf("Watermark does not match the expected result.\nWant: %s\nGot: %s", test.wantWatermark, watermark)
+			}
+		})
+	}
+}
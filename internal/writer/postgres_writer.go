@@ -3,97 +3,463 @@ package writer
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/retry"
 )
 
 // PostgresWriter implements the SQLWriter interface for writing SQL commands to the postgres DB.
 type PostgresWriter struct {
-	dbConn *sql.DB
+	dbConn      *sql.DB
+	retryPolicy retry.Policy
+
+	// tx holds the open transaction and batch the statements executed in it,
+	// so that the batch can be replayed when the transaction is lost.
+	tx         *sql.Tx
+	batch      []string
+	batchBytes int
+	batchDDL   int
+
+	flushConfig config.FlushConfig
+
+	// name identifies the writer in the coordinator, which is nil when checkpointing is disabled.
+	// The writer skips the oplogs of the streams it committed before a restart, and saves the
+	// checkpoints of its streams along with the global watermark in the transaction of the batch.
+	// committedTS is the last oplog committed.
+	name        string
+	coordinator *domain.CheckpointCoordinator
+	streams     *streamCheckpoint
+	committedTS domain.Timestamp
+
+	// commitUnknown is set once a commit of the batch has failed without telling whether the
+	// transaction was committed, which the checkpoint saved along with it tells.
+	commitUnknown bool
+
+	metrics domain.Metrics
+	health  domain.Health
+	logger  *slog.Logger
 }
 
 // NewPostgresWriter creates a new instance of PostgresWriter.
-func NewPostgresWriter(dbConfig config.DBConfig) SQLWriter {
-	connStr := fmt.Sprintf(
-		"postgresql://%s:%s@%s:%s/%s?sslmode=disable",
-		dbConfig.UserName,
-		dbConfig.Password,
-		dbConfig.Host,
-		dbConfig.Port,
-		dbConfig.Name,
-	)
-	postgresConn, err := sql.Open(POSTGRES, connStr)
+func NewPostgresWriter(
+	dbConfig config.DBConfig,
+	retryPolicy retry.Policy,
+	flushConfig config.FlushConfig,
+	name string,
+	coordinator *domain.CheckpointCoordinator,
+	m domain.Metrics,
+	h domain.Health,
+	logger *slog.Logger,
+) (SQLWriter, error) {
+	postgresConn, err := openPostgres(dbConfig, retryPolicy)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	h.WriterConnected(name, true)
 
-	// Set connection pool properties
-	postgresConn.SetMaxOpenConns(10)
-	postgresConn.SetMaxIdleConns(5)
-	postgresConn.SetConnMaxLifetime(5 * time.Minute)
+	var checkpoint domain.Timestamp
+	var streamCheckpoints map[string]domain.Timestamp
+	if coordinator != nil {
+		err := retryPolicy.Do(context.Background(), isTransientPostgresError, func() error {
+			if err := createCheckpointTable(postgresConn); err != nil {
+				return err
+			}
 
-	err = postgresConn.Ping()
-	if err != nil {
-		panic(err)
+			var err error
+			streamCheckpoints, err = readCheckpoints(postgresConn, streamCheckpointPrefix(name))
+			return err
+		})
+		if err != nil {
+			postgresConn.Close()
+			return nil, fmt.Errorf("checkpoint of %s could not be read: %w", name, err)
+		}
+
+		// the oplogs of the streams committed before a restart are skipped, so they are
+		// committed to the coordinator up front
+		positions := make(map[string]domain.Timestamp, len(streamCheckpoints))
+		for stream, ts := range streamCheckpoints {
+			positions[domain.StreamKey(name, stream)] = ts
+			if ts.After(checkpoint) {
+				checkpoint = ts
+			}
+		}
+		coordinator.Commit(positions)
 	}
 
 	return &PostgresWriter{
-		dbConn: postgresConn,
+		dbConn:      postgresConn,
+		retryPolicy: retryPolicy,
+		batch:       make([]string, 0, flushConfig.MaxStatements),
+		flushConfig: flushConfig,
+		name:        name,
+		coordinator: coordinator,
+		streams:     newStreamCheckpoint(streamCheckpoints),
+		committedTS: checkpoint,
+		metrics:     m,
+		health:      h,
+		logger:      logger.With("db", name),
+	}, nil
+}
+
+func openPostgres(dbConfig config.DBConfig, retryPolicy retry.Policy) (*sql.DB, error) {
+	postgresConn, err := sql.Open(POSTGRES, connectionString(dbConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	// Set connection pool properties
+	postgresConn.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	postgresConn.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	postgresConn.SetConnMaxLifetime(dbConfig.ConnMaxLifetime)
+
+	err = retryPolicy.Do(context.Background(), isTransientPostgresError, postgresConn.Ping)
+	if err != nil {
+		postgresConn.Close()
+		return nil, fmt.Errorf("postgres could not be reached: %w", err)
 	}
+
+	return postgresConn, nil
 }
 
 const (
-	POSTGRES  string = "postgres"
-	BatchSize int    = 10000
+	POSTGRES string = "postgres"
 )
 
-func (p *PostgresWriter) WriteSQL(ctx context.Context, sqlChan <-chan string) {
+// errCommitOutcomeUnknown is returned when the connection is lost while committing a batch
+// whose outcome cannot be read back from the checkpoint.
+var errCommitOutcomeUnknown = errors.New("connection lost while committing, the outcome of the commit is unknown")
+
+// connectionString returns the URL of the target, with its TLS settings and the parameters
+// of the session. The password authenticates with md5 or SCRAM-SHA-256 as requested by the
+// server, and the client certificate authenticates with the cert method.
+func connectionString(dbConfig config.DBConfig) string {
+	params := url.Values{}
+	params.Set("sslmode", dbConfig.SSLMode)
+	setParam(params, "sslrootcert", dbConfig.SSLRootCert)
+	setParam(params, "sslcert", dbConfig.SSLCert)
+	setParam(params, "sslkey", dbConfig.SSLKey)
+	setParam(params, "application_name", dbConfig.ApplicationName)
+	if dbConfig.ConnectTimeout > 0 {
+		params.Set("connect_timeout", strconv.Itoa(int(dbConfig.ConnectTimeout.Seconds())))
+	}
+	// unknown parameters are sent to the server as run-time parameters of the session
+	if dbConfig.StatementTimeout > 0 {
+		params.Set("statement_timeout", strconv.FormatInt(dbConfig.StatementTimeout.Milliseconds(), 10))
+	}
+
+	connURL := url.URL{
+		Scheme:   "postgresql",
+		Host:     net.JoinHostPort(dbConfig.Host, dbConfig.Port),
+		Path:     "/" + dbConfig.Name,
+		RawQuery: params.Encode(),
+	}
+	if dbConfig.Password != "" {
+		connURL.User = url.UserPassword(dbConfig.UserName, dbConfig.Password)
+	} else {
+		connURL.User = url.User(dbConfig.UserName)
+	}
+	return connURL.String()
+}
+
+func setParam(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
+
+// WriteSQL executes the SQL commands in batches. A batch is committed on an oplog boundary
+// once it holds FlushConfig.MaxStatements statements or FlushConfig.MaxBytes bytes, or once its
+// first statement is older than FlushConfig.MaxLatency, so that a trickle of changes while
+// tailing is committed promptly when the stream goes idle. The uncommitted batch is rolled back
+// when a statement or a commit fails.
+func (p *PostgresWriter) WriteSQL(ctx context.Context, sqlChan <-chan domain.SQLCommand) error {
 	defer p.dbConn.Close()
+	defer func() {
+		if p.tx != nil {
+			p.tx.Rollback()
+		}
+	}()
 
-	cnt := 0
-	tx, err := p.dbConn.Begin()
-	if err != nil {
-		panic(err)
+	if err := p.begin(ctx); err != nil {
+		return err
 	}
 
-	for sqlCmd := range sqlChan {
-		// Check if the context is done
+	// the latency timer runs while the batch holds uncommitted statements
+	var latencyTimer *time.Timer
+	var latencyChan <-chan time.Time
+	overdue := false
+	atBoundary := true
+
+	flush := func() error {
+		if latencyTimer != nil {
+			latencyTimer.Stop()
+		}
+		latencyChan = nil
+		overdue = false
+		return p.commit(ctx)
+	}
+
+	for {
 		select {
 		case <-ctx.Done():
 			// The context is done, stop reading Oplogs
-			return
-		default:
-			// Context is still active, continue reading Oplogs
-		}
+			return nil
+
+		case <-latencyChan:
+			latencyChan = nil
+			overdue = true
+			if atBoundary {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+		case sqlCmd, ok := <-sqlChan:
+			if !ok {
+				// Commit the remaining queries
+				return flush()
+			}
 
-		cnt++
-		if cnt%BatchSize == 0 {
+			// skip the oplogs committed before a restart
+			if p.streams.skip(sqlCmd) {
+				continue
+			}
 
-			err = tx.Commit()
-			if err != nil {
-				panic(err)
+			// commit on oplog boundaries only, so that an oplog is never partially committed
+			atBoundary = p.streams.observe(sqlCmd)
+			if sqlCmd.IsCheckpoint() {
+				if atBoundary && (overdue || p.isBatchFull()) {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				continue
 			}
 
-			tx, err = p.dbConn.Begin()
-			if err != nil {
-				panic(err)
+			if len(p.batch) == 0 && p.flushConfig.MaxLatency > 0 {
+				latencyTimer = time.NewTimer(p.flushConfig.MaxLatency)
+				latencyChan = latencyTimer.C
+			}
+
+			p.logger.Debug("executing statement", "ts", sqlCmd.Timestamp.String(), "sql", sqlCmd.Query)
+			if err := p.exec(ctx, sqlCmd.Query); err != nil {
+				return fmt.Errorf("statement at %s failed: %w", sqlCmd.Timestamp, err)
 			}
 		}
+	}
+}
+
+func (p *PostgresWriter) isBatchFull() bool {
+	return (p.flushConfig.MaxStatements > 0 && len(p.batch) >= p.flushConfig.MaxStatements) ||
+		(p.flushConfig.MaxBytes > 0 && p.batchBytes >= p.flushConfig.MaxBytes)
+}
 
-		_, err = tx.Exec(sqlCmd)
-		println(sqlCmd)
+func (p *PostgresWriter) begin(ctx context.Context) error {
+	return p.retryPolicy.Do(ctx, isTransientPostgresError, func() error {
+		tx, err := p.dbConn.Begin()
 		if err != nil {
+			return err
+		}
+		p.tx = tx
+		return nil
+	})
+}
+
+// exec executes the statement in the open transaction, replaying the batch on a transient error.
+func (p *PostgresWriter) exec(ctx context.Context, sqlCmd string) error {
+	p.batch = append(p.batch, sqlCmd)
+	p.batchBytes += len(sqlCmd)
+	if isDDL(sqlCmd) {
+		p.batchDDL++
+	}
+
+	_, err := p.tx.Exec(sqlCmd)
+	if err != nil {
+		p.metrics.ApplyError(p.name)
+	}
+	if !isTransientPostgresError(err) {
+		return err
+	}
+
+	p.logger.Warn("replaying uncommitted batch", "statements", len(p.batch), "error", err)
+	p.health.WriterConnected(p.name, false)
+	p.tx.Rollback()
+	p.tx = nil
+	return p.retryPolicy.Do(ctx, isTransientPostgresError, p.replay)
+}
+
+// commit commits the open transaction and begins the next one, replaying the batch on a transient error.
+func (p *PostgresWriter) commit(ctx context.Context) error {
+	start := time.Now()
+	err := p.retryPolicy.Do(ctx, isTransientPostgresError, func() error {
+		if p.commitUnknown {
+			landed, err := p.landed()
+			if err != nil || landed {
+				return err
+			}
+			p.commitUnknown = false
+		}
+
+		if p.tx == nil {
+			if err := p.replay(); err != nil {
+				return err
+			}
+		}
+
+		if err := p.saveCheckpoint(); err != nil {
+			p.tx.Rollback()
+			p.tx = nil
+			return err
+		}
+
+		if err := p.tx.Commit(); err != nil {
+			p.metrics.ApplyError(p.name)
+			p.logger.Warn("batch commit failed", "statements", len(p.batch), "error", err)
+			p.health.WriterConnected(p.name, false)
+			p.tx = nil
+			p.commitUnknown = isTransientPostgresError(err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.commitUnknown = false
+	positions := p.streams.positions(p.name)
+	if last := p.streams.committed(); last.After(p.committedTS) {
+		p.committedTS = last
+	}
+
+	if p.coordinator != nil && len(positions) > 0 {
+		p.coordinator.Commit(positions)
+	}
+
+	p.metrics.BatchCommitted(p.name, len(p.batch), time.Since(start), p.committedTS)
+	p.health.Committed(p.name, p.committedTS, len(p.batch), p.batchDDL)
+	p.logger.Debug("batch committed", "ts", p.committedTS.String(), "statements", len(p.batch))
+
+	p.batch = p.batch[:0]
+	p.batchBytes = 0
+	p.batchDDL = 0
+	return p.begin(ctx)
+}
+
+// landed reports whether the transaction of the batch was committed although its commit
+// failed, by reading back a stream checkpoint moved forward by the batch. The outcome of the
+// commit is unknown when the batch moves no checkpoint forward, in which case replaying it
+// could apply its statements twice.
+func (p *PostgresWriter) landed() (bool, error) {
+	if p.coordinator == nil || len(p.streams.pending) == 0 {
+		return false, errCommitOutcomeUnknown
+	}
+
+	// the checkpoints of a stream only move forward
+	var stream string
+	var ts domain.Timestamp
+	for stream, ts = range p.streams.pending {
+		break
+	}
+
+	checkpoint, err := readCheckpoint(p.dbConn, streamCheckpointPrefix(p.name)+stream)
+	if err != nil {
+		return false, err
+	}
+	p.health.WriterConnected(p.name, true)
+	landed := !ts.After(checkpoint)
+	if landed {
+		p.logger.Info("failed commit was applied", "ts", ts.String())
+	} else {
+		p.logger.Warn("replaying uncommitted batch", "statements", len(p.batch))
+	}
+	return landed, nil
+}
+
+// saveCheckpoint saves the checkpoints of the streams and the global watermark the coordinator
+// derives from them in the open transaction.
+func (p *PostgresWriter) saveCheckpoint() error {
+	if p.coordinator == nil || len(p.streams.pending) == 0 {
+		return nil
+	}
+
+	for stream, ts := range p.streams.pending {
+		if err := saveCheckpoint(p.tx, streamCheckpointPrefix(p.name)+stream, ts); err != nil {
+			return err
+		}
+	}
+
+	watermark := p.coordinator.Watermark(p.streams.positions(p.name))
+	if watermark.IsZero() {
+		return nil
+	}
+	return saveCheckpoint(p.tx, GlobalCheckpoint, watermark)
+}
+
+// replay executes the uncommitted batch in a new transaction.
+func (p *PostgresWriter) replay() error {
+	tx, err := p.dbConn.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, sqlCmd := range p.batch {
+		if _, err := tx.Exec(sqlCmd); err != nil {
 			tx.Rollback()
-			panic(err)
+			return err
 		}
+	}
+
+	p.tx = tx
+	p.health.WriterConnected(p.name, true)
+	return nil
+}
 
+func isDDL(sqlCmd string) bool {
+	return strings.HasPrefix(sqlCmd, "CREATE ") || strings.HasPrefix(sqlCmd, "ALTER ")
+}
+
+// isTransientPostgresError reports whether err is a connection or concurrency error
+// after which retrying the transaction is expected to succeed.
+func isTransientPostgresError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
 	}
 
-	// Commit the remaining queries
-	if err := tx.Commit(); err != nil {
-		panic(err)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08": // connection_exception
+			return true
+		}
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03", // cannot_connect_now
+			"53300": // too_many_connections
+			return true
+		}
+		return false
 	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
@@ -0,0 +1,72 @@
+package writer
+
+import "github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+
+// streamCheckpoint tracks the position of a writer in the collection streams of its database,
+// whose statements reach it interleaved.
+//
+// Each stream is checkpointed at its last applied oplog, so that the oplogs of a stream
+// applied before a restart are skipped when the stream resumes. The positions of the streams
+// are committed to the coordinator, which derives the position from which the oplog resumes.
+// A batch may only be committed once no stream is in the middle of an oplog.
+type streamCheckpoint struct {
+	// streams are the positions committed before the writer started
+	streams map[string]domain.Timestamp
+
+	// open holds the streams with statements of an oplog whose checkpoint is not seen yet
+	open map[string]bool
+	// pending are the positions reached by the batch
+	pending map[string]domain.Timestamp
+}
+
+func newStreamCheckpoint(streams map[string]domain.Timestamp) *streamCheckpoint {
+	return &streamCheckpoint{
+		streams: streams,
+		open:    make(map[string]bool),
+		pending: make(map[string]domain.Timestamp),
+	}
+}
+
+// skip reports whether the command belongs to an oplog committed before a restart.
+func (c *streamCheckpoint) skip(sqlCmd domain.SQLCommand) bool {
+	streamTS, ok := c.streams[sqlCmd.Stream]
+	return ok && !sqlCmd.Timestamp.After(streamTS)
+}
+
+// observe records the command and reports whether the writer is on an oplog boundary of
+// every stream, where the batch may be committed.
+func (c *streamCheckpoint) observe(sqlCmd domain.SQLCommand) bool {
+	if !sqlCmd.IsCheckpoint() {
+		c.open[sqlCmd.Stream] = true
+		return false
+	}
+
+	delete(c.open, sqlCmd.Stream)
+	if !sqlCmd.Timestamp.IsZero() {
+		c.pending[sqlCmd.Stream] = sqlCmd.Timestamp
+	}
+	return len(c.open) == 0
+}
+
+// positions returns the positions reached by the batch by the key of their stream in the
+// coordinator.
+func (c *streamCheckpoint) positions(name string) map[string]domain.Timestamp {
+	positions := make(map[string]domain.Timestamp, len(c.pending))
+	for stream, ts := range c.pending {
+		positions[domain.StreamKey(name, stream)] = ts
+	}
+	return positions
+}
+
+// committed records that the positions reached by the batch have been committed, and returns
+// the last of them.
+func (c *streamCheckpoint) committed() domain.Timestamp {
+	var last domain.Timestamp
+	for _, ts := range c.pending {
+		if ts.After(last) {
+			last = ts
+		}
+	}
+	c.pending = make(map[string]domain.Timestamp)
+	return last
+}
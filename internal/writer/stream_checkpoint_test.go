@@ -0,0 +1,154 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/one2nc/mongo-oplog-to-sql/config"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/logging"
+	"github.com/one2nc/mongo-oplog-to-sql/internal/service"
+)
+
+func ts(t uint32) domain.Timestamp {
+	return domain.Timestamp{T: t, I: 1}
+}
+
+func TestStreamCheckpointObserve(t *testing.T) {
+	// oplogs 1 and 3 are in the stream of test.a, oplog 2 in the stream of test.b, which
+	// publishes oplog 2 before test.a publishes oplog 1
+	commands := []domain.SQLCommand{
+		{Query: "INSERT INTO test.b ...", Timestamp: ts(2), Stream: "test.b"},
+		{Timestamp: ts(2), Stream: "test.b"},
+		{Query: "INSERT INTO test.a ...", Timestamp: ts(1), Stream: "test.a"},
+		{Query: "INSERT INTO test.a_items ...", Timestamp: ts(1), Stream: "test.a"},
+		{Timestamp: ts(1), Stream: "test.a"},
+		{Query: "INSERT INTO test.a ...", Timestamp: ts(3), Stream: "test.a"},
+		{Query: "INSERT INTO test.b ...", Timestamp: ts(4), Stream: "test.b"},
+		{Timestamp: ts(3), Stream: "test.a"},
+		{Timestamp: ts(4), Stream: "test.b"},
+	}
+	wantBoundary := []bool{false, true, false, false, true, false, false, false, true}
+
+	streams := newStreamCheckpoint(nil)
+	for i, sqlCmd := range commands {
+		if boundary := streams.observe(sqlCmd); boundary != wantBoundary[i] {
+			t.Errorf("Command %d does not match the expected result.\nWant: boundary %v\nGot: boundary %v", i, wantBoundary[i], boundary)
+		}
+	}
+
+	wantPositions := map[string]domain.Timestamp{"test/test.a": ts(3), "test/test.b": ts(4)}
+	if positions := streams.positions("test"); !reflect.DeepEqual(positions, wantPositions) {
+		t.Errorf("Stream positions do not match the expected result.\nWant: %v\nGot: %v", wantPositions, positions)
+	}
+	if last := streams.committed(); last != ts(4) || len(streams.pending) > 0 {
+		t.Errorf("Committed position does not match the expected result.\nWant: %s\nGot: %s", ts(4), last)
+	}
+}
+
+func TestStreamCheckpointSkip(t *testing.T) {
+	streams := newStreamCheckpoint(map[string]domain.Timestamp{"test.a": ts(8)})
+
+	tests := []struct {
+		name     string
+		sqlCmd   domain.SQLCommand
+		wantSkip bool
+	}{
+		{
+			name:     "Stream without checkpoint",
+			sqlCmd:   domain.SQLCommand{Query: "INSERT INTO test.b ...", Timestamp: ts(4), Stream: "test.b"},
+			wantSkip: false,
+		},
+		{
+			name:     "Before the stream checkpoint",
+			sqlCmd:   domain.SQLCommand{Query: "INSERT INTO test.a ...", Timestamp: ts(7), Stream: "test.a"},
+			wantSkip: true,
+		},
+		{
+			name:     "After the stream checkpoint",
+			sqlCmd:   domain.SQLCommand{Query: "INSERT INTO test.a ...", Timestamp: ts(9), Stream: "test.a"},
+			wantSkip: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := streams.skip(test.sqlCmd); got != test.wantSkip {
+				t.Errorf("Skip does not match the expected result.\nWant: %v\nGot: %v", test.wantSkip, got)
+			}
+		})
+	}
+}
+
+// TestStreamCheckpointInterleavedCollections checks that the watermark derived by the
+// coordinator from the positions of the batches committed on a boundary never runs ahead of
+// an oplog whose statements have not all reached the writer, as the collections of a
+// database are processed concurrently.
+func TestStreamCheckpointInterleavedCollections(t *testing.T) {
+	const count = 500
+
+	oplogChan := make(chan domain.OplogEntry)
+	go func() {
+		defer close(oplogChan)
+		for i := 1; i <= count; i++ {
+			// the documents of test.order hold an array, which takes more statements
+			oplog := fmt.Sprintf(`{"op": "i", "ns": "test.student", "ts": {"T": %d, "I": 1}, "o": {"_id": "%d", "name": "n"}}`, i, i)
+			if i%2 == 0 {
+				oplog = fmt.Sprintf(`{"op": "i", "ns": "test.order", "ts": {"T": %d, "I": 1}, "o": {"_id": "%d", "items": [{"sku": "a"}, {"sku": "b"}]}}`, i, i)
+			}
+			var entry domain.OplogEntry
+			if err := json.Unmarshal([]byte(oplog), &entry); err != nil {
+				panic(err)
+			}
+			oplogChan <- entry
+		}
+	}()
+
+	coordinator := domain.NewCheckpointCoordinator(domain.Timestamp{})
+	trackedChan := coordinator.Track(oplogChan, func(oplog domain.OplogEntry) string {
+		return domain.StreamKey(oplog.DatabaseName(), oplog.Namespace)
+	})
+
+	oplogService := service.NewOplogService(context.Background(), &stubUUIDGenerator{}, config.DefaultPipeline(), domain.NopMetrics{}, logging.NewNopLogger())
+	sqlStmts := oplogService.ProcessOplogsConcurrent(trackedChan, func() {})
+
+	for sqlStmt := range sqlStmts {
+		streams := newStreamCheckpoint(nil)
+		// published holds the oplogs whose statements have all reached the writer
+		published := make(map[domain.Timestamp]bool)
+		boundaries := 0
+		var watermark domain.Timestamp
+
+		for sqlCmd := range sqlStmt.GetChannel() {
+			boundary := streams.observe(sqlCmd)
+			if sqlCmd.IsCheckpoint() {
+				published[sqlCmd.Timestamp] = true
+			}
+			if !boundary {
+				continue
+			}
+			boundaries++
+
+			watermark = coordinator.Commit(streams.positions(sqlStmt.GetDBName()))
+			streams.committed()
+			for i := uint32(1); i <= count && !ts(i).After(watermark); i++ {
+				if !published[ts(i)] {
+					t.Fatalf("Watermark %s runs ahead of oplog %s, which is not applied yet", watermark, ts(i))
+				}
+			}
+		}
+
+		if watermark != ts(count) || boundaries == 0 {
+			t.Errorf("Final watermark does not match the expected result.\nWant: %s\nGot: %s", ts(count), watermark)
+		}
+	}
+}
+
+type stubUUIDGenerator struct{}
+
+func (g *stubUUIDGenerator) UUID() string {
+	return "stubbed-id"
+}
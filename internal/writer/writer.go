@@ -1,7 +1,13 @@
 package writer
 
-import "context"
+import (
+	"context"
 
+	"github.com/one2nc/mongo-oplog-to-sql/internal/domain"
+)
+
+// SQLWriter writes the SQL commands of a channel until it is closed or the context is done,
+// and returns the error which stopped it, if any.
 type SQLWriter interface {
-	WriteSQL(ctx context.Context, sqlChan <-chan string)
+	WriteSQL(ctx context.Context, sqlChan <-chan domain.SQLCommand) error
 }